@@ -0,0 +1,290 @@
+// Package client is a Go client for the API served by guardian-cc serve.
+//
+//	c := client.New("http://localhost:8080")
+//	s, err := c.Setter(ctx, "Rufus")
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/ThomasAdam/guardian-cc/api"
+)
+
+// Client talks to one guardian-cc server.
+type Client struct {
+	// BaseURL is the server's address, e.g. "http://localhost:8080".
+	BaseURL string
+	// HTTPClient makes the requests; http.DefaultClient if nil.
+	HTTPClient *http.Client
+	// Token, if set, is sent as a bearer token.  Query needs an
+	// analyst's, the admin methods an admin's, and the rest a reader's
+	// if the server's API is private.
+	Token string
+}
+
+// New returns a Client for the server at baseURL.
+func New(baseURL string) *Client {
+	return &Client{BaseURL: strings.TrimSuffix(baseURL, "/")}
+}
+
+// Error is returned when the server answers with a non-2xx status.
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("guardian-cc: %d %s", e.StatusCode, e.Message)
+}
+
+// Page selects a page of a list.  The zero value is the server's default
+// first page.
+type Page struct {
+	Offset int
+	Limit  int
+}
+
+func (p Page) values() url.Values {
+	v := url.Values{}
+	if p.Offset > 0 {
+		v.Set("offset", strconv.Itoa(p.Offset))
+	}
+	if p.Limit > 0 {
+		v.Set("limit", strconv.Itoa(p.Limit))
+	}
+	return v
+}
+
+// CrosswordFilter narrows Crosswords.  Empty fields match everything;
+// From and To are inclusive dates in YYYY-MM-DD form.
+type CrosswordFilter struct {
+	Setter string
+	Type   string
+	From   string
+	To     string
+}
+
+// Setters returns a page of setters, by name.
+func (c *Client) Setters(ctx context.Context, page Page) (*api.List[api.Setter], error) {
+	return get[api.List[api.Setter]](ctx, c, "/api/setters", page.values())
+}
+
+// Setter returns a setter's totals, years and longest streaks.
+func (c *Client) Setter(ctx context.Context, name string) (*api.SetterDetail, error) {
+	return get[api.SetterDetail](ctx, c, "/api/setters/"+url.PathEscape(name), nil)
+}
+
+// Crosswords returns a page of the crosswords matching f, oldest first.
+func (c *Client) Crosswords(ctx context.Context, f CrosswordFilter, page Page) (*api.List[api.Crossword], error) {
+	v := page.values()
+	for k, s := range map[string]string{"setter": f.Setter, "type": f.Type, "from": f.From, "to": f.To} {
+		if s != "" {
+			v.Set(k, s)
+		}
+	}
+	return get[api.List[api.Crossword]](ctx, c, "/api/crosswords", v)
+}
+
+// Crossword returns a crossword and its entries.  id is the crossword's
+// path, e.g. "crosswords/cryptic/21625".
+func (c *Client) Crossword(ctx context.Context, id string) (*api.CrosswordDetail, error) {
+	var parts []string
+	for _, p := range strings.Split(id, "/") {
+		parts = append(parts, url.PathEscape(p))
+	}
+	return get[api.CrosswordDetail](ctx, c, "/api/crosswords/"+strings.Join(parts, "/"), nil)
+}
+
+// AnswerClues returns a page of the clues given for an answer, oldest
+// first.
+func (c *Client) AnswerClues(ctx context.Context, solution string, page Page) (*api.List[api.AnswerClue], error) {
+	return get[api.List[api.AnswerClue]](ctx, c, "/api/answers/"+url.PathEscape(solution), page.values())
+}
+
+// AnswerSearch is a pattern for SearchAnswers, e.g. Pattern "?A?B??E" with
+// Enumeration "3,4".  Either may be empty but not both.  Examples is how
+// many example clues to return per answer; 0 leaves it to the server.
+type AnswerSearch struct {
+	Pattern     string
+	Enumeration string
+	Examples    int
+}
+
+// SearchAnswers returns a page of the answers fitting s, most used first.
+func (c *Client) SearchAnswers(ctx context.Context, s AnswerSearch, page Page) (*api.List[api.AnswerMatch], error) {
+	v := page.values()
+	if s.Pattern != "" {
+		v.Set("pattern", s.Pattern)
+	}
+	if s.Enumeration != "" {
+		v.Set("enum", s.Enumeration)
+	}
+	if s.Examples > 0 {
+		v.Set("examples", strconv.Itoa(s.Examples))
+	}
+	return get[api.List[api.AnswerMatch]](ctx, c, "/api/search/answers", v)
+}
+
+// AnagramSearch is a query for SearchAnagrams: the letters to rearrange,
+// optionally narrowed by a pattern and enumeration as in AnswerSearch.
+type AnagramSearch struct {
+	Letters     string
+	Pattern     string
+	Enumeration string
+	Examples    int
+}
+
+// SearchAnagrams returns a page of the answers that are anagrams of
+// s.Letters, most used first.
+func (c *Client) SearchAnagrams(ctx context.Context, s AnagramSearch, page Page) (*api.List[api.AnswerMatch], error) {
+	v := page.values()
+	v.Set("letters", s.Letters)
+	if s.Pattern != "" {
+		v.Set("pattern", s.Pattern)
+	}
+	if s.Enumeration != "" {
+		v.Set("enum", s.Enumeration)
+	}
+	if s.Examples > 0 {
+		v.Set("examples", strconv.Itoa(s.Examples))
+	}
+	return get[api.List[api.AnswerMatch]](ctx, c, "/api/search/anagrams", v)
+}
+
+// ClueSearch is a query for SearchClues: words and "quoted phrases" that
+// must all be in the clue.  The other fields narrow the search when set;
+// From and To are inclusive dates in YYYY-MM-DD form, and Sort is
+// relevance (the default), newest or oldest.
+type ClueSearch struct {
+	Query  string
+	Setter string
+	Type   string
+	From   string
+	To     string
+	Length int
+	Sort   string
+}
+
+// SearchClues returns a page of the clues matching s.
+func (c *Client) SearchClues(ctx context.Context, s ClueSearch, page Page) (*api.List[api.ClueHit], error) {
+	v := page.values()
+	v.Set("q", s.Query)
+	for k, p := range map[string]string{"setter": s.Setter, "type": s.Type, "from": s.From, "to": s.To, "sort": s.Sort} {
+		if p != "" {
+			v.Set(k, p)
+		}
+	}
+	if s.Length > 0 {
+		v.Set("length", strconv.Itoa(s.Length))
+	}
+	return get[api.List[api.ClueHit]](ctx, c, "/api/search/clues", v)
+}
+
+// ChartData returns the data behind a chart section, e.g. "1" or "5a".
+func (c *Client) ChartData(ctx context.Context, order string) (*api.ChartData, error) {
+	return get[api.ChartData](ctx, c, "/api/charts/"+url.PathEscape(order)+"/data", url.Values{"format": {"json"}})
+}
+
+// DataTable runs a DataTables server-side processing request for table
+// (chart5, chart5a or chart6).  params holds the DataTables parameters,
+// e.g. start, length and search[value].
+func (c *Client) DataTable(ctx context.Context, table string, params url.Values) (*api.DataTablesResponse, error) {
+	v := url.Values{}
+	for k, vs := range params {
+		v[k] = vs
+	}
+	v.Set("table", table)
+	return get[api.DataTablesResponse](ctx, c, "/api/dt", v)
+}
+
+// Query runs an ad-hoc SELECT and returns up to limit of its rows, or as
+// many as the server allows if limit is 0.  c.Token must be an analyst's.
+func (c *Client) Query(ctx context.Context, q string, limit int) (*api.QueryResult, error) {
+	v := url.Values{"q": {q}}
+	if limit > 0 {
+		v.Set("limit", strconv.Itoa(limit))
+	}
+	return get[api.QueryResult](ctx, c, "/api/query", v)
+}
+
+// UploadCrosswords imports crosswords into the server's archive.  body
+// holds one or more crosswords in the scraper's JSON format, either as an
+// array or one after the other.  c.Token must be an admin's.
+func (c *Client) UploadCrosswords(ctx context.Context, body io.Reader) (*api.ImportResult, error) {
+	return do[api.ImportResult](ctx, c, http.MethodPost, "/api/admin/crosswords", nil, body)
+}
+
+// Jobs lists the server's background jobs, newest first.  c.Token must
+// be an admin's.
+func (c *Client) Jobs(ctx context.Context, page Page) (*api.List[api.Job], error) {
+	return get[api.List[api.Job]](ctx, c, "/api/admin/jobs", page.values())
+}
+
+// SubmitJob queues a background job: import, refresh or render.  c.Token
+// must be an admin's.
+func (c *Client) SubmitJob(ctx context.Context, kind string) (*api.Job, error) {
+	return do[api.Job](ctx, c, http.MethodPost, "/api/admin/jobs", url.Values{"kind": {kind}}, nil)
+}
+
+// Job fetches a background job with its output so far.  c.Token must be
+// an admin's.
+func (c *Client) Job(ctx context.Context, id int64) (*api.Job, error) {
+	return get[api.Job](ctx, c, "/api/admin/jobs/"+strconv.FormatInt(id, 10), nil)
+}
+
+// get fetches path with the query q and decodes the JSON response.
+func get[T any](ctx context.Context, c *Client, path string, q url.Values) (*T, error) {
+	return do[T](ctx, c, http.MethodGet, path, q, nil)
+}
+
+// do sends a request, with body as JSON if it is set, and decodes the
+// JSON response.
+func do[T any](ctx context.Context, c *Client, method, path string, q url.Values, body io.Reader) (*T, error) {
+	u := c.BaseURL + path
+	if len(q) > 0 {
+		u += "?" + q.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+
+	hc := c.HTTPClient
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	resp, err := hc.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		msg := strings.TrimSpace(string(body))
+		var ae api.Error
+		if json.Unmarshal(body, &ae) == nil && ae.Error != "" {
+			msg = ae.Error
+		}
+		return nil, &Error{StatusCode: resp.StatusCode, Message: msg}
+	}
+	v := new(T)
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return nil, fmt.Errorf("decoding %s: %w", path, err)
+	}
+	return v, nil
+}
@@ -0,0 +1,450 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// param is a query or path parameter of an operation.
+type param struct {
+	name, in, typ, description string
+	required                   bool
+}
+
+// operation is one endpoint.  response is a value of the Go type the
+// endpoint encodes as JSON; text lists the media types of any text
+// formats (e.g. CSV) it can send instead.
+type operation struct {
+	path, summary, description string
+	params                     []param
+	response                   any
+	text                       []string
+	errors                     []int
+	// auth is set for endpoints that need a bearer token.
+	auth bool
+	// method is the operation's HTTP method; GET if empty.  body, if
+	// set, describes the JSON request body.
+	method string
+	body   string
+	// status is the status of a successful response; 200 if zero.
+	// errorNotes replaces errorDescriptions' text for some errors.
+	status     int
+	errorNotes map[int]string
+}
+
+func query(name, typ, description string) param {
+	return param{name: name, in: "query", typ: typ, description: description}
+}
+
+func path(name, description string) param {
+	return param{name: name, in: "path", typ: "string", description: description, required: true}
+}
+
+var pageParams = []param{
+	query("offset", "integer", "Number of items to skip.  Defaults to 0."),
+	query("limit", "integer", "Number of items to return, from 1 to 1000.  Defaults to 50."),
+}
+
+// operations lists every endpoint under /api, in the order they appear
+// in the document.
+var operations = []operation{
+	{
+		path:    "/api/dt",
+		summary: "Page, sort and search a table for DataTables",
+		description: "DataTables server-side processing for the tables of charts 5, 5a and 6.  " +
+			"Besides the parameters below, DataTables' columns[i][search][value], " +
+			"columns[i][search][regex], columns[i][searchable], columns[i][orderable], " +
+			"order[i][column] and order[i][dir] are honoured.  Errors are plain text.",
+		params: []param{
+			{name: "table", in: "query", typ: "string", description: "Table name: chart5, chart5a or chart6.", required: true},
+			query("draw", "integer", "Echoed back in the response."),
+			query("start", "integer", "Index of the first row to return."),
+			query("length", "integer", "Number of rows to return, at most 1000."),
+			query("search[value]", "string", "Search across all searchable columns; space-separated terms must all match."),
+			query("search[regex]", "boolean", "Treat search[value] as a regular expression."),
+			query("format", "string", "Export every matching row, ignoring start and length, as csv, tsv or jsonl "+
+				"(JSON Lines).  Links are exported as plain values: crossword numbers, URLs and PDF URLs."),
+		},
+		response:   DataTablesResponse{},
+		text:       []string{"text/csv", "text/tab-separated-values", "application/x-ndjson"},
+		errors:     []int{400, 503},
+		errorNotes: map[int]string{503: "The database is busy, e.g. with a render job; try again after Retry-After seconds"},
+	},
+	{
+		path:    "/api/charts/{order}/data",
+		summary: "Data behind a chart section",
+		params: []param{
+			path("order", "Section number, e.g. 1 or 5a."),
+			query("format", "string", "csv (the default) or json."),
+		},
+		response: ChartData{},
+		text:     []string{"text/csv"},
+		errors:   []int{400, 404},
+	},
+	{
+		path:     "/api/setters",
+		summary:  "Every setter, by name",
+		params:   pageParams,
+		response: List[Setter]{},
+		errors:   []int{400},
+	},
+	{
+		path:     "/api/setters/{name}",
+		summary:  "A setter's totals, years and longest streaks",
+		params:   []param{path("name", "Setter name, e.g. Rufus.")},
+		response: SetterDetail{},
+		errors:   []int{404},
+	},
+	{
+		path:    "/api/crosswords",
+		summary: "Crosswords, oldest first",
+		params: append([]param{
+			query("setter", "string", "Only crosswords by this setter."),
+			query("type", "string", "Only crosswords of this type, e.g. cryptic or prize."),
+			query("from", "string", "Only crosswords published on or after this date (YYYY-MM-DD)."),
+			query("to", "string", "Only crosswords published on or before this date (YYYY-MM-DD)."),
+		}, pageParams...),
+		response: List[Crossword]{},
+		errors:   []int{400},
+	},
+	{
+		path:     "/api/crosswords/{id}",
+		summary:  "A crossword and its entries",
+		params:   []param{path("id", "Crossword path, e.g. crosswords/cryptic/21625.  The slashes need not be escaped.")},
+		response: CrosswordDetail{},
+		errors:   []int{404},
+	},
+	{
+		path:     "/api/answers/{solution}",
+		summary:  "Every clue given for an answer, oldest first",
+		params:   append([]param{path("solution", "The answer; case, spaces and punctuation are ignored.")}, pageParams...),
+		response: List[AnswerClue]{},
+		errors:   []int{400},
+	},
+	{
+		path:    "/api/search/answers",
+		summary: "Answers fitting a pattern, most used first",
+		description: "Finds every answer in the archive that fits a pattern of known and unknown letters, " +
+			"ranked by how often it has been used, with its most recent clues.  When an enumeration is given " +
+			"(or the pattern marks word breaks), answers whose clues give a different one are left out.",
+		params: append([]param{
+			query("pattern", "string", "Letters, with ? (or . or _) for each unknown and a space or - between words, e.g. ?A?B??E or ?A? ?B?E."),
+			query("enum", "string", "Enumeration, e.g. 3,4 or 1-5.  Must add up to the pattern's length; with no pattern, any answer with these word lengths matches."),
+			query("examples", "integer", "Example clues per answer, at most 10 (default 3)."),
+		}, pageParams...),
+		response: List[AnswerMatch]{},
+		errors:   []int{400},
+	},
+	{
+		path:    "/api/search/anagrams",
+		summary: "Answers that are anagrams of some letters",
+		description: "Finds every answer in the archive made of exactly the given letters, including answers spread over " +
+			"several lights, ranked and illustrated as /api/search/answers does.  A pattern or enumeration narrows the search.",
+		params: append([]param{
+			{name: "letters", in: "query", typ: "string", description: "The letters to rearrange; anything else is ignored.", required: true},
+			query("pattern", "string", "Letters already known, as for /api/search/answers; must be as long as letters."),
+			query("enum", "string", "Enumeration, e.g. 3,4; must add up to the number of letters."),
+			query("examples", "integer", "Example clues per answer, at most 10 (default 3)."),
+		}, pageParams...),
+		response: List[AnswerMatch]{},
+		errors:   []int{400, 503},
+	},
+	{
+		path:    "/api/search/clues",
+		summary: "Clues containing words and phrases",
+		description: "Full-text search over clue text, using an index the importer keeps.  Every word must be in the clue, " +
+			"and the words of a \"quoted phrase\" must be in it in order; case and punctuation are ignored.  " +
+			"Each hit's snippet marks the matching words with <mark>.",
+		params: append([]param{
+			{name: "q", in: "query", typ: "string", description: "Words and \"quoted phrases\", e.g. \"in the bag\" cat.", required: true},
+			query("setter", "string", "Only clues by this setter."),
+			query("type", "string", "Only clues from this crossword type, e.g. cryptic or prize."),
+			query("from", "string", "Only crosswords published on or after this date (YYYY-MM-DD)."),
+			query("to", "string", "Only crosswords published on or before this date (YYYY-MM-DD)."),
+			query("length", "integer", "Only clues whose answer has this many letters."),
+			query("sort", "string", "relevance (the default), newest or oldest."),
+		}, pageParams...),
+		response: List[ClueHit]{},
+		errors:   []int{400, 503},
+	},
+	{
+		path:    "/api/admin/crosswords",
+		method:  "POST",
+		summary: "Import crosswords",
+		description: "Imports crosswords pushed by a scraper.  Each is checked, written into the archive at " +
+			"crosswords/<type>/setter/<setter>/<number>.JSON and imported.  If any is invalid, or is already " +
+			"imported from a file that differs from it, none is imported.  Crosswords already in the database " +
+			"are otherwise left alone.  Needs an admin's token; only served when serve has a tokens file.",
+		body:       "A crossword in the scraper's JSON format, an array of them, or several one after the other.",
+		response:   ImportResult{},
+		errors:     []int{400, 401, 403, 409, 413},
+		errorNotes: map[int]string{409: "A crossword is already imported, and the upload is not the file it was imported from"},
+		auth:       true,
+	},
+	{
+		path:    "/api/admin/jobs",
+		summary: "List background jobs",
+		description: "The history of import, refresh and render jobs, newest first, without their output.  " +
+			"The latest 500 are kept.  Needs an admin's token; only served when serve has a tokens file.",
+		params:   pageParams,
+		response: List[Job]{},
+		errors:   []int{400, 401, 403},
+		auth:     true,
+	},
+	{
+		path:    "/api/admin/jobs",
+		method:  "POST",
+		summary: "Start a background job",
+		description: "Queues a job, which runs once those before it have finished, and returns it; its " +
+			"Location header is the job's URL.  import imports the archive as the import command does, " +
+			"render renders the analysis page as the render command does, and refresh does both.  The kind " +
+			"may also be POSTed as a form.  Needs an admin's token; only served when serve has a tokens file.",
+		params: []param{
+			{name: "kind", in: "query", typ: "string", description: "import, refresh or render.", required: true},
+		},
+		response:   Job{},
+		status:     202,
+		errors:     []int{400, 401, 403, 503},
+		errorNotes: map[int]string{503: "Too many jobs are queued"},
+		auth:       true,
+	},
+	{
+		path:    "/api/admin/jobs/{id}",
+		summary: "A background job",
+		description: "A job with its output so far.  Only the last 2000 lines of a job's output are kept.  " +
+			"Needs an admin's token; only served when serve has a tokens file.",
+		params:   []param{path("id", "The job's ID.")},
+		response: Job{},
+		errors:   []int{401, 403, 404},
+		auth:     true,
+	},
+	{
+		path:    "/api/admin/jobs/{id}/events",
+		summary: "Follow a background job",
+		description: "A job's output as Server-Sent Events, one data event per line with the line's number as " +
+			"its id, from the line after any Last-Event-ID.  Once the job has finished, a done event carries " +
+			"the job as JSON and the stream ends.  Needs an admin's token; only served when serve has a " +
+			"tokens file.",
+		params: []param{path("id", "The job's ID.")},
+		text:   []string{"text/event-stream"},
+		errors: []int{401, 403, 404},
+		auth:   true,
+	},
+	{
+		path:     "/api/openapi.json",
+		summary:  "This document",
+		response: map[string]any{},
+	},
+	{
+		path:    "/api/query",
+		summary: "Run an ad-hoc read-only query",
+		description: "Runs a single SELECT against the live database and returns its first rows.  Only the tables " +
+			"answers, clue_docs, clue_terms, crosswords, entries and resolved_entries (and the query's own CTEs) " +
+			"may be read, table functions may not be used, and only DuckDB's deterministic built-in functions " +
+			"may be called.  Queries are stopped after 30 seconds.  The parameters may also be POSTed as a " +
+			"form.  Needs an analyst's token; only served when serve has a tokens file.",
+		params: []param{
+			{name: "q", in: "query", typ: "string", description: "The SELECT statement.", required: true},
+			query("format", "string", "json (the default) or csv."),
+			query("limit", "integer", "Rows to return, from 1 to 10000.  Defaults to 10000."),
+		},
+		response:   QueryResult{},
+		text:       []string{"text/csv"},
+		errors:     []int{400, 401, 403, 503},
+		errorNotes: map[int]string{503: "The query took longer than 30 seconds; try again after Retry-After seconds"},
+		auth:       true,
+	},
+}
+
+// OpenAPI returns the OpenAPI 3 description of the server's API.  The
+// schemas are derived from this package's types, so the document always
+// matches what the server sends.
+func OpenAPI() ([]byte, error) {
+	g := &schemaGen{components: make(map[string]any)}
+	paths := make(map[string]any)
+	for _, op := range operations {
+		var params []any
+		for _, p := range op.params {
+			params = append(params, map[string]any{
+				"name":        p.name,
+				"in":          p.in,
+				"required":    p.required,
+				"description": p.description,
+				"schema":      map[string]any{"type": p.typ},
+			})
+		}
+
+		content := make(map[string]any)
+		if op.response != nil {
+			content["application/json"] = map[string]any{"schema": g.schema(reflect.TypeOf(op.response))}
+		}
+		for _, typ := range op.text {
+			content[typ] = map[string]any{"schema": map[string]any{"type": "string"}}
+		}
+		status := op.status
+		if status == 0 {
+			status = 200
+		}
+		responses := map[string]any{strconv.Itoa(status): map[string]any{"description": http.StatusText(status), "content": content}}
+		for _, code := range op.errors {
+			desc, ok := op.errorNotes[code]
+			if !ok {
+				desc = errorDescriptions[code]
+			}
+			resp := map[string]any{"description": desc}
+			if op.path != "/api/dt" {
+				resp["content"] = map[string]any{"application/json": map[string]any{"schema": g.schema(reflect.TypeOf(Error{}))}}
+			}
+			responses[strconv.Itoa(code)] = resp
+		}
+
+		get := map[string]any{
+			"summary":   op.summary,
+			"responses": responses,
+		}
+		if op.description != "" {
+			get["description"] = op.description
+		}
+		if params != nil {
+			get["parameters"] = params
+		}
+		if op.auth {
+			get["security"] = []any{map[string]any{"bearer": []string{}}}
+		}
+		if op.body != "" {
+			get["requestBody"] = map[string]any{
+				"description": op.body,
+				"required":    true,
+				"content":     map[string]any{"application/json": map[string]any{"schema": map[string]any{}}},
+			}
+		}
+		method := "get"
+		if op.method != "" {
+			method = strings.ToLower(op.method)
+		}
+		// Operations on the same path share its entry.
+		item, _ := paths[op.path].(map[string]any)
+		if item == nil {
+			item = make(map[string]any)
+			paths[op.path] = item
+		}
+		item[method] = get
+	}
+
+	doc := map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":       "guardian-cc",
+			"description": apiDescription,
+			"version":     "1",
+		},
+		"paths": paths,
+		"components": map[string]any{
+			"schemas": g.components,
+			"securitySchemes": map[string]any{
+				"bearer": map[string]any{"type": "http", "scheme": "bearer"},
+			},
+		},
+	}
+	return json.MarshalIndent(doc, "", "  ")
+}
+
+// apiDescription introduces the document.
+const apiDescription = "Access to the Guardian cryptic crossword database behind guardian-cc serve.  " +
+	"Tokens have a role: reader, analyst or admin.  If serve's tokens file makes the API private, " +
+	"every endpoint needs at least a reader's token, as does /metrics."
+
+var errorDescriptions = map[int]string{
+	400: "Invalid parameters",
+	401: "Missing or invalid token",
+	403: "The token's role does not allow this",
+	413: "Request body too large",
+	404: "Not found",
+	503: "Not available until the search indexes have been rebuilt by an import",
+}
+
+// schemaGen builds JSON schemas from Go types, following encoding/json's
+// rules for field names, omitempty and embedded structs.  Named structs
+// go into components and are referred to by $ref; instances of generic
+// types are written out in place.
+type schemaGen struct {
+	components map[string]any
+}
+
+func (g *schemaGen) schema(t reflect.Type) map[string]any {
+	switch t.Kind() {
+	case reflect.Pointer:
+		s := g.schema(t.Elem())
+		if r, ok := s["$ref"]; ok {
+			// $ref siblings are ignored in OpenAPI 3.0.
+			return map[string]any{"allOf": []any{map[string]any{"$ref": r}}, "nullable": true}
+		}
+		s["nullable"] = true
+		return s
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": g.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": g.schema(t.Elem())}
+	case reflect.Interface:
+		return map[string]any{}
+	case reflect.Struct:
+		name := t.Name()
+		if strings.Contains(name, "[") {
+			return g.object(t)
+		}
+		if _, ok := g.components[name]; !ok {
+			g.components[name] = nil // guards against recursion
+			g.components[name] = g.object(t)
+		}
+		return map[string]any{"$ref": "#/components/schemas/" + name}
+	}
+	panic("api: no schema for " + t.String())
+}
+
+// object returns the schema of struct type t.
+func (g *schemaGen) object(t reflect.Type) map[string]any {
+	props := make(map[string]any)
+	var required []string
+	var walk func(reflect.Type)
+	walk = func(t reflect.Type) {
+		for i := range t.NumField() {
+			f := t.Field(i)
+			if f.Anonymous && f.Type.Kind() == reflect.Struct {
+				walk(f.Type)
+				continue
+			}
+			if !f.IsExported() {
+				continue
+			}
+			name, opts, _ := strings.Cut(f.Tag.Get("json"), ",")
+			if name == "-" {
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+			props[name] = g.schema(f.Type)
+			if !strings.Contains(opts, "omitempty") {
+				required = append(required, name)
+			}
+		}
+	}
+	walk(t)
+	s := map[string]any{"type": "object", "properties": props}
+	if required != nil {
+		s["required"] = required
+	}
+	return s
+}
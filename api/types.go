@@ -0,0 +1,215 @@
+// Package api defines the JSON documents served by guardian-cc serve, and
+// the OpenAPI description of its endpoints.  The server encodes these
+// types and package client decodes them, so the two cannot drift apart.
+package api
+
+// DataTablesResponse is the reply to /api/dt, in the DataTables
+// server-side processing format.  Data holds one page of rows as the
+// table shows them, with links already rendered as HTML.
+type DataTablesResponse struct {
+	Draw            int        `json:"draw"`
+	RecordsTotal    int        `json:"recordsTotal"`
+	RecordsFiltered int        `json:"recordsFiltered"`
+	Data            [][]string `json:"data"`
+}
+
+// ChartData is the data behind a chart section, as served by
+// /api/charts/{order}/data?format=json.  Each row has one value (a
+// string, a number or, for the DataTables sections, a list of strings)
+// per column, without any HTML.
+type ChartData struct {
+	Columns []string `json:"columns"`
+	Rows    [][]any  `json:"rows"`
+}
+
+// List is one page of a list endpoint's items.
+type List[T any] struct {
+	Data []T  `json:"data"`
+	Page Page `json:"page"`
+}
+
+// Page describes where a List sits in the full result.  Next is the URL
+// of the following page, if there is one.
+type Page struct {
+	Offset int    `json:"offset"`
+	Limit  int    `json:"limit"`
+	Total  int    `json:"total"`
+	Next   string `json:"next,omitempty"`
+}
+
+// Error is the body of every JSON API error response.
+type Error struct {
+	Error string `json:"error"`
+}
+
+// Setter is an entry in /api/setters.  Dates are YYYY-MM-DD.
+type Setter struct {
+	Name       string `json:"name"`
+	Crosswords int    `json:"crosswords"`
+	First      string `json:"first"`
+	Last       string `json:"last"`
+}
+
+// SetterDetail is served by /api/setters/{name}.  Types counts the
+// setter's crosswords by crossword type.
+type SetterDetail struct {
+	Setter
+	Types   map[string]int `json:"types"`
+	Years   []YearCount    `json:"years"`
+	Streaks Streaks        `json:"streaks"`
+}
+
+// YearCount is the number of crosswords a setter published in a year.
+type YearCount struct {
+	Year       int `json:"year"`
+	Crosswords int `json:"crosswords"`
+}
+
+// Streaks holds a setter's longest runs of consecutive weeks and months
+// with at least one crossword, as charts 13 and 14 show.
+type Streaks struct {
+	Weeks  *Streak `json:"weeks"`
+	Months *Streak `json:"months"`
+}
+
+// Streak is a run of consecutive periods.  Start and End are the dates of
+// the first and last crosswords in it.
+type Streak struct {
+	Length     int    `json:"length"`
+	Start      string `json:"start"`
+	End        string `json:"end"`
+	Crosswords int    `json:"crosswords"`
+}
+
+// Crossword is an entry in /api/crosswords.  ID is the crossword's path,
+// e.g. "crosswords/cryptic/21625".
+type Crossword struct {
+	ID        string `json:"id"`
+	Number    string `json:"number"`
+	Name      string `json:"name"`
+	Setter    string `json:"setter"`
+	SetterURL string `json:"setter_url"`
+	Date      string `json:"date"`
+	Type      string `json:"type"`
+	PDF       string `json:"pdf"`
+}
+
+// CrosswordDetail is served by /api/crosswords/{id}.
+type CrosswordDetail struct {
+	Crossword
+	Entries []Entry `json:"entries"`
+}
+
+// Entry is a light in a crossword, with its clue as published.
+type Entry struct {
+	ID          string `json:"id"`
+	Number      int    `json:"number"`
+	HumanNumber string `json:"human_number"`
+	Clue        string `json:"clue"`
+	Direction   string `json:"direction"`
+	Length      int    `json:"length"`
+	Solution    string `json:"solution"`
+	X           int    `json:"x"`
+	Y           int    `json:"y"`
+}
+
+// AnswerClue is an entry in /api/answers/{solution}: one use of the
+// answer, with cross-references ("See 5") resolved to the clue they
+// point at.
+type AnswerClue struct {
+	Clue      string `json:"clue"`
+	Solution  string `json:"solution"`
+	Entry     string `json:"entry"`
+	Crossword string `json:"crossword"`
+	Number    string `json:"number"`
+	Setter    string `json:"setter"`
+	Date      string `json:"date"`
+	Type      string `json:"type"`
+}
+
+// AnswerMatch is an entry in /api/search/answers: an answer that fits the
+// pattern, with how often it has been used and the most recent clues for
+// it.  Enumeration is the answer's most common word lengths, e.g. "3,4",
+// or empty if no clue for it gives them.
+type AnswerMatch struct {
+	Answer      string       `json:"answer"`
+	Enumeration string       `json:"enumeration"`
+	Uses        int          `json:"uses"`
+	Setters     int          `json:"setters"`
+	Examples    []AnswerClue `json:"examples"`
+}
+
+// ClueHit is an entry in /api/search/clues: a clue matching the query.
+// Clue is its plain text; Highlights are the byte ranges of the words in
+// it that matched, and Snippet is the clue (cut short if long) as HTML
+// with those words in <mark>.  Score is the clue's relevance.
+type ClueHit struct {
+	Clue       string   `json:"clue"`
+	Snippet    string   `json:"snippet"`
+	Highlights [][2]int `json:"highlights"`
+	Solution   string   `json:"solution"`
+	Entry      string   `json:"entry"`
+	Crossword  string   `json:"crossword"`
+	Number     string   `json:"number"`
+	Setter     string   `json:"setter"`
+	Date       string   `json:"date"`
+	Type       string   `json:"type"`
+	URL        string   `json:"url"`
+	Score      float64  `json:"score"`
+}
+
+// QueryResult is the reply to /api/query: the columns of an ad-hoc query
+// and its first rows, one value per column.  Dates and times are given as
+// text.  Truncated is set if the query had more rows than were returned.
+type QueryResult struct {
+	Columns   []QueryColumn `json:"columns"`
+	Rows      [][]any       `json:"rows"`
+	Truncated bool          `json:"truncated"`
+}
+
+// QueryColumn is a column of a QueryResult, with its DuckDB type.
+type QueryColumn struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// ImportResult is the reply to POST /api/admin/crosswords: how many of
+// the uploaded crosswords were new to the database and how many could not
+// be imported, and where each was filed in the archive.
+type ImportResult struct {
+	Added      int                 `json:"added"`
+	Failed     int                 `json:"failed"`
+	Crosswords []ImportedCrossword `json:"crosswords"`
+}
+
+// ImportedCrossword is an uploaded crossword.  Path is its file in the
+// archive, relative to the server's directory.  Added is false for a
+// crossword already in the database (or one that failed to import).
+type ImportedCrossword struct {
+	ID    string `json:"id"`
+	Path  string `json:"path"`
+	Added bool   `json:"added"`
+}
+
+// Job is a background job run by serve, as served by /api/admin/jobs.
+// Kind is import, refresh or render, and State queued, running,
+// succeeded or failed.  Times are RFC 3339.  Output, which only a single
+// job's document has, is its progress so far, one line per element;
+// Dropped is how many lines before those were not kept.
+type Job struct {
+	ID       int64    `json:"id"`
+	Kind     string   `json:"kind"`
+	State    string   `json:"state"`
+	Created  string   `json:"created"`
+	Started  string   `json:"started,omitempty"`
+	Finished string   `json:"finished,omitempty"`
+	Error    string   `json:"error,omitempty"`
+	Output   []string `json:"output,omitempty"`
+	Dropped  int      `json:"dropped,omitempty"`
+}
+
+// CrosswordURL returns the Guardian's page for the crossword with the
+// given ID, e.g. "crosswords/cryptic/21625".
+func CrosswordURL(id string) string {
+	return "https://www.theguardian.com/" + id
+}
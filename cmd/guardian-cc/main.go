@@ -1,23 +1,80 @@
 package main
 
 import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
 	"fmt"
+	"log/slog"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+	"unicode/utf8"
 
+	"github.com/ThomasAdam/guardian-cc/api"
+	"github.com/ThomasAdam/guardian-cc/internal/auth"
 	"github.com/ThomasAdam/guardian-cc/internal/charts"
 	"github.com/ThomasAdam/guardian-cc/internal/db"
 	"github.com/ThomasAdam/guardian-cc/internal/importer"
+	"github.com/ThomasAdam/guardian-cc/internal/metrics"
+	"github.com/ThomasAdam/guardian-cc/internal/search"
 	"github.com/ThomasAdam/guardian-cc/internal/server"
 )
 
 func usage() {
 	fmt.Fprintf(os.Stderr, "Usage: guardian-cc <command> [args...]\n\n")
 	fmt.Fprintf(os.Stderr, "Commands:\n")
-	fmt.Fprintf(os.Stderr, "  import [file.JSON ...]  Import crossword JSON files into DuckDB\n")
+	fmt.Fprintf(os.Stderr, "  import [flags] [file.JSON ...]\n")
+	fmt.Fprintf(os.Stderr, "                          Import crossword JSON files into DuckDB\n")
 	fmt.Fprintf(os.Stderr, "                          With no args, imports all files under crosswords/\n")
-	fmt.Fprintf(os.Stderr, "  render                  Render charts to gcc-analysis.html\n")
-	fmt.Fprintf(os.Stderr, "  serve [addr]            Serve the analysis page with server-side pagination\n")
+	fmt.Fprintf(os.Stderr, "                          -metrics-file path: write Prometheus metrics for node_exporter\n")
+	fmt.Fprintf(os.Stderr, "  render [flags]          Render charts to gcc-analysis.html\n")
+	fmt.Fprintf(os.Stderr, "                          -templates dir: files in dir override the built-in templates\n")
+	fmt.Fprintf(os.Stderr, "                          -format svg: write static SVG charts and page to -dir (default svg/)\n")
+	fmt.Fprintf(os.Stderr, "                          -backend vegalite: write Vega-Lite specs to -dir (default vegalite/)\n")
+	fmt.Fprintf(os.Stderr, "                          -as-of YYYY-MM-DD: render as of that date rather than now\n")
+	fmt.Fprintf(os.Stderr, "                          -reproducible: same DB, same output (as of the latest crossword\n")
+	fmt.Fprintf(os.Stderr, "                          unless -as-of is given)\n")
+	fmt.Fprintf(os.Stderr, "                          -force: re-render charts even if the DB and templates are unchanged\n")
+	fmt.Fprintf(os.Stderr, "                          -server-side: page the tables through serve's /api/dt\n")
+	fmt.Fprintf(os.Stderr, "                          -metrics-file path: write Prometheus metrics for node_exporter\n")
+	fmt.Fprintf(os.Stderr, "  serve [flags] [addr]    Render and serve the analysis page with server-side pagination\n")
 	fmt.Fprintf(os.Stderr, "                          Default addr is :8080\n")
+	fmt.Fprintf(os.Stderr, "                          -templates dir: files in dir override the built-in templates\n")
+	fmt.Fprintf(os.Stderr, "                          -access-log text|json|off: format of the per-request log on stderr\n")
+	fmt.Fprintf(os.Stderr, "                          -tokens path: API tokens (default ./tokens.json); if it exists,\n")
+	fmt.Fprintf(os.Stderr, "                          serve /api/query and the /query console to analysts, and\n")
+	fmt.Fprintf(os.Stderr, "                          crossword uploads and import/render jobs (/admin/jobs) to admins\n")
+	fmt.Fprintf(os.Stderr, "                          Stops gracefully on SIGINT or SIGTERM\n")
+	fmt.Fprintf(os.Stderr, "  tokens create [flags] name\n")
+	fmt.Fprintf(os.Stderr, "                          Create an API token for serve and print it\n")
+	fmt.Fprintf(os.Stderr, "                          -role reader|analyst|admin: what it may do (default reader)\n")
+	fmt.Fprintf(os.Stderr, "                          -file path: the tokens file (default ./tokens.json)\n")
+	fmt.Fprintf(os.Stderr, "  tokens revoke [-file path] name\n")
+	fmt.Fprintf(os.Stderr, "                          Revoke an API token; serve stops accepting it at once\n")
+	fmt.Fprintf(os.Stderr, "  tokens list [-file path]\n")
+	fmt.Fprintf(os.Stderr, "                          List the API tokens' names and roles\n")
+	fmt.Fprintf(os.Stderr, "  tokens private [-file path] on|off\n")
+	fmt.Fprintf(os.Stderr, "                          Make the JSON API, /api/dt, chart data and /metrics need\n")
+	fmt.Fprintf(os.Stderr, "                          a reader's token, or serve them to anyone again\n")
+	fmt.Fprintf(os.Stderr, "  search answers [flags] pattern\n")
+	fmt.Fprintf(os.Stderr, "                          List answers fitting a pattern such as ?A?B??E or ?A? ?B?E,\n")
+	fmt.Fprintf(os.Stderr, "                          most used first, with example clues\n")
+	fmt.Fprintf(os.Stderr, "                          -enum 3,4: only answers with this enumeration\n")
+	fmt.Fprintf(os.Stderr, "                          -limit n, -examples n: how many answers, and clues for each\n")
+	fmt.Fprintf(os.Stderr, "  search anagrams [flags] letters...\n")
+	fmt.Fprintf(os.Stderr, "                          List answers that are anagrams of the letters, most used first\n")
+	fmt.Fprintf(os.Stderr, "                          -pattern p, -enum 3,4: only answers fitting these too\n")
+	fmt.Fprintf(os.Stderr, "                          -limit n, -examples n: how many answers, and clues for each\n")
+	fmt.Fprintf(os.Stderr, "  search clues [flags] words...\n")
+	fmt.Fprintf(os.Stderr, "                          List clues containing every word and \"quoted phrase\"\n")
+	fmt.Fprintf(os.Stderr, "                          -setter name, -type cryptic|prize, -from/-to YYYY-MM-DD,\n")
+	fmt.Fprintf(os.Stderr, "                          -length n (answer letters): narrow the search\n")
+	fmt.Fprintf(os.Stderr, "                          -sort relevance|newest|oldest, -limit n\n")
 	os.Exit(1)
 }
 
@@ -30,63 +87,193 @@ func main() {
 	case "import":
 		runImport(os.Args[2:])
 	case "render":
-		runRender()
+		runRender(os.Args[2:])
 	case "serve":
-		addr := ":8080"
-		if len(os.Args) > 2 {
-			addr = os.Args[2]
-		}
-		runServe(addr)
+		runServe(os.Args[2:])
+	case "search":
+		runSearch(os.Args[2:])
+	case "tokens":
+		runTokens(os.Args[2:])
 	default:
 		fmt.Fprintf(os.Stderr, "Unknown command: %s\n", os.Args[1])
 		usage()
 	}
 }
 
-func runImport(files []string) {
+func runImport(args []string) {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	metricsFile := flags.String("metrics-file", "", "write Prometheus metrics for node_exporter's textfile collector to this file")
+	flags.Parse(args)
+	start := time.Now()
+
 	database, err := db.Open(db.DefaultDBFile)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error opening database: %v\n", err)
+		writeRunMetrics(*metricsFile, "import", nil, start, err, nil)
 		os.Exit(1)
 	}
 	defer database.Close()
 
 	if err := db.CreateSchema(database); err != nil {
 		fmt.Fprintf(os.Stderr, "Error creating schema: %v\n", err)
+		writeRunMetrics(*metricsFile, "import", nil, start, err, nil)
 		os.Exit(1)
 	}
 
-	if err := importer.Import(database, files); err != nil {
+	res, err := importer.Import(database, flags.Args(), os.Stdout)
+	writeRunMetrics(*metricsFile, "import", database, start, err, func(reg *metrics.Registry) {
+		files := reg.Gauge("gcc_import_files", "Files looked at by the last import, by outcome.", "result")
+		files.Set(float64(res.Added), "added")
+		files.Set(float64(res.Files-res.Added-res.Failed), "skipped")
+		files.Set(float64(res.Failed), "failed")
+	})
+	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error importing: %v\n", err)
 		os.Exit(1)
 	}
 }
 
-func runRender() {
+func runRender(args []string) {
+	flags := flag.NewFlagSet("render", flag.ExitOnError)
+	tmplDir := flags.String("templates", "", "directory whose files override the built-in templates")
+	format := flags.String("format", "html", "output format: html (c3 charts) or svg (static, no JavaScript)")
+	backend := flags.String("backend", "c3", "chart backend for -format html: c3 (page) or vegalite (spec files)")
+	outDir := flags.String("dir", "", "output directory for -format svg and -backend vegalite")
+	reproducible := flags.Bool("reproducible", false, "render as of -as-of (default: the latest crossword's date) for byte-identical output")
+	asOfFlag := flags.String("as-of", "", "render as of this date (YYYY-MM-DD) instead of now")
+	force := flags.Bool("force", false, "re-render every chart, ignoring the fragment cache")
+	serverSide := flags.Bool("server-side", false, "page the tables through serve's /api/dt rather than writing ajax files")
+	metricsFile := flags.String("metrics-file", "", "write Prometheus metrics for node_exporter's textfile collector to this file")
+	flags.Parse(args)
+	start := time.Now()
+
+	if *format != "html" && *format != "svg" {
+		fmt.Fprintf(os.Stderr, "Unknown format: %s\n", *format)
+		os.Exit(1)
+	}
+	if *backend != "c3" && *backend != "vegalite" {
+		fmt.Fprintf(os.Stderr, "Unknown backend: %s\n", *backend)
+		os.Exit(1)
+	}
+	if *format == "svg" && *backend != "c3" {
+		fmt.Fprintf(os.Stderr, "-backend cannot be used with -format svg\n")
+		os.Exit(1)
+	}
+	if *serverSide && (*format != "html" || *backend != "c3") {
+		fmt.Fprintf(os.Stderr, "-server-side only applies to the c3 page\n")
+		os.Exit(1)
+	}
+	if *outDir == "" {
+		*outDir = *format
+		if *backend == "vegalite" {
+			*outDir = "vegalite"
+		}
+	}
+
+	opts := charts.Options{Force: *force}
+	if *asOfFlag != "" {
+		t, err := time.Parse(time.DateOnly, *asOfFlag)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid -as-of date: %v\n", err)
+			os.Exit(1)
+		}
+		opts.AsOf = t
+	}
+
+	tmpls, err := charts.LoadTemplates(*tmplDir)
+	if err == nil && *serverSide {
+		tmpls, err = tmpls.ForServer()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading templates: %v\n", err)
+		writeRunMetrics(*metricsFile, "render", nil, start, err, nil)
+		os.Exit(1)
+	}
+
 	database, err := db.Open(db.DefaultDBFile)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error opening database: %v\n", err)
+		writeRunMetrics(*metricsFile, "render", nil, start, err, nil)
 		os.Exit(1)
 	}
 	defer database.Close()
 
 	if err := db.CreateSchema(database); err != nil {
 		fmt.Fprintf(os.Stderr, "Error creating schema: %v\n", err)
+		writeRunMetrics(*metricsFile, "render", nil, start, err, nil)
 		os.Exit(1)
 	}
 
-	tmplDir := "ui/chart_defs"
-	outputFile := "./gcc-analysis.html"
+	if *reproducible && opts.AsOf.IsZero() {
+		opts.AsOf, err = db.LatestDate(database)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			writeRunMetrics(*metricsFile, "render", nil, start, err, nil)
+			os.Exit(1)
+		}
+	}
 
-	if err := charts.RenderAll(database, tmplDir, outputFile); err != nil {
+	output := "./gcc-analysis.html"
+	switch {
+	case *format == "svg":
+		output = *outDir
+		err = charts.RenderStatic(database, tmpls, output, opts, os.Stderr)
+	case *backend == "vegalite":
+		output = *outDir
+		err = charts.RenderVegaLite(database, output, opts, os.Stderr)
+	default:
+		err = charts.RenderAll(database, tmpls, output, opts, os.Stderr)
+	}
+	writeRunMetrics(*metricsFile, "render", database, start, err, nil)
+	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error rendering charts: %v\n", err)
 		os.Exit(1)
 	}
 
-	fmt.Printf("Written: %s\n", outputFile)
+	fmt.Printf("Written: %s\n", output)
 }
 
-func runServe(addr string) {
+func runServe(args []string) {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	tmplDir := flags.String("templates", "", "directory whose files override the built-in templates")
+	accessLog := flags.String("access-log", "text", "access log format on stderr: text, json or off")
+	tokensFile := flags.String("tokens", auth.DefaultFile, "file of API tokens (see the tokens command) that authorize /api/query, uploads and jobs")
+	flags.Parse(args)
+
+	var opts server.Options
+	switch *accessLog {
+	case "text":
+		opts.AccessLog = slog.New(slog.NewTextHandler(os.Stderr, nil))
+	case "json":
+		opts.AccessLog = slog.New(slog.NewJSONHandler(os.Stderr, nil))
+	case "off":
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown access log format %q (want text, json or off)\n", *accessLog)
+		os.Exit(1)
+	}
+
+	tokens, err := auth.Open(*tokensFile)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		fmt.Fprintf(os.Stderr, "No %s: not serving /api/query, uploads or jobs\n", *tokensFile)
+	case err != nil:
+		fmt.Fprintf(os.Stderr, "Error reading tokens: %v\n", err)
+		os.Exit(1)
+	default:
+		opts.Tokens = tokens
+	}
+
+	addr := ":8080"
+	if flags.NArg() > 0 {
+		addr = flags.Arg(0)
+	}
+
+	tmpls, err := charts.LoadTemplates(*tmplDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading templates: %v\n", err)
+		os.Exit(1)
+	}
+
 	database, err := db.Open(db.DefaultDBFile)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error opening database: %v\n", err)
@@ -99,8 +286,298 @@ func runServe(addr string) {
 		os.Exit(1)
 	}
 
-	if err := server.Serve(database, addr); err != nil {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := server.Serve(ctx, database, tmpls, addr, opts); err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		os.Exit(1)
 	}
 }
+
+func runTokens(args []string) {
+	if len(args) < 1 {
+		usage()
+	}
+	if args[0] != "create" && args[0] != "revoke" && args[0] != "list" && args[0] != "private" {
+		fmt.Fprintf(os.Stderr, "Unknown tokens command: %s\n", args[0])
+		usage()
+	}
+	flags := flag.NewFlagSet("tokens "+args[0], flag.ExitOnError)
+	file := flags.String("file", auth.DefaultFile, "the tokens file")
+	var role *string
+	if args[0] == "create" {
+		role = flags.String("role", "reader", "what the token may do: reader, analyst or admin")
+	}
+	flags.Parse(args[1:])
+
+	cfg, err := auth.Load(*file)
+	if errors.Is(err, os.ErrNotExist) && args[0] == "create" {
+		cfg, err = &auth.Config{}, nil
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading tokens: %v\n", err)
+		os.Exit(1)
+	}
+
+	switch args[0] {
+	case "create":
+		if flags.NArg() != 1 {
+			usage()
+		}
+		r, err := auth.ParseRole(*role)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		secret, err := cfg.Create(flags.Arg(0), r)
+		if err == nil {
+			err = cfg.Save(*file)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error creating token: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Fprintf(os.Stderr, "Created %s token %q in %s.  It is not kept, so copy it now:\n", r, flags.Arg(0), *file)
+		fmt.Println(secret)
+	case "revoke":
+		if flags.NArg() != 1 {
+			usage()
+		}
+		err := cfg.Revoke(flags.Arg(0))
+		if err == nil {
+			err = cfg.Save(*file)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error revoking token %q: %v\n", flags.Arg(0), err)
+			os.Exit(1)
+		}
+		fmt.Printf("Revoked: %s\n", flags.Arg(0))
+	case "private":
+		if flags.NArg() != 1 || (flags.Arg(0) != "on" && flags.Arg(0) != "off") {
+			usage()
+		}
+		cfg.Private = flags.Arg(0) == "on"
+		if err := cfg.Save(*file); err != nil {
+			fmt.Fprintf(os.Stderr, "Error saving tokens: %v\n", err)
+			os.Exit(1)
+		}
+		if cfg.Private {
+			fmt.Println("The JSON API, /api/dt, chart data and /metrics now need a token.")
+		} else {
+			fmt.Println("The JSON API, /api/dt, chart data and /metrics are now public.")
+		}
+	case "list":
+		for _, t := range cfg.Tokens {
+			fmt.Printf("%-20s %-8s created %s\n", t.Name, t.Role, t.Created)
+		}
+		if cfg.Private {
+			fmt.Println("The JSON API, /api/dt, chart data and /metrics are private: they need a token too.")
+		}
+	}
+}
+
+func runSearch(args []string) {
+	if len(args) < 1 {
+		usage()
+	}
+	switch args[0] {
+	case "answers":
+		searchAnswers(args[1:])
+	case "anagrams":
+		searchAnagrams(args[1:])
+	case "clues":
+		searchClues(args[1:])
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown search: %s\n", args[0])
+		usage()
+	}
+}
+
+func searchAnswers(args []string) {
+	flags := flag.NewFlagSet("search answers", flag.ExitOnError)
+	enum := flags.String("enum", "", "enumeration, e.g. 3,4 or 1-5")
+	limit := flags.Int("limit", 20, "number of answers to list")
+	examples := flags.Int("examples", 3, "example clues to show for each answer")
+	flags.Parse(args)
+	if flags.NArg() > 1 || (flags.NArg() == 0 && *enum == "") {
+		usage()
+	}
+
+	p, err := search.ParsePattern(flags.Arg(0), *enum)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	database := openSearchDB()
+	defer database.Close()
+
+	matches, total, err := search.Answers(context.Background(), database, p, *examples, 0, *limit)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	printAnswers(matches, total)
+}
+
+func searchAnagrams(args []string) {
+	flags := flag.NewFlagSet("search anagrams", flag.ExitOnError)
+	pattern := flags.String("pattern", "", "letters already known, e.g. ?I????")
+	enum := flags.String("enum", "", "enumeration, e.g. 3,4 or 1-5")
+	limit := flags.Int("limit", 20, "number of answers to list")
+	examples := flags.Int("examples", 3, "example clues to show for each answer")
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		usage()
+	}
+
+	p, err := search.AnagramPattern(*pattern, *enum)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	database := openSearchDB()
+	defer database.Close()
+
+	matches, total, err := search.Anagrams(context.Background(), database,
+		strings.Join(flags.Args(), ""), p, *examples, 0, *limit)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	printAnswers(matches, total)
+}
+
+// printAnswers lists the answers found by search answers or anagrams.
+func printAnswers(matches []api.AnswerMatch, total int) {
+	for _, m := range matches {
+		en := m.Enumeration
+		if en == "" {
+			en = strconv.Itoa(utf8.RuneCountInString(m.Answer))
+		}
+		fmt.Printf("%s (%s)  %d uses by %d setters\n", m.Answer, en, m.Uses, m.Setters)
+		for _, e := range m.Examples {
+			fmt.Printf("    %s  [%s, %s %s, %s]\n", strings.TrimSpace(e.Clue), e.Setter, e.Type, e.Number, e.Date)
+		}
+	}
+	if total > len(matches) {
+		fmt.Printf("(%d of %d answers)\n", len(matches), total)
+	}
+}
+
+func searchClues(args []string) {
+	flags := flag.NewFlagSet("search clues", flag.ExitOnError)
+	setter := flags.String("setter", "", "only clues by this setter")
+	typ := flags.String("type", "", "only clues from this crossword type")
+	from := flags.String("from", "", "only crosswords published on or after this date (YYYY-MM-DD)")
+	to := flags.String("to", "", "only crosswords published on or before this date (YYYY-MM-DD)")
+	length := flags.Int("length", 0, "only clues whose answer has this many letters")
+	sort := flags.String("sort", "relevance", "relevance, newest or oldest")
+	limit := flags.Int("limit", 20, "number of clues to list")
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		usage()
+	}
+
+	q := search.ClueQuery{
+		Query:  strings.Join(flags.Args(), " "),
+		Setter: *setter,
+		Type:   *typ,
+		Length: *length,
+		Sort:   *sort,
+	}
+	for _, d := range []struct {
+		flag, value string
+		dst         *time.Time
+	}{{"from", *from, &q.From}, {"to", *to, &q.To}} {
+		if d.value == "" {
+			continue
+		}
+		t, err := time.Parse(time.DateOnly, d.value)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid -%s date %q (want YYYY-MM-DD)\n", d.flag, d.value)
+			os.Exit(1)
+		}
+		*d.dst = t
+	}
+
+	database := openSearchDB()
+	defer database.Close()
+
+	hits, total, err := search.Clues(context.Background(), database, q, 0, *limit)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	// Matching words are shown in bold on a terminal.
+	bold := false
+	if fi, err := os.Stdout.Stat(); err == nil && fi.Mode()&os.ModeCharDevice != 0 {
+		bold = true
+	}
+	for _, h := range hits {
+		var b strings.Builder
+		pos := 0
+		for _, s := range h.Highlights {
+			b.WriteString(h.Clue[pos:s[0]])
+			if bold {
+				b.WriteString("\x1b[1m" + h.Clue[s[0]:s[1]] + "\x1b[0m")
+			} else {
+				b.WriteString(h.Clue[s[0]:s[1]])
+			}
+			pos = s[1]
+		}
+		b.WriteString(h.Clue[pos:])
+		fmt.Println(b.String())
+		fmt.Printf("    %s  [%s, %s %s, %s, %s]\n", h.Solution, h.Setter, h.Type, h.Number, h.Entry, h.Date)
+		fmt.Printf("    %s\n", h.URL)
+	}
+	if total > len(hits) {
+		fmt.Printf("(%d of %d clues)\n", len(hits), total)
+	}
+}
+
+// openSearchDB opens the DB for the search commands, exiting on failure.
+func openSearchDB() *sql.DB {
+	database, err := db.Open(db.DefaultDBFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error opening database: %v\n", err)
+		os.Exit(1)
+	}
+	if err := db.CreateSchema(database); err != nil {
+		database.Close()
+		fmt.Fprintf(os.Stderr, "Error creating schema: %v\n", err)
+		os.Exit(1)
+	}
+	return database
+}
+
+// writeRunMetrics writes the outcome of a run of command, along with the
+// state of the DB if it was opened, to path for node_exporter's textfile
+// collector.  extra, if set, adds the command's own metrics.  Nothing is
+// written if path is empty.
+func writeRunMetrics(path, command string, database *sql.DB, start time.Time, runErr error, extra func(*metrics.Registry)) {
+	if path == "" {
+		return
+	}
+	reg := metrics.NewRegistry()
+	reg.Gauge("gcc_run_last_timestamp_seconds", "When the command last finished, as a Unix time.", "command").
+		SetTime(time.Now(), command)
+	reg.Gauge("gcc_run_duration_seconds", "How long the command's last run took.", "command").
+		Set(time.Since(start).Seconds(), command)
+	success := 0.0
+	if runErr == nil {
+		success = 1
+	}
+	reg.Gauge("gcc_run_success", "Whether the command's last run succeeded (1) or failed (0).", "command").
+		Set(success, command)
+	if database != nil {
+		metrics.RegisterDB(reg, database, "command", command)
+	}
+	if extra != nil {
+		extra(reg)
+	}
+	if err := reg.WriteFile(path); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+	}
+}
@@ -2,7 +2,10 @@ module github.com/ThomasAdam/guardian-cc
 
 go 1.25.6
 
-require github.com/marcboeker/go-duckdb v1.8.5
+require (
+	github.com/andybalholm/brotli v1.1.1
+	github.com/marcboeker/go-duckdb v1.8.5
+)
 
 require (
 	github.com/apache/arrow-go/v18 v18.1.0 // indirect
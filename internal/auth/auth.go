@@ -0,0 +1,262 @@
+// Package auth manages the API tokens serve accepts.  Each token has a
+// name and a role; only a hash of it is kept, in a JSON file (by default
+// tokens.json in the current directory) written by the tokens command.
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultFile is where tokens are kept unless told otherwise.
+const DefaultFile = "./tokens.json"
+
+// Role is what a token may do.  Each role may do everything the ones
+// before it may.
+type Role int
+
+const (
+	// Reader may use the JSON API when it is private.
+	Reader Role = iota + 1
+	// Analyst may also run ad-hoc queries.
+	Analyst
+	// Admin may also change the data: upload crosswords and run jobs.
+	Admin
+)
+
+var roleNames = map[Role]string{Reader: "reader", Analyst: "analyst", Admin: "admin"}
+
+func (r Role) String() string {
+	if s, ok := roleNames[r]; ok {
+		return s
+	}
+	return fmt.Sprintf("Role(%d)", int(r))
+}
+
+// ParseRole parses a role's name.
+func ParseRole(s string) (Role, error) {
+	for r, name := range roleNames {
+		if s == name {
+			return r, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown role %q (want reader, analyst or admin)", s)
+}
+
+func (r Role) MarshalText() ([]byte, error) {
+	if _, ok := roleNames[r]; !ok {
+		return nil, fmt.Errorf("invalid role %d", int(r))
+	}
+	return []byte(r.String()), nil
+}
+
+func (r *Role) UnmarshalText(b []byte) error {
+	role, err := ParseRole(string(b))
+	if err != nil {
+		return err
+	}
+	*r = role
+	return nil
+}
+
+// Token is a token as kept in the file: its SHA-256 hash, not the token
+// itself.  Tokens are long and random, so a fast hash is enough.
+type Token struct {
+	Name    string `json:"name"`
+	Role    Role   `json:"role"`
+	Hash    string `json:"hash"`
+	Created string `json:"created"`
+}
+
+// Config is the contents of a tokens file.
+type Config struct {
+	// Private, if set, makes the read-only JSON API, the data behind the
+	// page and the metrics need a token too.
+	Private bool    `json:"private"`
+	Tokens  []Token `json:"tokens"`
+}
+
+// ErrNoToken is returned by Revoke for a name that has no token.
+var ErrNoToken = errors.New("no such token")
+
+// tokenNameRE matches a token's name, e.g. "scraper" or "alice@laptop".
+var tokenNameRE = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._@-]{0,63}$`)
+
+// Load reads a tokens file.  A missing file is reported as an error
+// satisfying errors.Is(err, os.ErrNotExist).
+func Load(path string) (*Config, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var c Config
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+	for _, t := range c.Tokens {
+		if !strings.HasPrefix(t.Hash, "sha256:") || t.Role == 0 {
+			return nil, fmt.Errorf("reading %s: token %q has no valid hash or role", path, t.Name)
+		}
+	}
+	return &c, nil
+}
+
+// Save writes c to path, readable only by its owner.  The file is
+// replaced whole, so a server reading it never sees half of it.
+func (c *Config) Save(path string) error {
+	b, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), ".tokens-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Chmod(0o600); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// Create adds a token with the given name and role, returning the token
+// itself, which is not kept anywhere.
+func (c *Config) Create(name string, role Role) (string, error) {
+	if !tokenNameRE.MatchString(name) {
+		return "", fmt.Errorf("invalid token name %q (want letters, digits and ._@-)", name)
+	}
+	if _, ok := roleNames[role]; !ok {
+		return "", fmt.Errorf("invalid role %d", int(role))
+	}
+	for _, t := range c.Tokens {
+		if t.Name == name {
+			return "", fmt.Errorf("there is already a token named %q", name)
+		}
+	}
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	secret := "gcc_" + base64.RawURLEncoding.EncodeToString(b)
+	c.Tokens = append(c.Tokens, Token{
+		Name:    name,
+		Role:    role,
+		Hash:    hash(secret),
+		Created: time.Now().UTC().Format(time.RFC3339),
+	})
+	return secret, nil
+}
+
+// Revoke removes the token with the given name.
+func (c *Config) Revoke(name string) error {
+	for i, t := range c.Tokens {
+		if t.Name == name {
+			c.Tokens = append(c.Tokens[:i], c.Tokens[i+1:]...)
+			return nil
+		}
+	}
+	return ErrNoToken
+}
+
+// Lookup returns the token whose hash matches secret.
+func (c *Config) Lookup(secret string) (Token, bool) {
+	h := []byte(hash(secret))
+	var found Token
+	ok := false
+	// Compare against every token, so that the time taken says nothing
+	// about which matched.
+	for _, t := range c.Tokens {
+		if subtle.ConstantTimeCompare(h, []byte(t.Hash)) == 1 {
+			found, ok = t, true
+		}
+	}
+	return found, ok
+}
+
+func hash(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// File is a tokens file that is read again whenever it changes, so that
+// tokens created or revoked while serve runs take effect at once.
+type File struct {
+	path string
+
+	mu      sync.Mutex
+	modTime time.Time
+	size    int64
+	config  *Config
+}
+
+// Open reads the tokens file at path.
+func Open(path string) (*File, error) {
+	f := &File{path: path}
+	if err := f.reload(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Config returns the file's current contents.  If the file has changed
+// but cannot be read, the last good contents are kept; if it has been
+// removed, no token is accepted.
+func (f *File) Config() *Config {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	fi, err := os.Stat(f.path)
+	if errors.Is(err, os.ErrNotExist) {
+		f.modTime, f.size = time.Time{}, 0
+		f.config = &Config{Private: f.config.Private}
+		return f.config
+	}
+	if err == nil && (!fi.ModTime().Equal(f.modTime) || fi.Size() != f.size) {
+		if err := f.reloadLocked(); err != nil {
+			// Record the bad version so that it is not retried on
+			// every request.
+			f.modTime, f.size = fi.ModTime(), fi.Size()
+			log.Printf("error reloading %s (keeping the tokens read before): %v", f.path, err)
+		}
+	}
+	return f.config
+}
+
+func (f *File) reload() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.reloadLocked()
+}
+
+func (f *File) reloadLocked() error {
+	fi, err := os.Stat(f.path)
+	if err != nil {
+		return err
+	}
+	c, err := Load(f.path)
+	if err != nil {
+		return err
+	}
+	f.modTime, f.size, f.config = fi.ModTime(), fi.Size(), c
+	return nil
+}
@@ -0,0 +1,147 @@
+package auth
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCreateLookupRevoke(t *testing.T) {
+	var c Config
+	reader, err := c.Create("reader", Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	admin, err := c.Create("admin", Admin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(reader, "gcc_") || reader == admin {
+		t.Errorf("Create = %q and %q, want two different gcc_ tokens", reader, admin)
+	}
+	for _, tok := range c.Tokens {
+		if strings.Contains(tok.Hash, reader) || strings.Contains(tok.Hash, admin) {
+			t.Errorf("token %q keeps the secret itself", tok.Name)
+		}
+	}
+
+	if _, err := c.Create("admin", Reader); err == nil {
+		t.Error("Create(admin) again succeeded, want an error")
+	}
+	if _, err := c.Create("no spaces", Reader); err == nil {
+		t.Error(`Create("no spaces") succeeded, want an error`)
+	}
+	if _, err := c.Create("nobody", 0); err == nil {
+		t.Error("Create with role 0 succeeded, want an error")
+	}
+
+	if tok, ok := c.Lookup(admin); !ok || tok.Name != "admin" || tok.Role != Admin {
+		t.Errorf("Lookup(admin's token) = %+v, %v; want admin", tok, ok)
+	}
+	if tok, ok := c.Lookup(reader); !ok || tok.Name != "reader" || tok.Role != Reader {
+		t.Errorf("Lookup(reader's token) = %+v, %v; want reader", tok, ok)
+	}
+	for _, secret := range []string{"", "gcc_", admin + "x", c.Tokens[0].Hash} {
+		if tok, ok := c.Lookup(secret); ok {
+			t.Errorf("Lookup(%q) = %+v, want no token", secret, tok)
+		}
+	}
+
+	if err := c.Revoke("admin"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.Lookup(admin); ok {
+		t.Error("Lookup found a revoked token")
+	}
+	if err := c.Revoke("admin"); !errors.Is(err, ErrNoToken) {
+		t.Errorf("Revoke(admin) again = %v, want ErrNoToken", err)
+	}
+}
+
+func TestSaveLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens.json")
+	if _, err := Load(path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Load of a missing file = %v, want os.ErrNotExist", err)
+	}
+
+	c := Config{Private: true}
+	secret, err := c.Create("analyst", Analyst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0o600 {
+		t.Errorf("saved file has mode %v, want 0600", fi.Mode().Perm())
+	}
+
+	got, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tok, ok := got.Lookup(secret); !got.Private || !ok || tok.Role != Analyst {
+		t.Errorf("Load = private %v, Lookup %+v, %v; want private and the analyst", got.Private, tok, ok)
+	}
+
+	for _, bad := range []string{
+		`{"tokens": [{"name": "x", "role": "root", "hash": "sha256:00"}]}`,
+		`{"tokens": [{"name": "x", "role": "reader", "hash": "plain"}]}`,
+		`{"tokens": [`,
+	} {
+		if err := os.WriteFile(path, []byte(bad), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Load(path); err == nil {
+			t.Errorf("Load(%s) succeeded, want an error", bad)
+		}
+	}
+}
+
+func TestFileReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens.json")
+	c := Config{Private: true}
+	first, err := c.Create("first", Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	f, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := c.Create("second", Admin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := f.Config().Lookup(second); !ok {
+		t.Error("a token added to the file is not accepted")
+	}
+
+	if err := os.WriteFile(path, []byte("not json"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := f.Config().Lookup(first); !ok {
+		t.Error("a bad file dropped the tokens read before it")
+	}
+
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	cfg := f.Config()
+	if _, ok := cfg.Lookup(first); ok || !cfg.Private {
+		t.Errorf("after removing the file: token accepted %v, private %v; want no tokens, still private", ok, cfg.Private)
+	}
+}
@@ -0,0 +1,150 @@
+package charts
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+
+	"github.com/andybalholm/brotli"
+)
+
+// Precompressed siblings written next to each ajax file, so that a server
+// can send them as-is to clients that accept the encoding.
+const (
+	gzipExt   = ".gz"
+	brotliExt = ".br"
+)
+
+// brotliLevel trades size for speed: the pure-Go encoder's best
+// compression saves a fifth of the size but makes a render several times
+// slower.
+const brotliLevel = 5
+
+// tableAjax returns the URL p's table loads its rows from.  For a static
+// page the rows are written to the dataset's ajax file first, and are
+// also returned with their plain values (see TableDataset.ExportValues);
+// a server-side page pages through /api/dt instead, and no rows are read.
+func tableAjax(db *sql.DB, tmpls *Templates, p TablePlugin) (string, *Table, error) {
+	ds := p.Table()
+	if tmpls.serverSide {
+		return "api/dt?table=" + ds.Name, nil, nil
+	}
+	t := ds.exportTable()
+	err := writeAjaxFile(ds.ajaxFile, func(emit func([]string) error) error {
+		return ds.EachRow(context.Background(), db, func(row TableRow) error {
+			t.Rows = append(t.Rows, ds.ExportValues(row))
+			return emit(ds.Cells(row))
+		})
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	return path.Base(ds.ajaxFile), t, nil
+}
+
+// ajaxFiles returns filename and its precompressed siblings.
+func ajaxFiles(filename string) []string {
+	return []string{filename, filename + gzipExt, filename + brotliExt}
+}
+
+// writeAjaxFile writes a DataTables-compatible AJAX JSON file, along with
+// gzip and brotli compressed copies.  Rows are produced by each, which
+// calls emit once per row, and are encoded as they arrive rather than
+// being collected first.  Each file is written under a temporary name and
+// renamed into place, so a server never sees a partial file.
+//
+// HTML escaping is disabled so that tags (e.g. <a href=...>) embedded in
+// the data are written literally, not escaped to \u003c etc.
+func writeAjaxFile(filename string, each func(emit func(row []string) error) error) error {
+	var files []*os.File
+	defer func() {
+		for _, f := range files {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+	create := func(name string) (*os.File, error) {
+		f, err := os.Create(name + ".tmp")
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+		return f, nil
+	}
+
+	plain, err := create(filename)
+	if err != nil {
+		return err
+	}
+	gzFile, err := create(filename + gzipExt)
+	if err != nil {
+		return err
+	}
+	brFile, err := create(filename + brotliExt)
+	if err != nil {
+		return err
+	}
+	gz, err := gzip.NewWriterLevel(gzFile, gzip.DefaultCompression)
+	if err != nil {
+		return err
+	}
+	br := brotli.NewWriterLevel(brFile, brotliLevel)
+
+	w := bufio.NewWriter(io.MultiWriter(plain, gz, br))
+	var row bytes.Buffer
+	enc := json.NewEncoder(&row)
+	enc.SetEscapeHTML(false)
+
+	if _, err := io.WriteString(w, `{"data":[`); err != nil {
+		return err
+	}
+	n := 0
+	err = each(func(cells []string) error {
+		row.Reset()
+		if n > 0 {
+			row.WriteByte(',')
+		}
+		row.WriteByte('\n')
+		n++
+		if err := enc.Encode(cells); err != nil {
+			return err
+		}
+		// Drop the newline Encode appends; the next row starts with one.
+		_, err := w.Write(bytes.TrimSuffix(row.Bytes(), []byte("\n")))
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, "\n]}\n"); err != nil {
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("writing %s: %w", filename, err)
+	}
+	if err := gz.Close(); err != nil {
+		return fmt.Errorf("writing %s: %w", filename+gzipExt, err)
+	}
+	if err := br.Close(); err != nil {
+		return fmt.Errorf("writing %s: %w", filename+brotliExt, err)
+	}
+
+	for i, name := range ajaxFiles(filename) {
+		f := files[i]
+		if err := f.Close(); err != nil {
+			return fmt.Errorf("writing %s: %w", name, err)
+		}
+		if err := os.Rename(f.Name(), name); err != nil {
+			return err
+		}
+	}
+	files = nil
+	return nil
+}
@@ -0,0 +1,130 @@
+package charts
+
+// c3 configuration, as consumed by c3.generate().  Only the options the
+// charts actually use are modelled; the JSON field names are c3's own.
+
+type c3Config struct {
+	Bindto  string    `json:"bindto"`
+	Size    c3Size    `json:"size"`
+	Data    c3Data    `json:"data"`
+	Tooltip *c3Toggle `json:"tooltip,omitempty"`
+	Axis    c3Axes    `json:"axis"`
+	Legend  *c3Toggle `json:"legend,omitempty"`
+}
+
+type c3Size struct {
+	Height int `json:"height"`
+	Width  int `json:"width,omitempty"`
+}
+
+type c3Data struct {
+	Colors  map[string]string `json:"colors,omitempty"`
+	Columns []any             `json:"columns"`
+	Type    ChartKind         `json:"type"`
+	Groups  [][]string        `json:"groups,omitempty"`
+	Empty   *c3Empty          `json:"empty,omitempty"`
+}
+
+type c3Empty struct {
+	Label c3Text `json:"label"`
+}
+
+type c3Text struct {
+	Text string `json:"text"`
+}
+
+type c3Toggle struct {
+	Show bool `json:"show"`
+}
+
+type c3Axes struct {
+	X c3XAxis `json:"x"`
+	Y c3YAxis `json:"y"`
+}
+
+type c3XAxis struct {
+	Type       string   `json:"type"`
+	Categories []string `json:"categories"`
+	Tick       *c3XTick `json:"tick,omitempty"`
+	Height     int      `json:"height,omitempty"`
+}
+
+type c3XTick struct {
+	Rotate    int  `json:"rotate"`
+	Multiline bool `json:"multiline"`
+}
+
+type c3YAxis struct {
+	Label string   `json:"label,omitempty"`
+	Min   *float64 `json:"min,omitempty"`
+	Max   *float64 `json:"max,omitempty"`
+	Tick  *c3YTick `json:"tick,omitempty"`
+}
+
+type c3YTick struct {
+	Steps int `json:"steps"`
+}
+
+// c3Def converts spec into a c3 configuration bound to the element with
+// the given id.
+func c3Def(spec *ChartSpec, divID string) c3Config {
+	cfg := c3Config{
+		Bindto: "#" + divID,
+		Size:   c3Size{Height: spec.Height, Width: spec.Width},
+		Data: c3Data{
+			Colors:  spec.Colors,
+			Columns: seriesColumns(spec.Series),
+			Type:    spec.Kind,
+		},
+		Axis: c3Axes{
+			X: c3XAxis{
+				Type:       "category",
+				Categories: spec.Categories,
+				Height:     spec.XAxis.Height,
+			},
+			Y: c3YAxis{
+				Label: spec.YAxis.Label,
+				Min:   spec.YAxis.Min,
+				Max:   spec.YAxis.Max,
+			},
+		},
+	}
+	if spec.Stacked {
+		group := make([]string, len(spec.Series))
+		for i, s := range spec.Series {
+			group[i] = s.Name
+		}
+		cfg.Data.Groups = [][]string{group}
+	}
+	if spec.EmptyLabel != "" {
+		cfg.Data.Empty = &c3Empty{Label: c3Text{Text: spec.EmptyLabel}}
+	}
+	if spec.XAxis.TickRotate != 0 {
+		cfg.Axis.X.Tick = &c3XTick{Rotate: spec.XAxis.TickRotate}
+	}
+	if spec.YAxis.TickSteps != 0 {
+		cfg.Axis.Y.Tick = &c3YTick{Steps: spec.YAxis.TickSteps}
+	}
+	if spec.HideTooltip {
+		cfg.Tooltip = &c3Toggle{Show: false}
+	}
+	if spec.HideLegend {
+		cfg.Legend = &c3Toggle{Show: false}
+	}
+	return cfg
+}
+
+// seriesColumns converts series into c3's column format, where each
+// column is the series name followed by its values.
+func seriesColumns(series []Series) []any {
+	columns := make([]any, 0, len(series))
+	for _, s := range series {
+		col := make([]any, 0, len(s.Values)+1)
+		col = append(col, s.Name)
+		for _, v := range s.Values {
+			col = append(col, v)
+		}
+		columns = append(columns, col)
+	}
+	return columns
+}
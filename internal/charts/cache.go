@@ -0,0 +1,158 @@
+package charts
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// cacheDirName is the directory, next to the rendered page, that holds
+// each plugin's last rendered fragment and files.
+const cacheDirName = ".render-cache"
+
+// Files within a cache entry.  The key file is written last, so an entry
+// without one is incomplete and ignored.
+const (
+	cacheKeyFile      = "key"
+	cacheFragmentFile = "fragment.html"
+)
+
+// fragmentCache stores rendered sections so that RenderAll can skip
+// plugins whose inputs have not changed.  An entry's key covers
+// everything a fragment depends on: the DB generation (bumped by every
+// import that adds crosswords), the chart templates, the year being
+// rendered as of and the binary itself.
+type fragmentCache struct {
+	dir  string
+	base string
+}
+
+func newFragmentCache(outDir string, generation int64, tmpls *Templates, year int) (*fragmentCache, error) {
+	exe, err := executableSum()
+	if err != nil {
+		return nil, err
+	}
+	h := sha256.New()
+	fmt.Fprintf(h, "generation %d\nyear %d\ntemplates %s\nbinary %s\n",
+		generation, year, tmpls.sum, exe)
+	return &fragmentCache{
+		dir:  filepath.Join(outDir, cacheDirName),
+		base: hex.EncodeToString(h.Sum(nil)),
+	}, nil
+}
+
+// key returns the cache key for p's section.
+func (fc *fragmentCache) key(p ChartPlugin) string {
+	return fc.base + " chart" + p.Order()
+}
+
+// load returns p's cached fragment and copies its cached files back to
+// where the page expects them.  It reports false if there is no entry for
+// the current key.
+func (fc *fragmentCache) load(p ChartPlugin, files []string) (string, bool) {
+	entry := filepath.Join(fc.dir, "chart"+p.Order())
+	key, err := os.ReadFile(filepath.Join(entry, cacheKeyFile))
+	if err != nil || string(key) != fc.key(p) {
+		return "", false
+	}
+	fragment, err := os.ReadFile(filepath.Join(entry, cacheFragmentFile))
+	if err != nil {
+		return "", false
+	}
+	for _, f := range files {
+		if err := copyFile(filepath.Join(entry, filepath.Base(f)), f); err != nil {
+			return "", false
+		}
+	}
+	return string(fragment), true
+}
+
+// store saves p's fragment and copies of its files under the current key.
+func (fc *fragmentCache) store(p ChartPlugin, fragment string, files []string) error {
+	entry := filepath.Join(fc.dir, "chart"+p.Order())
+	if err := os.RemoveAll(entry); err != nil {
+		return fmt.Errorf("clearing cache for chart%s: %w", p.Order(), err)
+	}
+	if err := os.MkdirAll(entry, 0755); err != nil {
+		return fmt.Errorf("creating cache for chart%s: %w", p.Order(), err)
+	}
+	if err := os.WriteFile(filepath.Join(entry, cacheFragmentFile), []byte(fragment), 0644); err != nil {
+		return fmt.Errorf("caching chart%s: %w", p.Order(), err)
+	}
+	for _, f := range files {
+		if err := copyFile(f, filepath.Join(entry, filepath.Base(f))); err != nil {
+			return fmt.Errorf("caching chart%s: %w", p.Order(), err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(entry, cacheKeyFile), []byte(fc.key(p)), 0644); err != nil {
+		return fmt.Errorf("caching chart%s: %w", p.Order(), err)
+	}
+	return nil
+}
+
+// pluginFiles returns the files p's section writes besides its fragment:
+// the CSV and JSON data files in outDir and, for DataTables plugins on a
+// static page, the ajax file.
+func pluginFiles(p ChartPlugin, tmpls *Templates, outDir string) []string {
+	files := []string{
+		filepath.Join(outDir, "chart"+p.Order()+".csv"),
+		filepath.Join(outDir, "chart"+p.Order()+".json"),
+	}
+	if tp, ok := p.(TablePlugin); ok && !tmpls.serverSide {
+		files = append(files, ajaxFiles(tp.Table().ajaxFile)...)
+	}
+	return files
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+var (
+	exeSumOnce sync.Once
+	exeSum     string
+	exeSumErr  error
+)
+
+// executableSum returns a hash of the running binary, so that a rebuilt
+// guardian-cc (with, say, a changed chart query) does not reuse fragments
+// rendered by the old one.
+func executableSum() (string, error) {
+	exeSumOnce.Do(func() {
+		path, err := os.Executable()
+		if err != nil {
+			exeSumErr = fmt.Errorf("finding executable: %w", err)
+			return
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			exeSumErr = fmt.Errorf("reading executable: %w", err)
+			return
+		}
+		defer f.Close()
+		h := sha256.New()
+		if _, err := io.Copy(h, f); err != nil {
+			exeSumErr = fmt.Errorf("reading executable: %w", err)
+			return
+		}
+		exeSum = hex.EncodeToString(h.Sum(nil))
+	})
+	return exeSum, exeSumErr
+}
@@ -15,17 +15,17 @@ type setterTypeCount struct {
 	count int
 }
 
-func (c *Chart1) Render(db *sql.DB, tmplDir string) (string, error) {
+func (c *Chart1) Series(db *sql.DB, opts Options) (*Section, error) {
 	rows, err := db.Query(`
 		SELECT creator_name AS name,
 		       crossword_type AS type,
 		       COUNT(*) AS count
 		FROM crosswords
 		GROUP BY creator_name, crossword_type
-		ORDER BY count DESC, crossword_type ASC
+		ORDER BY count DESC, crossword_type ASC, creator_name ASC
 	`)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	defer rows.Close()
 
@@ -40,7 +40,7 @@ func (c *Chart1) Render(db *sql.DB, tmplDir string) (string, error) {
 		var name, ctype string
 		var count int
 		if err := rows.Scan(&name, &ctype, &count); err != nil {
-			return "", err
+			return nil, err
 		}
 		if nm[name] == nil {
 			nm[name] = &nameMap{}
@@ -74,51 +74,52 @@ func (c *Chart1) Render(db *sql.DB, tmplDir string) (string, error) {
 		sorted = append(sorted, kv{k, v})
 	}
 	sort.Slice(sorted, func(i, j int) bool {
-		return sorted[i].data.cryptic.pos < sorted[j].data.cryptic.pos
+		if sorted[i].data.cryptic.pos != sorted[j].data.cryptic.pos {
+			return sorted[i].data.cryptic.pos < sorted[j].data.cryptic.pos
+		}
+		return sorted[i].name < sorted[j].name
 	})
 
-	labels := []any{"x"}
-	cryptic := []any{"Cryptic"}
-	prize := []any{"Prize"}
+	spec := &ChartSpec{
+		ID:         "chart1",
+		Title:      "Total number of crosswords, set by author",
+		Kind:       KindBar,
+		Stacked:    true,
+		Series:     []Series{{Name: "Cryptic"}, {Name: "Prize"}},
+		XAxis:      Axis{TickRotate: 75},
+		YAxis:      Axis{Label: "No. of crosswords set", Max: limit(800), TickSteps: 20},
+		Height:     800,
+		EmptyLabel: "Unknown",
+	}
 	for _, s := range sorted {
-		labels = append(labels, s.name)
-		cryptic = append(cryptic, s.data.cryptic.count)
-		prize = append(prize, s.data.prize.count)
+		spec.Categories = append(spec.Categories, s.name)
+		spec.Series[0].Values = append(spec.Series[0].Values, float64(s.data.cryptic.count))
+		spec.Series[1].Values = append(spec.Series[1].Values, float64(s.data.prize.count))
 	}
-	columns := []any{labels, cryptic, prize}
 
-	chartDef := map[string]any{
-		"bindto": "#mychart1",
-		"size":   map[string]any{"height": 800},
-		"data": map[string]any{
-			"x":       "x",
-			"columns": columns,
-			"type":    "bar",
-			"empty":   map[string]any{"label": map[string]any{"text": "Unknown"}},
-			"groups":  [][]string{{"Prize", "Cryptic"}},
-		},
-		"axis": map[string]any{
-			"x": map[string]any{
-				"type":   "category",
-				"tick":   map[string]any{"rotate": "75", "multiline": false},
-				"height": 0,
-			},
-			"y": map[string]any{
-				"label": "No. of crosswords set",
-				"max":   800,
-				"tick":  map[string]any{"steps": 20},
-			},
-		},
+	return &Section{
+		Order:    c.Order(),
+		Title:    spec.Title,
+		Preamble: "This chart shows the number of crosswords set per setter.  No real surprises here as to the most prolific setters.",
+		Charts:   []*ChartSpec{spec},
+	}, nil
+}
+
+func (c *Chart1) Render(db *sql.DB, tmpls *Templates, opts Options) (string, *Table, error) {
+	sec, err := c.Series(db, opts)
+	if err != nil {
+		return "", nil, err
 	}
+	spec := sec.Charts[0]
 
 	data := map[string]any{
-		"Title":        "Total number of crosswords, set by author",
-		"Preamble":     "This chart shows the number of crosswords set per setter.  No real surprises here as to the most prolific setters.",
+		"Title":        sec.Title,
+		"Preamble":     sec.Preamble,
 		"Order":        1,
 		"DivID":        "mychart1",
 		"JSVar":        "chart1",
 		"DefaultChart": "bar",
-		"ChartJSON":    toJSON(chartDef),
+		"ChartJSON":    toJSON(c3Def(spec, "mychart1")),
 	}
-	return executeTemplate(tmplDir, "chart.tmpl", data)
+	return renderSeries(tmpls, sec, "chart.tmpl", data)
 }
@@ -10,7 +10,7 @@ type Chart10 struct{}
 
 func (c *Chart10) Order() string { return "10" }
 
-func (c *Chart10) Render(db *sql.DB, tmplDir string) (string, error) {
+func (c *Chart10) Series(db *sql.DB, opts Options) (*Section, error) {
 	rows, err := db.Query(`
 		SELECT c.creator_name AS name,
 		       e.direction,
@@ -22,7 +22,7 @@ func (c *Chart10) Render(db *sql.DB, tmplDir string) (string, error) {
 		ORDER BY c.creator_name, e.direction
 	`)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	defer rows.Close()
 
@@ -35,7 +35,7 @@ func (c *Chart10) Render(db *sql.DB, tmplDir string) (string, error) {
 		var name, direction string
 		var cnt int
 		if err := rows.Scan(&name, &direction, &cnt); err != nil {
-			return "", err
+			return nil, err
 		}
 		if data[name] == nil {
 			data[name] = &counts{}
@@ -48,7 +48,8 @@ func (c *Chart10) Render(db *sql.DB, tmplDir string) (string, error) {
 		}
 	}
 
-	// Sort setters by total clues (across+down) descending, most prolific first.
+	// Sort setters by total clues (across+down) descending, most prolific
+	// first, then by name.
 	type kv struct {
 		name   string
 		across int
@@ -61,48 +62,52 @@ func (c *Chart10) Render(db *sql.DB, tmplDir string) (string, error) {
 	sort.Slice(sorted, func(i, j int) bool {
 		ti := sorted[i].across + sorted[i].down
 		tj := sorted[j].across + sorted[j].down
-		return ti > tj
+		if ti != tj {
+			return ti > tj
+		}
+		return sorted[i].name < sorted[j].name
 	})
 
-	labels := []any{"x"}
-	across := []any{"Across"}
-	down := []any{"Down"}
+	spec := &ChartSpec{
+		ID:      "chart10",
+		Title:   "Across vs Down clue balance per setter",
+		Kind:    KindBar,
+		Stacked: true,
+		Series:  []Series{{Name: "Across"}, {Name: "Down"}},
+		XAxis:   Axis{TickRotate: 75},
+		YAxis:   Axis{Label: "Number of clues"},
+		Height:  600,
+		Colors:  map[string]string{"Across": "#4e79a7", "Down": "#f28e2b"},
+	}
 	for _, s := range sorted {
-		labels = append(labels, s.name)
-		across = append(across, s.across)
-		down = append(down, s.down)
+		spec.Categories = append(spec.Categories, s.name)
+		spec.Series[0].Values = append(spec.Series[0].Values, float64(s.across))
+		spec.Series[1].Values = append(spec.Series[1].Values, float64(s.down))
 	}
 
-	chartDef := map[string]any{
-		"bindto": "#mychart10",
-		"size":   map[string]any{"height": 600},
-		"data": map[string]any{
-			"colors":  map[string]string{"Across": "#4e79a7", "Down": "#f28e2b"},
-			"x":       "x",
-			"columns": []any{labels, across, down},
-			"type":    "bar",
-			"groups":  [][]string{{"Across", "Down"}},
-		},
-		"axis": map[string]any{
-			"x": map[string]any{
-				"type":   "category",
-				"tick":   map[string]any{"rotate": "75", "multiline": false},
-				"height": 0,
-			},
-			"y": map[string]any{
-				"label": "Number of clues",
-			},
-		},
+	return &Section{
+		Order:    c.Order(),
+		Title:    spec.Title,
+		Preamble: "A stacked bar showing the total number of Across and Down clues each setter has written. For a standard 15×15 grid you would expect roughly equal numbers, so large imbalances can indicate a preference for one direction or a different grid style.",
+		Charts:   []*ChartSpec{spec},
+	}, nil
+}
+
+func (c *Chart10) Render(db *sql.DB, tmpls *Templates, opts Options) (string, *Table, error) {
+	sec, err := c.Series(db, opts)
+	if err != nil {
+		return "", nil, err
 	}
+	spec := sec.Charts[0]
 
 	tmplData := map[string]any{
-		"Title":        "Across vs Down clue balance per setter",
-		"Preamble":     "A stacked bar showing the total number of Across and Down clues each setter has written. For a standard 15×15 grid you would expect roughly equal numbers, so large imbalances can indicate a preference for one direction or a different grid style.",
+		"Title":        sec.Title,
+		"Preamble":     sec.Preamble,
 		"Order":        10,
 		"DivID":        "mychart10",
 		"JSVar":        "chart10",
 		"DefaultChart": "bar",
-		"ChartJSON":    toJSON(chartDef),
+		"ChartJSON":    toJSON(c3Def(spec, "mychart10")),
 	}
-	return executeTemplate(tmplDir, "chart.tmpl", tmplData)
+	return renderSeries(tmpls, sec, "chart.tmpl", tmplData)
 }
@@ -3,7 +3,8 @@ package charts
 import (
 	"database/sql"
 	"sort"
-	"time"
+	"strconv"
+	"strings"
 )
 
 // Chart11 generates "New setters per year" bar chart.
@@ -13,7 +14,16 @@ type Chart11 struct{}
 
 func (c *Chart11) Order() string { return "11" }
 
-func (c *Chart11) Render(db *sql.DB, tmplDir string) (string, error) {
+// debutYear is one year on the x axis and the setters who debuted in it.
+type debutYear struct {
+	Year    int
+	Count   int
+	Setters []string
+}
+
+// load returns one entry per year from the earliest debut to the year
+// being rendered as of.
+func (c *Chart11) load(db *sql.DB, opts Options) ([]debutYear, error) {
 	rows, err := db.Query(`
 		SELECT creator_name,
 		       CAST(EXTRACT(YEAR FROM MIN(date)) AS INTEGER) AS debut_year
@@ -22,7 +32,7 @@ func (c *Chart11) Render(db *sql.DB, tmplDir string) (string, error) {
 		ORDER BY debut_year, creator_name
 	`)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	defer rows.Close()
 
@@ -32,13 +42,13 @@ func (c *Chart11) Render(db *sql.DB, tmplDir string) (string, error) {
 		var name string
 		var year int
 		if err := rows.Scan(&name, &year); err != nil {
-			return "", err
+			return nil, err
 		}
 		debutNames[year] = append(debutNames[year], name)
 	}
 
 	// Build a contiguous year range
-	currentYear := time.Now().Year()
+	currentYear := opts.now().Year()
 	minYear := currentYear
 	for y := range debutNames {
 		if y < minYear {
@@ -46,49 +56,65 @@ func (c *Chart11) Render(db *sql.DB, tmplDir string) (string, error) {
 		}
 	}
 
-	type yearEntry struct {
-		Year    int
-		Count   int
-		Setters []string
-	}
-	var results []yearEntry
+	var results []debutYear
 	for y := minYear; y <= currentYear; y++ {
 		names := debutNames[y]
-		results = append(results, yearEntry{
+		results = append(results, debutYear{
 			Year:    y,
 			Count:   len(names),
 			Setters: names,
 		})
 	}
 	sort.Slice(results, func(i, j int) bool { return results[i].Year < results[j].Year })
+	return results, nil
+}
 
-	labels := make([]int, len(results))
-	values := []any{"New setters"}
-	for i, r := range results {
-		labels[i] = r.Year
-		values = append(values, r.Count)
+func (c *Chart11) series(results []debutYear) *ChartSpec {
+	spec := &ChartSpec{
+		ID:              "chart11",
+		Title:           "New setters making their debut, per year",
+		Kind:            KindBar,
+		Series:          []Series{{Name: "New setters"}},
+		XAxis:           Axis{TickRotate: 75},
+		YAxis:           Axis{Label: "New setters making their debut", Min: limit(0)},
+		Height:          400,
+		ColorByCategory: true,
+	}
+	for _, r := range results {
+		spec.Categories = append(spec.Categories, strconv.Itoa(r.Year))
+		spec.Series[0].Values = append(spec.Series[0].Values, float64(r.Count))
+		spec.Tooltips = append(spec.Tooltips, strings.Join(r.Setters, ", "))
+	}
+	return spec
+}
+
+const chart11Preamble = "How many distinct setters made their Guardian crossword debut each year.  Hover over a bar to see who debuted that year.  Shows how the pool of contributors has grown (or shrunk) over time."
+
+func (c *Chart11) Series(db *sql.DB, opts Options) (*Section, error) {
+	results, err := c.load(db, opts)
+	if err != nil {
+		return nil, err
+	}
+	return c.section(results), nil
+}
+
+func (c *Chart11) section(results []debutYear) *Section {
+	spec := c.series(results)
+	return &Section{
+		Order:    c.Order(),
+		Title:    spec.Title,
+		Preamble: chart11Preamble,
+		Charts:   []*ChartSpec{spec},
 	}
+}
 
-	chartDef := map[string]any{
-		"bindto": "#mychart11",
-		"size":   map[string]any{"height": 400},
-		"data": map[string]any{
-			"columns": []any{values},
-			"type":    "bar",
-		},
-		"axis": map[string]any{
-			"x": map[string]any{
-				"type":       "category",
-				"tick":       map[string]any{"rotate": "75", "multiline": false},
-				"height":     0,
-				"categories": labels,
-			},
-			"y": map[string]any{
-				"label": "New setters making their debut",
-				"min":   0,
-			},
-		},
+func (c *Chart11) Render(db *sql.DB, tmpls *Templates, opts Options) (string, *Table, error) {
+	results, err := c.load(db, opts)
+	if err != nil {
+		return "", nil, err
 	}
+	sec := c.section(results)
+	spec := sec.Charts[0]
 
 	// Build the year->names lookup for the JS tooltip.
 	// Keyed by the x-axis index (0-based position in the year range) so the
@@ -107,15 +133,15 @@ func (c *Chart11) Render(db *sql.DB, tmplDir string) (string, error) {
 	}
 
 	data := map[string]any{
-		"Title":        "New setters making their debut, per year",
-		"Preamble":     "How many distinct setters made their Guardian crossword debut each year.  Hover over a bar to see who debuted that year.  Shows how the pool of contributors has grown (or shrunk) over time.",
+		"Title":        spec.Title,
+		"Preamble":     chart11Preamble,
 		"Order":        11,
 		"DivID":        "mychart11",
 		"JSVar":        "chart11",
 		"DefaultChart": "bar",
-		"ChartJSON":    toJSON(chartDef),
+		"ChartJSON":    toJSON(c3Def(spec, "mychart11")),
 		"DebutIndex":   toJSON(debutIndex),
 		"BarColorJS":   barColorJS("chart11"),
 	}
-	return executeTemplate(tmplDir, "chart11.tmpl", data)
+	return renderSeries(tmpls, sec, "chart11.tmpl", data)
 }
@@ -16,7 +16,7 @@ var monthNames = []string{
 	"Jul", "Aug", "Sep", "Oct", "Nov", "Dec",
 }
 
-func (c *Chart12) Render(db *sql.DB, tmplDir string) (string, error) {
+func (c *Chart12) Series(db *sql.DB, opts Options) (*Section, error) {
 	rows, err := db.Query(`
 		SELECT CAST(EXTRACT(MONTH FROM date) AS INTEGER) AS month,
 		       COUNT(*) AS cnt
@@ -25,57 +25,60 @@ func (c *Chart12) Render(db *sql.DB, tmplDir string) (string, error) {
 		ORDER BY month
 	`)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	defer rows.Close()
 
 	// Index 0 = January (month 1)
-	counts := make([]int, 12)
+	counts := make([]float64, 12)
 	for rows.Next() {
 		var month, cnt int
 		if err := rows.Scan(&month, &cnt); err != nil {
-			return "", err
+			return nil, err
 		}
 		if month >= 1 && month <= 12 {
-			counts[month-1] = cnt
+			counts[month-1] = float64(cnt)
 		}
 	}
 
 	labels := make([]string, 12)
 	copy(labels, monthNames)
 
-	values := []any{"Crosswords"}
-	for _, v := range counts {
-		values = append(values, v)
+	spec := &ChartSpec{
+		ID:              "chart12",
+		Title:           "Crosswords published by month of year",
+		Kind:            KindBar,
+		Categories:      labels,
+		Series:          []Series{{Name: "Crosswords", Values: counts}},
+		YAxis:           Axis{Label: "Number of crosswords published"},
+		Height:          400,
+		ColorByCategory: true,
 	}
 
-	chartDef := map[string]any{
-		"bindto": "#mychart12",
-		"size":   map[string]any{"height": 400},
-		"data": map[string]any{
-			"columns": []any{values},
-			"type":    "bar",
-		},
-		"axis": map[string]any{
-			"x": map[string]any{
-				"type":       "category",
-				"categories": labels,
-			},
-			"y": map[string]any{
-				"label": "Number of crosswords published",
-			},
-		},
+	return &Section{
+		Order:    c.Order(),
+		Title:    spec.Title,
+		Preamble: "Total number of crosswords published in each calendar month, summed across all years and setters.  Dips in August and December can reflect holiday periods when fewer puzzles are commissioned.",
+		Charts:   []*ChartSpec{spec},
+	}, nil
+}
+
+func (c *Chart12) Render(db *sql.DB, tmpls *Templates, opts Options) (string, *Table, error) {
+	sec, err := c.Series(db, opts)
+	if err != nil {
+		return "", nil, err
 	}
+	spec := sec.Charts[0]
 
 	data := map[string]any{
-		"Title":        "Crosswords published by month of year",
-		"Preamble":     "Total number of crosswords published in each calendar month, summed across all years and setters.  Dips in August and December can reflect holiday periods when fewer puzzles are commissioned.",
+		"Title":        sec.Title,
+		"Preamble":     sec.Preamble,
 		"Order":        12,
 		"DivID":        "mychart12",
 		"JSVar":        "chart12",
 		"DefaultChart": "bar",
-		"ChartJSON":    toJSON(chartDef),
+		"ChartJSON":    toJSON(c3Def(spec, "mychart12")),
 		"BarColorJS":   barColorJS("chart12"),
 	}
-	return executeTemplate(tmplDir, "chart.tmpl", data)
+	return renderSeries(tmpls, sec, "chart.tmpl", data)
 }
@@ -15,7 +15,17 @@ type Chart13 struct{}
 
 func (c *Chart13) Order() string { return "13" }
 
-func (c *Chart13) Render(db *sql.DB, tmplDir string) (string, error) {
+// weekStreak is a setter's longest run of consecutive weeks.
+type weekStreak struct {
+	Setter      string `json:"setter"`
+	Weeks       int    `json:"weeks"`
+	StreakStart string `json:"start"`
+	StreakEnd   string `json:"end"`
+	Puzzles     int    `json:"puzzles"`
+}
+
+// load returns each setter's longest week streak, longest first.
+func (c *Chart13) load(db *sql.DB) ([]weekStreak, error) {
 	// Gaps-and-islands approach:
 	//  1. Collapse each (setter, week) to one row.
 	//  2. Number each setter's weeks with ROW_NUMBER() in chronological order.
@@ -67,29 +77,21 @@ func (c *Chart13) Render(db *sql.DB, tmplDir string) (string, error) {
 		JOIN best b
 		  ON g.creator_name = b.creator_name
 		 AND g.streak_weeks  = b.best_weeks
-		ORDER BY g.streak_weeks DESC, g.creator_name
+		ORDER BY g.streak_weeks DESC, g.creator_name, g.streak_start
 	`)
 	if err != nil {
-		return "", fmt.Errorf("chart13 query: %w", err)
+		return nil, fmt.Errorf("chart13 query: %w", err)
 	}
 	defer rows.Close()
 
-	type streakEntry struct {
-		Setter      string `json:"setter"`
-		Weeks       int    `json:"weeks"`
-		StreakStart string `json:"start"`
-		StreakEnd   string `json:"end"`
-		Puzzles     int    `json:"puzzles"`
-	}
-
 	// One entry per setter – if a setter has two equal-length best streaks we
 	// take the first one returned (earliest chronologically via ORDER BY).
 	seen := make(map[string]bool)
-	var streaks []streakEntry
+	var streaks []weekStreak
 	for rows.Next() {
-		var e streakEntry
+		var e weekStreak
 		if err := rows.Scan(&e.Setter, &e.Weeks, &e.StreakStart, &e.StreakEnd, &e.Puzzles); err != nil {
-			return "", fmt.Errorf("chart13 scan: %w", err)
+			return nil, fmt.Errorf("chart13 scan: %w", err)
 		}
 		if seen[e.Setter] {
 			continue
@@ -98,51 +100,69 @@ func (c *Chart13) Render(db *sql.DB, tmplDir string) (string, error) {
 		streaks = append(streaks, e)
 	}
 	if err := rows.Err(); err != nil {
-		return "", fmt.Errorf("chart13 rows: %w", err)
+		return nil, fmt.Errorf("chart13 rows: %w", err)
 	}
 
-	// Build C3 columns: x-axis = setter name, y = streak weeks.
-	setterLabels := make([]string, len(streaks))
-	weekValues := []any{"Consecutive weeks"}
-	for i, s := range streaks {
-		setterLabels[i] = s.Setter
-		weekValues = append(weekValues, s.Weeks)
+	return streaks, nil
+}
+
+func (c *Chart13) series(streaks []weekStreak) *ChartSpec {
+	spec := &ChartSpec{
+		ID:              "chart13",
+		Title:           "Longest consecutive week-on-week streaks per setter",
+		Kind:            KindBar,
+		Series:          []Series{{Name: "Consecutive weeks"}},
+		XAxis:           Axis{TickRotate: 75, Height: 130},
+		YAxis:           Axis{Label: "Longest consecutive week streak", Min: limit(0)},
+		Height:          500,
+		ColorByCategory: true,
+	}
+	for _, e := range streaks {
+		spec.Categories = append(spec.Categories, e.Setter)
+		spec.Series[0].Values = append(spec.Series[0].Values, float64(e.Weeks))
+		spec.Tooltips = append(spec.Tooltips, fmt.Sprintf("%s to %s, %d puzzles", e.StreakStart, e.StreakEnd, e.Puzzles))
 	}
+	return spec
+}
+
+const chart13Preamble = "For each setter, the longest run of consecutive ISO weeks in which they published at least one crossword.  Hover a bar to see when the streak started and ended, and how many puzzles were published during it."
 
-	chartDef := map[string]any{
-		"bindto": "#mychart13",
-		"size":   map[string]any{"height": 500},
-		"data": map[string]any{
-			"columns": []any{weekValues},
-			"type":    "bar",
-		},
-		"axis": map[string]any{
-			"x": map[string]any{
-				"type":       "category",
-				"categories": setterLabels,
-				"tick": map[string]any{
-					"rotate":    75,
-					"multiline": false,
-				},
-				"height": 130,
-			},
-			"y": map[string]any{
-				"label": "Longest consecutive week streak",
-				"min":   0,
-			},
-		},
+func (c *Chart13) Series(db *sql.DB, opts Options) (*Section, error) {
+	streaks, err := c.load(db)
+	if err != nil {
+		return nil, err
+	}
+	return c.section(streaks), nil
+}
+
+func (c *Chart13) section(streaks []weekStreak) *Section {
+	spec := c.series(streaks)
+	return &Section{
+		Order:    c.Order(),
+		Title:    spec.Title,
+		Preamble: chart13Preamble,
+		Charts:   []*ChartSpec{spec},
+	}
+}
+
+func (c *Chart13) Render(db *sql.DB, tmpls *Templates, opts Options) (string, *Table, error) {
+	streaks, err := c.load(db)
+	if err != nil {
+		return "", nil, err
 	}
+	sec := c.section(streaks)
+	spec := sec.Charts[0]
 
 	tmplData := map[string]any{
-		"Title":        "Longest consecutive week-on-week streaks per setter",
-		"Preamble":     "For each setter, the longest run of consecutive ISO weeks in which they published at least one crossword.  Hover a bar to see when the streak started and ended, and how many puzzles were published during it.",
+		"Title":        spec.Title,
+		"Preamble":     chart13Preamble,
 		"Order":        13,
 		"DivID":        "mychart13",
 		"JSVar":        "chart13",
 		"DefaultChart": "bar",
-		"ChartJSON":    toJSON(chartDef),
+		"ChartJSON":    toJSON(c3Def(spec, "mychart13")),
 		"StreakIndex":  toJSON(streaks),
 		"BarColorJS":   barColorJS("chart13"),
 	}
-	return executeTemplate(tmplDir, "chart13.tmpl", tmplData)
+	return renderSeries(tmpls, sec, "chart13.tmpl", tmplData)
 }
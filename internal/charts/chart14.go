@@ -15,7 +15,17 @@ type Chart14 struct{}
 
 func (c *Chart14) Order() string { return "14" }
 
-func (c *Chart14) Render(db *sql.DB, tmplDir string) (string, error) {
+// monthStreak is a setter's longest run of consecutive months.
+type monthStreak struct {
+	Setter      string `json:"setter"`
+	Months      int    `json:"months"`
+	StreakStart string `json:"start"`
+	StreakEnd   string `json:"end"`
+	Puzzles     int    `json:"puzzles"`
+}
+
+// load returns each setter's longest month streak, longest first.
+func (c *Chart14) load(db *sql.DB) ([]monthStreak, error) {
 	// Gaps-and-islands approach (same pattern as Chart13 but over calendar months):
 	//  yr_mo = YEAR*100 + MONTH is a monotonically increasing integer where
 	//  consecutive months differ by 1 (except the Dec→Jan boundary: 12→13, not 12→101).
@@ -68,27 +78,19 @@ func (c *Chart14) Render(db *sql.DB, tmplDir string) (string, error) {
 		JOIN best b
 		  ON g.creator_name = b.creator_name
 		 AND g.streak_months = b.best_months
-		ORDER BY g.streak_months DESC, g.creator_name
+		ORDER BY g.streak_months DESC, g.creator_name, g.streak_start
 	`)
 	if err != nil {
-		return "", fmt.Errorf("chart14 query: %w", err)
+		return nil, fmt.Errorf("chart14 query: %w", err)
 	}
 	defer rows.Close()
 
-	type streakEntry struct {
-		Setter      string `json:"setter"`
-		Months      int    `json:"months"`
-		StreakStart string `json:"start"`
-		StreakEnd   string `json:"end"`
-		Puzzles     int    `json:"puzzles"`
-	}
-
 	seen := make(map[string]bool)
-	var streaks []streakEntry
+	var streaks []monthStreak
 	for rows.Next() {
-		var e streakEntry
+		var e monthStreak
 		if err := rows.Scan(&e.Setter, &e.Months, &e.StreakStart, &e.StreakEnd, &e.Puzzles); err != nil {
-			return "", fmt.Errorf("chart14 scan: %w", err)
+			return nil, fmt.Errorf("chart14 scan: %w", err)
 		}
 		if seen[e.Setter] {
 			continue
@@ -97,50 +99,69 @@ func (c *Chart14) Render(db *sql.DB, tmplDir string) (string, error) {
 		streaks = append(streaks, e)
 	}
 	if err := rows.Err(); err != nil {
-		return "", fmt.Errorf("chart14 rows: %w", err)
+		return nil, fmt.Errorf("chart14 rows: %w", err)
 	}
 
-	setterLabels := make([]string, len(streaks))
-	monthValues := []any{"Consecutive months"}
-	for i, s := range streaks {
-		setterLabels[i] = s.Setter
-		monthValues = append(monthValues, s.Months)
+	return streaks, nil
+}
+
+func (c *Chart14) series(streaks []monthStreak) *ChartSpec {
+	spec := &ChartSpec{
+		ID:              "chart14",
+		Title:           "Longest consecutive month-on-month streaks per setter",
+		Kind:            KindBar,
+		Series:          []Series{{Name: "Consecutive months"}},
+		XAxis:           Axis{TickRotate: 75, Height: 130},
+		YAxis:           Axis{Label: "Longest consecutive month streak", Min: limit(0)},
+		Height:          500,
+		ColorByCategory: true,
+	}
+	for _, e := range streaks {
+		spec.Categories = append(spec.Categories, e.Setter)
+		spec.Series[0].Values = append(spec.Series[0].Values, float64(e.Months))
+		spec.Tooltips = append(spec.Tooltips, fmt.Sprintf("%s to %s, %d puzzles", e.StreakStart, e.StreakEnd, e.Puzzles))
 	}
+	return spec
+}
+
+const chart14Preamble = "For each setter, the longest run of consecutive calendar months in which they published at least one crossword.  Hover a bar to see when the streak started and ended, and how many puzzles were published during it."
 
-	chartDef := map[string]any{
-		"bindto": "#mychart14",
-		"size":   map[string]any{"height": 500},
-		"data": map[string]any{
-			"columns": []any{monthValues},
-			"type":    "bar",
-		},
-		"axis": map[string]any{
-			"x": map[string]any{
-				"type":       "category",
-				"categories": setterLabels,
-				"tick": map[string]any{
-					"rotate":    75,
-					"multiline": false,
-				},
-				"height": 130,
-			},
-			"y": map[string]any{
-				"label": "Longest consecutive month streak",
-				"min":   0,
-			},
-		},
+func (c *Chart14) Series(db *sql.DB, opts Options) (*Section, error) {
+	streaks, err := c.load(db)
+	if err != nil {
+		return nil, err
+	}
+	return c.section(streaks), nil
+}
+
+func (c *Chart14) section(streaks []monthStreak) *Section {
+	spec := c.series(streaks)
+	return &Section{
+		Order:    c.Order(),
+		Title:    spec.Title,
+		Preamble: chart14Preamble,
+		Charts:   []*ChartSpec{spec},
+	}
+}
+
+func (c *Chart14) Render(db *sql.DB, tmpls *Templates, opts Options) (string, *Table, error) {
+	streaks, err := c.load(db)
+	if err != nil {
+		return "", nil, err
 	}
+	sec := c.section(streaks)
+	spec := sec.Charts[0]
 
 	tmplData := map[string]any{
-		"Title":        "Longest consecutive month-on-month streaks per setter",
-		"Preamble":     "For each setter, the longest run of consecutive calendar months in which they published at least one crossword.  Hover a bar to see when the streak started and ended, and how many puzzles were published during it.",
+		"Title":        spec.Title,
+		"Preamble":     chart14Preamble,
 		"Order":        14,
 		"DivID":        "mychart14",
 		"JSVar":        "chart14",
 		"DefaultChart": "bar",
-		"ChartJSON":    toJSON(chartDef),
+		"ChartJSON":    toJSON(c3Def(spec, "mychart14")),
 		"StreakIndex":  toJSON(streaks),
 		"BarColorJS":   barColorJS("chart14"),
 	}
-	return executeTemplate(tmplDir, "chart14.tmpl", tmplData)
+	return renderSeries(tmpls, sec, "chart14.tmpl", tmplData)
 }
@@ -3,7 +3,7 @@ package charts
 import (
 	"database/sql"
 	"sort"
-	"time"
+	"strconv"
 )
 
 // Chart2 generates "Crosswords per year, per setter" area chart.
@@ -11,7 +11,7 @@ type Chart2 struct{}
 
 func (c *Chart2) Order() string { return "2" }
 
-func (c *Chart2) Render(db *sql.DB, tmplDir string) (string, error) {
+func (c *Chart2) Series(db *sql.DB, opts Options) (*Section, error) {
 	rows, err := db.Query(`
 		SELECT creator_name AS name,
 		       CAST(EXTRACT(YEAR FROM date) AS INTEGER) AS year,
@@ -21,7 +21,7 @@ func (c *Chart2) Render(db *sql.DB, tmplDir string) (string, error) {
 		ORDER BY creator_name, year
 	`)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	defer rows.Close()
 
@@ -31,7 +31,7 @@ func (c *Chart2) Render(db *sql.DB, tmplDir string) (string, error) {
 		var name string
 		var year, count int
 		if err := rows.Scan(&name, &year, &count); err != nil {
-			return "", err
+			return nil, err
 		}
 		if data[name] == nil {
 			data[name] = make(map[int]int)
@@ -45,50 +45,55 @@ func (c *Chart2) Render(db *sql.DB, tmplDir string) (string, error) {
 	}
 	sort.Strings(setters)
 
-	currentYear := time.Now().Year()
+	currentYear := opts.now().Year()
 	yearRange := make([]int, 0)
 	for y := 1998; y <= currentYear; y++ {
 		yearRange = append(yearRange, y)
 	}
 
-	columns := make([]any, 0, len(setters))
+	spec := &ChartSpec{
+		ID:          "chart2",
+		Title:       "Crosswords per year, per setter",
+		Kind:        KindArea,
+		XAxis:       Axis{TickRotate: 75},
+		YAxis:       Axis{Label: "Crosswords per year"},
+		Height:      800,
+		HideTooltip: true,
+	}
+	for _, y := range yearRange {
+		spec.Categories = append(spec.Categories, strconv.Itoa(y))
+	}
 	for _, name := range setters {
-		row := []any{name}
+		s := Series{Name: name}
 		for _, y := range yearRange {
-			row = append(row, data[name][y]) // 0 if missing
+			s.Values = append(s.Values, float64(data[name][y])) // 0 if missing
 		}
-		columns = append(columns, row)
+		spec.Series = append(spec.Series, s)
 	}
 
-	chartDef := map[string]any{
-		"bindto": "#mychart2",
-		"size":   map[string]any{"height": 800},
-		"data": map[string]any{
-			"columns": columns,
-			"type":    "area",
-		},
-		"tooltip": map[string]any{"show": false},
-		"axis": map[string]any{
-			"x": map[string]any{
-				"type":       "category",
-				"tick":       map[string]any{"rotate": "75", "multiline": false},
-				"height":     0,
-				"categories": yearRange,
-			},
-			"y": map[string]any{
-				"label": "Crosswords per year",
-			},
-		},
+	return &Section{
+		Order:    c.Order(),
+		Title:    spec.Title,
+		Preamble: "This chart shows an area span for the number of crosswords set per setter, per year.  Interesting to see when a setter started and stopped.  Hover over a legend entry to isolate that setter.",
+		Charts:   []*ChartSpec{spec},
+	}, nil
+}
+
+func (c *Chart2) Render(db *sql.DB, tmpls *Templates, opts Options) (string, *Table, error) {
+	sec, err := c.Series(db, opts)
+	if err != nil {
+		return "", nil, err
 	}
+	spec := sec.Charts[0]
 
 	tmplData := map[string]any{
-		"Title":        "Crosswords per year, per setter",
-		"Preamble":     "This chart shows an area span for the number of crosswords set per setter, per year.  Interesting to see when a setter started and stopped.  Hover over a legend entry to isolate that setter.",
+		"Title":        sec.Title,
+		"Preamble":     sec.Preamble,
 		"Order":        2,
 		"DivID":        "mychart2",
 		"JSVar":        "chart2",
 		"DefaultChart": "area",
-		"ChartJSON":    toJSON(chartDef),
+		"ChartJSON":    toJSON(c3Def(spec, "mychart2")),
 	}
-	return executeTemplate(tmplDir, "chart2.tmpl", tmplData)
+	return renderSeries(tmpls, sec, "chart2.tmpl", tmplData)
 }
@@ -10,7 +10,7 @@ type Chart3 struct{}
 
 func (c *Chart3) Order() string { return "3" }
 
-func (c *Chart3) Render(db *sql.DB, tmplDir string) (string, error) {
+func (c *Chart3) Series(db *sql.DB, opts Options) (*Section, error) {
 	rows, err := db.Query(`
 		SELECT name, MAX(cnt) AS max_count
 		FROM (
@@ -23,10 +23,10 @@ func (c *Chart3) Render(db *sql.DB, tmplDir string) (string, error) {
 			HAVING COUNT(*) > 1
 		) sub
 		GROUP BY name
-		ORDER BY max_count DESC
+		ORDER BY max_count DESC, name
 	`)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	defer rows.Close()
 
@@ -38,52 +38,56 @@ func (c *Chart3) Render(db *sql.DB, tmplDir string) (string, error) {
 	for rows.Next() {
 		var e entry
 		if err := rows.Scan(&e.name, &e.count); err != nil {
-			return "", err
+			return nil, err
 		}
 		results = append(results, e)
 	}
 
-	// Sort by count descending
+	// Sort by count descending, then by name
 	sort.Slice(results, func(i, j int) bool {
-		return results[i].count > results[j].count
+		if results[i].count != results[j].count {
+			return results[i].count > results[j].count
+		}
+		return results[i].name < results[j].name
 	})
 
-	labels := make([]string, len(results))
-	values := []any{"Setters"}
-	for i, r := range results {
-		labels[i] = r.name
-		values = append(values, r.count)
+	spec := &ChartSpec{
+		ID:     "chart3",
+		Title:  "Frequency of word duplications across all crosswords, per setter",
+		Kind:   KindBar,
+		Series: []Series{{Name: "Setters"}},
+		XAxis:  Axis{TickRotate: 75},
+		YAxis:  Axis{Label: "Frequency of duplicated answers", TickSteps: 20},
+		Height: 800,
+	}
+	for _, r := range results {
+		spec.Categories = append(spec.Categories, r.name)
+		spec.Series[0].Values = append(spec.Series[0].Values, float64(r.count))
 	}
 
-	chartDef := map[string]any{
-		"bindto": "#mychart3",
-		"size":   map[string]any{"height": 800},
-		"data": map[string]any{
-			"columns": []any{values},
-			"type":    "bar",
-		},
-		"axis": map[string]any{
-			"x": map[string]any{
-				"type":       "category",
-				"tick":       map[string]any{"rotate": "75", "multiline": false},
-				"height":     0,
-				"categories": labels,
-			},
-			"y": map[string]any{
-				"label": "Frequency of duplicated answers",
-				"tick":  map[string]any{"steps": 20},
-			},
-		},
+	return &Section{
+		Order:    c.Order(),
+		Title:    spec.Title,
+		Preamble: "This chart shows the number of words a given setter has used more than once, across all crosswords for that setter.",
+		Charts:   []*ChartSpec{spec},
+	}, nil
+}
+
+func (c *Chart3) Render(db *sql.DB, tmpls *Templates, opts Options) (string, *Table, error) {
+	sec, err := c.Series(db, opts)
+	if err != nil {
+		return "", nil, err
 	}
+	spec := sec.Charts[0]
 
 	data := map[string]any{
-		"Title":        "Frequency of word duplications across all crosswords, per setter",
-		"Preamble":     "This chart shows the number of words a given setter has used more than once, across all crosswords for that setter.",
+		"Title":        sec.Title,
+		"Preamble":     sec.Preamble,
 		"Order":        3,
 		"DivID":        "mychart3",
 		"JSVar":        "chart3",
 		"DefaultChart": "bar",
-		"ChartJSON":    toJSON(chartDef),
+		"ChartJSON":    toJSON(c3Def(spec, "mychart3")),
 	}
-	return executeTemplate(tmplDir, "chart.tmpl", data)
+	return renderSeries(tmpls, sec, "chart.tmpl", data)
 }
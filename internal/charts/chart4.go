@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"math"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -35,7 +36,14 @@ type graphEntry struct {
 	PMonth int
 }
 
-func (c *Chart4) Render(db *sql.DB, tmplDir string) (string, error) {
+const (
+	chart4Title    = "Setter Biographies"
+	chart4Preamble = "This shows information about each setter"
+)
+
+// load gathers the per-setter statistics and returns them along with the
+// setter names in display order.
+func (c *Chart4) load(db *sql.DB) (map[string]*setterInfo, []string, error) {
 	setters := make(map[string]*setterInfo)
 
 	// 1. Date ranges per setter
@@ -48,14 +56,14 @@ func (c *Chart4) Render(db *sql.DB, tmplDir string) (string, error) {
 		ORDER BY creator_name
 	`)
 	if err != nil {
-		return "", err
+		return nil, nil, err
 	}
 	defer rangeRows.Close()
 
 	for rangeRows.Next() {
 		var name, firstDate, lastDate string
 		if err := rangeRows.Scan(&name, &firstDate, &lastDate); err != nil {
-			return "", err
+			return nil, nil, err
 		}
 		// Trim to just date portion (YYYY-MM-DD)
 		firstDate = strings.SplitN(firstDate, " ", 2)[0]
@@ -82,7 +90,7 @@ func (c *Chart4) Render(db *sql.DB, tmplDir string) (string, error) {
 		GROUP BY c.creator_name
 	`)
 	if err != nil {
-		return "", err
+		return nil, nil, err
 	}
 	defer selfRows.Close()
 
@@ -90,7 +98,7 @@ func (c *Chart4) Render(db *sql.DB, tmplDir string) (string, error) {
 		var name string
 		var count int
 		if err := selfRows.Scan(&name, &count); err != nil {
-			return "", err
+			return nil, nil, err
 		}
 		if s, ok := setters[name]; ok {
 			s.SelfRefCount = count
@@ -109,7 +117,7 @@ func (c *Chart4) Render(db *sql.DB, tmplDir string) (string, error) {
 		ORDER BY creator_name, year, crossword_type
 	`)
 	if err != nil {
-		return "", err
+		return nil, nil, err
 	}
 	defer graphRows.Close()
 
@@ -117,7 +125,7 @@ func (c *Chart4) Render(db *sql.DB, tmplDir string) (string, error) {
 		var name, ctype string
 		var year, count, pmonth int
 		if err := graphRows.Scan(&name, &year, &ctype, &count, &pmonth); err != nil {
-			return "", err
+			return nil, nil, err
 		}
 		s, ok := setters[name]
 		if !ok {
@@ -133,6 +141,69 @@ func (c *Chart4) Render(db *sql.DB, tmplDir string) (string, error) {
 		}
 	}
 
+	names := make([]string, 0, len(setters))
+	for k := range setters {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return setters, names, nil
+}
+
+// setterSeries builds the per-year chart data for the i'th setter.
+func setterSeries(i int, name string, s *setterInfo) *ChartSpec {
+	years := make([]int, 0, len(s.YearCounts))
+	for y := range s.YearCounts {
+		years = append(years, y)
+	}
+	sort.Ints(years)
+
+	spec := &ChartSpec{
+		ID:         fmt.Sprintf("chart4-%d", i),
+		Title:      name,
+		Kind:       KindArea,
+		Series:     []Series{{Name: name}, {Name: "Average per month"}},
+		XAxis:      Axis{TickRotate: 75},
+		YAxis:      Axis{Label: "Number of crosswords", Min: limit(1), TickSteps: 1},
+		Width:      600,
+		Height:     200,
+		HideLegend: true,
+	}
+	for _, y := range years {
+		cnt := s.YearCounts[y]
+		spec.Categories = append(spec.Categories, strconv.Itoa(y))
+		spec.Series[0].Values = append(spec.Series[0].Values, float64(cnt))
+		spec.Series[1].Values = append(spec.Series[1].Values, math.Ceil(float64(cnt)/12))
+	}
+	return spec
+}
+
+func (c *Chart4) Series(db *sql.DB, opts Options) (*Section, error) {
+	setters, names, err := c.load(db)
+	if err != nil {
+		return nil, err
+	}
+	return c.section(setters, names), nil
+}
+
+func (c *Chart4) section(setters map[string]*setterInfo, names []string) *Section {
+	sec := &Section{
+		Order:    c.Order(),
+		Title:    chart4Title,
+		Preamble: chart4Preamble,
+	}
+	for i, name := range names {
+		sec.Charts = append(sec.Charts, setterSeries(i, name, setters[name]))
+	}
+	return sec
+}
+
+func (c *Chart4) Render(db *sql.DB, tmpls *Templates, opts Options) (string, *Table, error) {
+	setters, names, err := c.load(db)
+	if err != nil {
+		return "", nil, err
+	}
+	sec := c.section(setters, names)
+
 	// Build per-setter chart definitions
 	type setterChart struct {
 		DivID        string
@@ -148,55 +219,10 @@ func (c *Chart4) Render(db *sql.DB, tmplDir string) (string, error) {
 		ChartDef     string
 	}
 
-	names := make([]string, 0, len(setters))
-	for k := range setters {
-		names = append(names, k)
-	}
-	sort.Strings(names)
-
 	var chartsData []setterChart
 	for i, name := range names {
 		s := setters[name]
-
-		// Get sorted year labels
-		years := make([]int, 0, len(s.YearCounts))
-		for y := range s.YearCounts {
-			years = append(years, y)
-		}
-		sort.Ints(years)
-
-		yearLabels := make([]any, len(years))
-		valuesRow := []any{name}
-		avgRow := []any{"Average per month"}
-		for j, y := range years {
-			yearLabels[j] = y
-			cnt := s.YearCounts[y]
-			valuesRow = append(valuesRow, cnt)
-			avgRow = append(avgRow, int(math.Ceil(float64(cnt)/12)))
-		}
-
-		chartDef := map[string]any{
-			"bindto": fmt.Sprintf("#mychart4%d", i),
-			"size":   map[string]any{"height": 200, "width": 600},
-			"data": map[string]any{
-				"columns": []any{valuesRow, avgRow},
-				"type":    "area",
-			},
-			"axis": map[string]any{
-				"x": map[string]any{
-					"type":       "category",
-					"tick":       map[string]any{"rotate": "75", "multiline": false},
-					"height":     0,
-					"categories": yearLabels,
-				},
-				"y": map[string]any{
-					"label": "Number of crosswords",
-					"tick":  map[string]any{"steps": 1},
-					"min":   1,
-				},
-			},
-			"legend": map[string]any{"show": false},
-		}
+		spec := sec.Charts[i]
 
 		chartsData = append(chartsData, setterChart{
 			DivID:        fmt.Sprintf("mychart4%d", i),
@@ -209,18 +235,18 @@ func (c *Chart4) Render(db *sql.DB, tmplDir string) (string, error) {
 			TotalCryptic: s.TotalCryptic,
 			TotalPrize:   s.TotalPrize,
 			SelfRef:      s.SelfRefCount,
-			ChartDef:     toJSON(chartDef),
+			ChartDef:     toJSON(c3Def(spec, fmt.Sprintf("mychart4%d", i))),
 		})
 	}
 
 	data := map[string]any{
-		"Title":        "Setter Biographies",
-		"Preamble":     "This shows information about each setter",
+		"Title":        chart4Title,
+		"Preamble":     chart4Preamble,
 		"Order":        4,
 		"DefaultChart": "area",
 		"Charts":       chartsData,
 	}
-	return executeTemplate(tmplDir, "chart4.tmpl", data)
+	return renderSeries(tmpls, sec, "chart4.tmpl", data)
 }
 
 // formatDuration computes a human-readable duration like "5 years, 3 months, 12 days".
@@ -1,18 +1,20 @@
 package charts
 
-import (
-	"database/sql"
-	"fmt"
-	"strings"
-)
+import "database/sql"
 
 // Chart5 generates "Duplicate answers" DataTable.
 type Chart5 struct{}
 
 func (c *Chart5) Order() string { return "5" }
 
-func (c *Chart5) Render(db *sql.DB, tmplDir string) (string, error) {
-	rows, err := db.Query(`
+func (c *Chart5) Table() *TableDataset { return chart5Table }
+
+// chart5Table lists each answer a setter has used in more than one
+// crossword, with the clues given for it each time.
+var chart5Table = &TableDataset{
+	Name:     "chart5",
+	ajaxFile: "./ds_ajax.txt",
+	Query: `
 		WITH deduped AS (
 			SELECT DISTINCT e.crossword_id, e.solution, e.clue,
 			       c.creator_name, c.crossword_type,
@@ -25,59 +27,29 @@ func (c *Chart5) Render(db *sql.DB, tmplDir string) (string, error) {
 			  AND e.clue NOT SIMILAR TO 'See\s+(clues|special)\s+.*'
 			  AND e.clue NOT SIMILAR TO 'Follow\s+the\s+link\s+below\s+to\s+see\s+today''s\s+clues.*'
 		)
-		SELECT creator_name AS name,
-		       solution,
-		       COUNT(*) AS count,
-		       LIST(clue ORDER BY cw_number) AS clues,
-		       LIST(crossword_type ORDER BY cw_number) AS types,
-		       LIST('<a href="https://www.theguardian.com/' || cw_path || '">' || cw_number || '</a>' ORDER BY cw_number) AS urls
+		SELECT creator_name AS setter,
+		       solution AS answer,
+		       LIST(clue ORDER BY cw_number, cw_path, clue) AS clues,
+		       LIST(crossword_type ORDER BY cw_number, cw_path, clue) AS types,
+		       LIST(cw_path ORDER BY cw_number, cw_path, clue) AS paths,
+		       LIST(cw_number ORDER BY cw_number, cw_path, clue) AS numbers
 		FROM deduped
 		GROUP BY creator_name, solution
-		HAVING COUNT(DISTINCT crossword_id) > 1
-		ORDER BY creator_name, solution
-	`)
-	if err != nil {
-		return "", err
-	}
-	defer rows.Close()
-
-	var ajaxData [][]string
-	for rows.Next() {
-		var name, solution string
-		var count int
-		var rawClues, rawTypes, rawURLs any
-		if err := rows.Scan(&name, &solution, &count, &rawClues, &rawTypes, &rawURLs); err != nil {
-			return "", fmt.Errorf("scanning chart5 row: %w", err)
-		}
-
-		clues := toStringSlice(rawClues)
-		types := toStringSlice(rawTypes)
-		urls := toStringSlice(rawURLs)
-
-		clueStr := strings.Join(clues, "<br />")
-		typeStr := strings.Join(types, "<br />")
-		urlStr := strings.Join(urls, "<br />")
-
-		ajaxData = append(ajaxData, []string{name, solution, clueStr, typeStr, urlStr})
-	}
-
-	if err := writeAjaxFile("./ds_ajax.txt", ajaxData); err != nil {
-		return "", err
-	}
-
-	columns := []map[string]string{
-		{"title": "Setter"},
-		{"title": "Answer"},
-		{"title": "Clues"},
-		{"title": "Type"},
-		{"title": "Crossword"},
-	}
+		HAVING COUNT(DISTINCT crossword_id) > 1`,
+	OrderBy: "setter, answer",
+	Columns: []TableColumn{
+		{Title: "Setter", Field: "setter", Searchable: true},
+		{Title: "Answer", Field: "answer", Searchable: true},
+		{Title: "Clues", Field: "clues", Searchable: true},
+		{Title: "Type", Field: "types"},
+		{Title: "Crossword", Field: "numbers", Render: crosswordLinks, Export: crosswordExport},
+	},
+}
 
-	data := map[string]any{
+func (c *Chart5) Render(db *sql.DB, tmpls *Templates, opts Options) (string, *Table, error) {
+	return renderTable(db, tmpls, c, "chart5.tmpl", map[string]any{
 		"Title":    "Number of duplicate answers and their questions",
 		"Preamble": "This table shows the number of times a given clue has been used and the different questions which have been used to make up that clue.",
 		"Order":    5,
-		"Columns":  toJSON(columns),
-	}
-	return executeTemplate(tmplDir, "chart5.tmpl", data)
+	})
 }
@@ -1,27 +1,22 @@
 package charts
 
-import (
-	"database/sql"
-	"fmt"
-	"regexp"
-	"strings"
-)
+import "database/sql"
 
 // Chart5a generates "Duplicate clues" DataTable.
 type Chart5a struct{}
 
 func (c *Chart5a) Order() string { return "5a" }
 
-// skipClueRE matches residual placeholder clues that should be filtered out.
-// Most cross-references are resolved by the resolved_entries view; this
-// catches remaining edge cases (length-only entries, instruction links).
-var skipClueRE = regexp.MustCompile(
-	`^\s+\(\d+\)$` +
-		`|^Follow\s+the\s+link\s+below\s+to\s+see\s+today's\s+clues.*$`,
-)
+func (c *Chart5a) Table() *TableDataset { return chart5aTable }
 
-func (c *Chart5a) Render(db *sql.DB, tmplDir string) (string, error) {
-	rows, err := db.Query(`
+// chart5aTable lists each clue a setter has used in more than one
+// crossword.  Cross-references are resolved by the resolved_entries view;
+// the remaining placeholder clues (length-only entries, instruction
+// links) are filtered out here.
+var chart5aTable = &TableDataset{
+	Name:     "chart5a",
+	ajaxFile: "./ds_ajax5a.txt",
+	Query: `
 		WITH deduped AS (
 			SELECT DISTINCT e.crossword_id, e.clue, c.creator_name,
 			       c.crossword_type, c.id AS cw_path, c.number AS cw_number
@@ -33,69 +28,32 @@ func (c *Chart5a) Render(db *sql.DB, tmplDir string) (string, error) {
 			  AND e.clue NOT SIMILAR TO 'See\s+(clues|special)\s+.*'
 			  AND e.clue NOT SIMILAR TO 'Follow\s+the\s+link\s+below\s+to\s+see\s+today''s\s+clues.*'
 		)
-		SELECT creator_name AS name,
+		SELECT creator_name AS setter,
 		       clue,
-		       COUNT(*) AS count,
-		       LIST(clue ORDER BY cw_number) AS clues,
-		       LIST(crossword_type ORDER BY cw_number) AS types,
-		       LIST('<a href="https://www.theguardian.com/' || cw_path || '">' || cw_number || '</a>' ORDER BY cw_number) AS urls
+		       LIST(clue ORDER BY cw_number, cw_path, clue) AS clues,
+		       LIST(crossword_type ORDER BY cw_number, cw_path, clue) AS types,
+		       LIST(cw_path ORDER BY cw_number, cw_path, clue) AS paths,
+		       LIST(cw_number ORDER BY cw_number, cw_path, clue) AS numbers
 		FROM deduped
 		GROUP BY creator_name, clue
-		HAVING COUNT(DISTINCT crossword_id) > 1
-		ORDER BY creator_name, clue
-	`)
-	if err != nil {
-		return "", err
-	}
-	defer rows.Close()
-
-	var ajaxData [][]string
-	for rows.Next() {
-		var name, clue string
-		var count int
-		var rawClues, rawTypes, rawURLs any
-		if err := rows.Scan(&name, &clue, &count, &rawClues, &rawTypes, &rawURLs); err != nil {
-			return "", fmt.Errorf("scanning chart5a row: %w", err)
-		}
-
-		clues := toStringSlice(rawClues)
-		types := toStringSlice(rawTypes)
-		urls := toStringSlice(rawURLs)
-
-		// Filter out residual placeholder clues
-		var filtered []string
-		for _, cl := range clues {
-			if !skipClueRE.MatchString(cl) {
-				filtered = append(filtered, cl)
-			}
-		}
-		if len(filtered) == 0 || filtered[0] == "" {
-			continue
-		}
-
-		clueStr := strings.Join(filtered, "<br />")
-		typeStr := strings.Join(types, "<br />")
-		urlStr := strings.Join(urls, "<br />")
-
-		ajaxData = append(ajaxData, []string{name, clueStr, typeStr, urlStr})
-	}
-
-	if err := writeAjaxFile("./ds_ajax5a.txt", ajaxData); err != nil {
-		return "", err
-	}
-
-	columns := []map[string]string{
-		{"title": "Setter"},
-		{"title": "Clues"},
-		{"title": "Type"},
-		{"title": "Crossword"},
-	}
+		HAVING COUNT(DISTINCT crossword_id) > 1`,
+	OrderBy: "setter, clue",
+	Columns: []TableColumn{
+		{Title: "Setter", Field: "setter", Searchable: true},
+		{Title: "Clues", Field: "clue", Searchable: true, Render: func(row TableRow) string {
+			return fieldText(row["clues"])
+		}, Export: []ExportField{{Name: "Clues", Value: func(row TableRow) any {
+			return toStringSlice(row["clues"])
+		}}}},
+		{Title: "Type", Field: "types"},
+		{Title: "Crossword", Field: "numbers", Render: crosswordLinks, Export: crosswordExport},
+	},
+}
 
-	data := map[string]any{
+func (c *Chart5a) Render(db *sql.DB, tmpls *Templates, opts Options) (string, *Table, error) {
+	return renderTable(db, tmpls, c, "chart5a.tmpl", map[string]any{
 		"Title":    "Number of duplicate clues per setter",
 		"Preamble": "This table shows the number of times a given clue has been used per setter.",
 		"Order":    "5a",
-		"Columns":  toJSON(columns),
-	}
-	return executeTemplate(tmplDir, "chart5a.tmpl", data)
+	})
 }
@@ -3,7 +3,6 @@ package charts
 import (
 	"database/sql"
 	"fmt"
-	"strings"
 )
 
 // Chart6 generates "PDF crossword list" DataTable.
@@ -11,49 +10,36 @@ type Chart6 struct{}
 
 func (c *Chart6) Order() string { return "6" }
 
-func (c *Chart6) Render(db *sql.DB, tmplDir string) (string, error) {
-	rows, err := db.Query(`
-		SELECT creator_name AS name,
+func (c *Chart6) Table() *TableDataset { return chart6Table }
+
+// chart6Table lists every crossword that has a PDF version.
+var chart6Table = &TableDataset{
+	Name:     "chart6",
+	ajaxFile: "./ds_ajax2.txt",
+	Query: `
+		SELECT creator_name AS setter,
 		       number,
 		       pdf,
 		       CAST(date AS VARCHAR) AS date
 		FROM crosswords
-		WHERE pdf IS NOT NULL
-		ORDER BY creator_name, number
-	`)
-	if err != nil {
-		return "", err
-	}
-	defer rows.Close()
-
-	var ajaxData [][]string
-	for rows.Next() {
-		var name, number, pdf, date string
-		if err := rows.Scan(&name, &number, &pdf, &date); err != nil {
-			return "", err
-		}
-		// Trim to just the date portion
-		date = strings.SplitN(date, " ", 2)[0]
-
-		link := fmt.Sprintf(`<a href="%s">%s</a>`, pdf, number)
-		ajaxData = append(ajaxData, []string{name, link, date})
-	}
-
-	if err := writeAjaxFile("./ds_ajax2.txt", ajaxData); err != nil {
-		return "", err
-	}
-
-	columns := []map[string]string{
-		{"title": "Setter"},
-		{"title": "Crossword (PDF)"},
-		{"title": "Date published"},
-	}
+		WHERE pdf IS NOT NULL`,
+	OrderBy: "setter, number, pdf",
+	Columns: []TableColumn{
+		{Title: "Setter", Field: "setter", Searchable: true},
+		{Title: "Crossword (PDF)", Field: "number", Searchable: true, Render: func(row TableRow) string {
+			return fmt.Sprintf(`<a href="%s">%s</a>`, fieldText(row["pdf"]), fieldText(row["number"]))
+		}, Export: []ExportField{
+			{Name: "Crossword", Value: func(row TableRow) any { return plainValue(row["number"]) }},
+			{Name: "PDF", Value: func(row TableRow) any { return plainValue(row["pdf"]) }},
+		}},
+		{Title: "Date published", Field: "date", Searchable: true},
+	},
+}
 
-	data := map[string]any{
+func (c *Chart6) Render(db *sql.DB, tmpls *Templates, opts Options) (string, *Table, error) {
+	return renderTable(db, tmpls, c, "chart6.tmpl", map[string]any{
 		"Title":    "List of all crosswords by setter, which has a PDF version",
 		"Preamble": "This table shows the crossword number and a link to the PDF crossword, if available.",
 		"Order":    6,
-		"Columns":  toJSON(columns),
-	}
-	return executeTemplate(tmplDir, "chart6.tmpl", data)
+	})
 }
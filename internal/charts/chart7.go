@@ -9,62 +9,65 @@ type Chart7 struct{}
 
 func (c *Chart7) Order() string { return "7" }
 
-func (c *Chart7) Render(db *sql.DB, tmplDir string) (string, error) {
+func (c *Chart7) Series(db *sql.DB, opts Options) (*Section, error) {
 	rows, err := db.Query(`
 		SELECT e.solution,
 		       COUNT(*) AS cnt
 		FROM entries e
 		WHERE e.solution IS NOT NULL AND e.solution != ''
 		GROUP BY e.solution
-		ORDER BY cnt DESC
+		ORDER BY cnt DESC, e.solution
 		LIMIT 50
 	`)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	defer rows.Close()
 
-	labels := make([]string, 0, 50)
-	values := []any{"Count"}
+	spec := &ChartSpec{
+		ID:              "chart7",
+		Title:           "Most-used answers across all crosswords (top 50)",
+		Kind:            KindBar,
+		Series:          []Series{{Name: "Count"}},
+		XAxis:           Axis{TickRotate: 75},
+		YAxis:           Axis{Label: "Times used across all crosswords"},
+		Height:          600,
+		ColorByCategory: true,
+	}
 	for rows.Next() {
 		var solution string
 		var cnt int
 		if err := rows.Scan(&solution, &cnt); err != nil {
-			return "", err
+			return nil, err
 		}
-		labels = append(labels, solution)
-		values = append(values, cnt)
+		spec.Categories = append(spec.Categories, solution)
+		spec.Series[0].Values = append(spec.Series[0].Values, float64(cnt))
 	}
 
-	chartDef := map[string]any{
-		"bindto": "#mychart7",
-		"size":   map[string]any{"height": 600},
-		"data": map[string]any{
-			"columns": []any{values},
-			"type":    "bar",
-		},
-		"axis": map[string]any{
-			"x": map[string]any{
-				"type":       "category",
-				"tick":       map[string]any{"rotate": "75", "multiline": false},
-				"height":     0,
-				"categories": labels,
-			},
-			"y": map[string]any{
-				"label": "Times used across all crosswords",
-			},
-		},
+	return &Section{
+		Order:    c.Order(),
+		Title:    spec.Title,
+		Preamble: "The top 50 solutions that appear most frequently across every crossword in the archive, regardless of setter.  These are the classic crossword chestnuts.",
+		Charts:   []*ChartSpec{spec},
+	}, nil
+}
+
+func (c *Chart7) Render(db *sql.DB, tmpls *Templates, opts Options) (string, *Table, error) {
+	sec, err := c.Series(db, opts)
+	if err != nil {
+		return "", nil, err
 	}
+	spec := sec.Charts[0]
 
 	data := map[string]any{
-		"Title":        "Most-used answers across all crosswords (top 50)",
-		"Preamble":     "The top 50 solutions that appear most frequently across every crossword in the archive, regardless of setter.  These are the classic crossword chestnuts.",
+		"Title":        sec.Title,
+		"Preamble":     sec.Preamble,
 		"Order":        7,
 		"DivID":        "mychart7",
 		"JSVar":        "chart7",
 		"DefaultChart": "bar",
-		"ChartJSON":    toJSON(chartDef),
+		"ChartJSON":    toJSON(c3Def(spec, "mychart7")),
 		"BarColorJS":   barColorJS("chart7"),
 	}
-	return executeTemplate(tmplDir, "chart.tmpl", data)
+	return renderSeries(tmpls, sec, "chart.tmpl", data)
 }
@@ -12,7 +12,7 @@ type Chart8 struct{}
 
 func (c *Chart8) Order() string { return "8" }
 
-func (c *Chart8) Render(db *sql.DB, tmplDir string) (string, error) {
+func (c *Chart8) Series(db *sql.DB, opts Options) (*Section, error) {
 	rows, err := db.Query(`
 		SELECT c.creator_name AS name,
 		       COUNT(DISTINCT e.solution)                        AS unique_solutions,
@@ -26,10 +26,10 @@ func (c *Chart8) Render(db *sql.DB, tmplDir string) (string, error) {
 		WHERE e.solution IS NOT NULL AND e.solution != ''
 		GROUP BY c.creator_name
 		HAVING COUNT(e.solution) > 0
-		ORDER BY ratio DESC
+		ORDER BY ratio DESC, name
 	`)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	defer rows.Close()
 
@@ -43,53 +43,57 @@ func (c *Chart8) Render(db *sql.DB, tmplDir string) (string, error) {
 		var unique, total int
 		var ratio float64
 		if err := rows.Scan(&name, &unique, &total, &ratio); err != nil {
-			return "", err
+			return nil, err
 		}
 		results = append(results, row{name, ratio})
 	}
 
 	sort.Slice(results, func(i, j int) bool {
-		return results[i].ratio > results[j].ratio
+		if results[i].ratio != results[j].ratio {
+			return results[i].ratio > results[j].ratio
+		}
+		return results[i].name < results[j].name
 	})
 
-	labels := make([]string, len(results))
-	values := []any{"Unique %"}
-	for i, r := range results {
-		labels[i] = r.name
-		values = append(values, r.ratio)
+	spec := &ChartSpec{
+		ID:              "chart8",
+		Title:           "Unique-answer ratio per setter",
+		Kind:            KindBar,
+		Series:          []Series{{Name: "Unique %"}},
+		XAxis:           Axis{TickRotate: 75},
+		YAxis:           Axis{Label: "Unique answers (%)", Min: limit(0), Max: limit(100)},
+		Height:          600,
+		ColorByCategory: true,
+	}
+	for _, r := range results {
+		spec.Categories = append(spec.Categories, r.name)
+		spec.Series[0].Values = append(spec.Series[0].Values, r.ratio)
 	}
 
-	chartDef := map[string]any{
-		"bindto": "#mychart8",
-		"size":   map[string]any{"height": 600},
-		"data": map[string]any{
-			"columns": []any{values},
-			"type":    "bar",
-		},
-		"axis": map[string]any{
-			"x": map[string]any{
-				"type":       "category",
-				"tick":       map[string]any{"rotate": "75", "multiline": false},
-				"height":     0,
-				"categories": labels,
-			},
-			"y": map[string]any{
-				"label": "Unique answers (%)",
-				"max":   100,
-				"min":   0,
-			},
-		},
+	return &Section{
+		Order:    c.Order(),
+		Title:    spec.Title,
+		Preamble: "The percentage of a setter's answers that are unique — i.e. used only once in their entire back-catalogue. A high percentage means a wider vocabulary; a low percentage means many repeated answers.",
+		Charts:   []*ChartSpec{spec},
+	}, nil
+}
+
+func (c *Chart8) Render(db *sql.DB, tmpls *Templates, opts Options) (string, *Table, error) {
+	sec, err := c.Series(db, opts)
+	if err != nil {
+		return "", nil, err
 	}
+	spec := sec.Charts[0]
 
 	data := map[string]any{
-		"Title":        "Unique-answer ratio per setter",
-		"Preamble":     "The percentage of a setter's answers that are unique — i.e. used only once in their entire back-catalogue. A high percentage means a wider vocabulary; a low percentage means many repeated answers.",
+		"Title":        sec.Title,
+		"Preamble":     sec.Preamble,
 		"Order":        8,
 		"DivID":        "mychart8",
 		"JSVar":        "chart8",
 		"DefaultChart": "bar",
-		"ChartJSON":    toJSON(chartDef),
+		"ChartJSON":    toJSON(c3Def(spec, "mychart8")),
 		"BarColorJS":   barColorJS("chart8"),
 	}
-	return executeTemplate(tmplDir, "chart.tmpl", data)
+	return renderSeries(tmpls, sec, "chart.tmpl", data)
 }
@@ -13,7 +13,7 @@ type Chart9 struct{}
 
 func (c *Chart9) Order() string { return "9" }
 
-func (c *Chart9) Render(db *sql.DB, tmplDir string) (string, error) {
+func (c *Chart9) Series(db *sql.DB, opts Options) (*Section, error) {
 	// Strip the trailing length hint "(N)" / "(N,M)" / "(N-M)" before measuring.
 	// regexp_replace removes the last parenthesised group and any leading/trailing
 	// whitespace so we measure only the clue text proper.
@@ -27,10 +27,10 @@ func (c *Chart9) Render(db *sql.DB, tmplDir string) (string, error) {
 		JOIN crosswords c ON e.crossword_id = c.id
 		WHERE e.clue IS NOT NULL AND e.clue != ''
 		GROUP BY c.creator_name
-		ORDER BY avg_len DESC
+		ORDER BY avg_len DESC, name
 	`)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	defer rows.Close()
 
@@ -43,51 +43,57 @@ func (c *Chart9) Render(db *sql.DB, tmplDir string) (string, error) {
 		var name string
 		var avgLen float64
 		if err := rows.Scan(&name, &avgLen); err != nil {
-			return "", err
+			return nil, err
 		}
 		results = append(results, row{name, avgLen})
 	}
 
 	sort.Slice(results, func(i, j int) bool {
-		return results[i].avgLen > results[j].avgLen
+		if results[i].avgLen != results[j].avgLen {
+			return results[i].avgLen > results[j].avgLen
+		}
+		return results[i].name < results[j].name
 	})
 
-	labels := make([]string, len(results))
-	values := []any{"Avg clue length (chars)"}
-	for i, r := range results {
-		labels[i] = r.name
-		values = append(values, r.avgLen)
+	spec := &ChartSpec{
+		ID:              "chart9",
+		Title:           "Average clue length per setter",
+		Kind:            KindBar,
+		Series:          []Series{{Name: "Avg clue length (chars)"}},
+		XAxis:           Axis{TickRotate: 75},
+		YAxis:           Axis{Label: "Average clue length (characters)"},
+		Height:          600,
+		ColorByCategory: true,
+	}
+	for _, r := range results {
+		spec.Categories = append(spec.Categories, r.name)
+		spec.Series[0].Values = append(spec.Series[0].Values, r.avgLen)
 	}
 
-	chartDef := map[string]any{
-		"bindto": "#mychart9",
-		"size":   map[string]any{"height": 600},
-		"data": map[string]any{
-			"columns": []any{values},
-			"type":    "bar",
-		},
-		"axis": map[string]any{
-			"x": map[string]any{
-				"type":       "category",
-				"tick":       map[string]any{"rotate": "75", "multiline": false},
-				"height":     0,
-				"categories": labels,
-			},
-			"y": map[string]any{
-				"label": "Average clue length (characters)",
-			},
-		},
+	return &Section{
+		Order:    c.Order(),
+		Title:    spec.Title,
+		Preamble: "Mean character-count of clue text per setter (the trailing length hint such as \"(6)\" is excluded). Longer clues tend to indicate more elaborate cryptic constructions or surface readings.",
+		Charts:   []*ChartSpec{spec},
+	}, nil
+}
+
+func (c *Chart9) Render(db *sql.DB, tmpls *Templates, opts Options) (string, *Table, error) {
+	sec, err := c.Series(db, opts)
+	if err != nil {
+		return "", nil, err
 	}
+	spec := sec.Charts[0]
 
 	data := map[string]any{
-		"Title":        "Average clue length per setter",
-		"Preamble":     "Mean character-count of clue text per setter (the trailing length hint such as \"(6)\" is excluded). Longer clues tend to indicate more elaborate cryptic constructions or surface readings.",
+		"Title":        sec.Title,
+		"Preamble":     sec.Preamble,
 		"Order":        9,
 		"DivID":        "mychart9",
 		"JSVar":        "chart9",
 		"DefaultChart": "bar",
-		"ChartJSON":    toJSON(chartDef),
+		"ChartJSON":    toJSON(c3Def(spec, "mychart9")),
 		"BarColorJS":   barColorJS("chart9"),
 	}
-	return executeTemplate(tmplDir, "chart.tmpl", data)
+	return renderSeries(tmpls, sec, "chart.tmpl", data)
 }
@@ -0,0 +1,255 @@
+package charts
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/ThomasAdam/guardian-cc/api"
+)
+
+// TableDataset is the data behind a DataTables section: a query and how
+// each of its columns is shown.  Both the static ajax files and serve's
+// /api/dt are driven from it, so a new table plugin is available
+// server-side without further work.
+type TableDataset struct {
+	// Name identifies the table, as in /api/dt?table=chart5.
+	Name string
+	// Query is a SELECT (possibly starting with WITH) that returns one
+	// row per table row.  Its output columns are what TableColumn.Field
+	// and the renderers refer to.  It has no ORDER BY; see OrderBy.
+	Query string
+	// OrderBy is the default row order, as an ORDER BY list over the
+	// query's output columns.  It also breaks ties when paging.
+	OrderBy string
+	Columns []TableColumn
+	// ajaxFile is where the rows are written for a static page.
+	ajaxFile string
+}
+
+// TableColumn is one column of a TableDataset.
+type TableColumn struct {
+	Title string
+	// Field is the query output column the table's column is searched
+	// and sorted by.
+	Field string
+	// Searchable reports whether the column takes part in searches.
+	Searchable bool
+	// Render returns the cell's HTML.  If nil, the cell is Field's value
+	// as text.
+	Render func(row TableRow) string
+	// Export gives the column's plain values for CSV, TSV and JSON Lines
+	// exports, as one or more fields; a link becomes its text and its
+	// URL, say.  If nil, the column exports Field's value as Title.
+	Export []ExportField
+}
+
+// ExportField is one field of an exported row.  Value returns a string,
+// a number or a []string.
+type ExportField struct {
+	Name  string
+	Value func(row TableRow) any
+}
+
+// TableRow is one row of a dataset's query, keyed by output column name.
+type TableRow map[string]any
+
+// TablePlugin is implemented by the DataTables sections (5, 5a and 6).
+type TablePlugin interface {
+	ChartPlugin
+	// Table returns the dataset behind the section's table.
+	Table() *TableDataset
+}
+
+// FindTable returns the dataset with the given name, or nil.
+func FindTable(name string) *TableDataset {
+	for _, p := range AllPlugins() {
+		if tp, ok := p.(TablePlugin); ok && tp.Table().Name == name {
+			return tp.Table()
+		}
+	}
+	return nil
+}
+
+// Titles returns the column titles.
+func (ds *TableDataset) Titles() []string {
+	titles := make([]string, len(ds.Columns))
+	for i, col := range ds.Columns {
+		titles[i] = col.Title
+	}
+	return titles
+}
+
+// ExportNames returns the names of the fields ExportValues returns.
+func (ds *TableDataset) ExportNames() []string {
+	var names []string
+	for _, col := range ds.Columns {
+		if col.Export == nil {
+			names = append(names, col.Title)
+			continue
+		}
+		for _, f := range col.Export {
+			names = append(names, f.Name)
+		}
+	}
+	return names
+}
+
+// ExportValues returns row's plain values, without any HTML, one per
+// field named by ExportNames.
+func (ds *TableDataset) ExportValues(row TableRow) []any {
+	var values []any
+	for _, col := range ds.Columns {
+		if col.Export == nil {
+			values = append(values, plainValue(row[col.Field]))
+			continue
+		}
+		for _, f := range col.Export {
+			values = append(values, f.Value(row))
+		}
+	}
+	return values
+}
+
+// Cells renders row as the table shows it, one string per column.
+func (ds *TableDataset) Cells(row TableRow) []string {
+	cells := make([]string, len(ds.Columns))
+	for i, col := range ds.Columns {
+		if col.Render != nil {
+			cells[i] = col.Render(row)
+		} else {
+			cells[i] = fieldText(row[col.Field])
+		}
+	}
+	return cells
+}
+
+// EachRow runs the query in its default order and passes each row to fn
+// in turn.
+func (ds *TableDataset) EachRow(ctx context.Context, db *sql.DB, fn func(row TableRow) error) error {
+	rows, err := db.QueryContext(ctx, "SELECT * FROM ("+ds.Query+") _t ORDER BY "+ds.OrderBy)
+	if err != nil {
+		return fmt.Errorf("querying %s: %w", ds.Name, err)
+	}
+	defer rows.Close()
+	return ScanTableRows(rows, fn)
+}
+
+// exportTable returns an empty table for the rows of ds, with the fields
+// of ExportValues as its columns.
+func (ds *TableDataset) exportTable() *Table {
+	return &Table{Columns: ds.ExportNames()}
+}
+
+// ScanTableRows reads every row of rows, which must select whole rows of
+// a dataset's query, and passes each to fn.
+func ScanTableRows(rows *sql.Rows, fn func(row TableRow) error) error {
+	names, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+	values := make([]any, len(names))
+	ptrs := make([]any, len(names))
+	for i := range values {
+		ptrs[i] = &values[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(ptrs...); err != nil {
+			return err
+		}
+		row := make(TableRow, len(names))
+		for i, name := range names {
+			row[name] = values[i]
+		}
+		if err := fn(row); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// renderTable renders a TablePlugin's section with its template, and
+// returns it along with the rows it read, if any (see tableAjax).  data
+// supplies the Title, Preamble and Order; the columns and where the rows
+// come from are filled in here.
+func renderTable(db *sql.DB, tmpls *Templates, p TablePlugin, tmplFile string, data map[string]any) (string, *Table, error) {
+	ajax, t, err := tableAjax(db, tmpls, p)
+	if err != nil {
+		return "", nil, err
+	}
+
+	ds := p.Table()
+	columns := make([]map[string]string, len(ds.Columns))
+	for i, col := range ds.Columns {
+		columns[i] = map[string]string{"title": col.Title}
+	}
+	data["Columns"] = toJSON(columns)
+	data["Ajax"] = ajax
+	data["ServerSide"] = tmpls.serverSide
+	html, err := executeTemplate(tmpls, tmplFile, data)
+	if err != nil {
+		return "", nil, err
+	}
+	return html, t, nil
+}
+
+// fieldText formats a query value for display.  Lists (from LIST()) are
+// shown one element per line.
+func fieldText(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case []any:
+		return strings.Join(toStringSlice(v), "<br />")
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
+
+// plainValue converts a query value for export: lists become []string
+// and anything else but numbers becomes a string.
+func plainValue(v any) any {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case []any:
+		return toStringSlice(v)
+	case string, int32, int64, float64:
+		return v
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
+
+// crosswordExport exports a crosswordLinks column as the crossword
+// numbers and their URLs.
+var crosswordExport = []ExportField{
+	{Name: "Crossword", Value: func(row TableRow) any {
+		return toStringSlice(row["numbers"])
+	}},
+	{Name: "URL", Value: func(row TableRow) any {
+		paths := toStringSlice(row["paths"])
+		urls := make([]string, len(paths))
+		for i, p := range paths {
+			urls[i] = api.CrosswordURL(p)
+		}
+		return urls
+	}},
+}
+
+// crosswordLinks renders the "paths" and "numbers" lists of a row as
+// links to each crossword on the Guardian site, one per line.
+func crosswordLinks(row TableRow) string {
+	paths := toStringSlice(row["paths"])
+	numbers := toStringSlice(row["numbers"])
+	links := make([]string, 0, len(paths))
+	for i, p := range paths {
+		if i < len(numbers) {
+			links = append(links, fmt.Sprintf(`<a href="%s">%s</a>`, api.CrosswordURL(p), numbers[i]))
+		}
+	}
+	return strings.Join(links, "<br />")
+}
@@ -1,25 +1,51 @@
 package charts
 
 import (
-	"bytes"
+	"context"
 	"database/sql"
 	"encoding/json"
 	"fmt"
 	htmltemplate "html/template"
+	"io"
 	"os"
+	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
-	texttemplate "text/template"
 	"time"
+
+	gccdb "github.com/ThomasAdam/guardian-cc/internal/db"
 )
 
 // ChartPlugin defines the interface each chart must implement.
 type ChartPlugin interface {
 	// Order returns the sort key for this chart section (e.g. "1", "2", "5a").
 	Order() string
-	// Render queries the DB and returns the rendered HTML fragment.
-	Render(db *sql.DB, tmplDir string) (string, error)
+	// Render queries the DB and returns the rendered HTML fragment and,
+	// from the same queries, the data behind it as a table.  The table
+	// is nil if rendering did not read the data, as for the tables of a
+	// page that pages through /api/dt.
+	Render(db *sql.DB, tmpls *Templates, opts Options) (string, *Table, error)
+}
+
+// Options are the settings of a render.
+type Options struct {
+	// AsOf, if set, is the time the output is rendered as of, in place
+	// of the current time.  It fixes the "Last Updated" line and the last
+	// year shown by the per-year charts, so that rendering the same DB
+	// twice gives byte-identical output.
+	AsOf time.Time
+	// Force makes RenderAll re-render every section, ignoring the
+	// fragment cache.
+	Force bool
+}
+
+// now returns the time the output is rendered as of.
+func (o Options) now() time.Time {
+	if !o.AsOf.IsZero() {
+		return o.AsOf
+	}
+	return time.Now()
 }
 
 // AllPlugins returns chart plugins in display order.
@@ -44,19 +70,87 @@ func AllPlugins() []ChartPlugin {
 }
 
 // RenderAll runs all chart plugins and produces the final HTML page.
-func RenderAll(db *sql.DB, tmplDir, outputFile string) error {
+// The stylesheet and each section's CSV/JSON data are written next to
+// outputFile.  Sections whose inputs are unchanged since the last render
+// are taken from the fragment cache unless opts.Force is set.  A line is
+// written to progress as each section is started.
+func RenderAll(db *sql.DB, tmpls *Templates, outputFile string, opts Options, progress io.Writer) error {
+	outDir := filepath.Dir(outputFile)
+	generation, err := gccdb.Generation(db)
+	if err != nil {
+		return err
+	}
+	cache, err := newFragmentCache(outDir, generation, tmpls, opts.now().Year())
+	if err != nil {
+		return err
+	}
+
 	plugins := AllPlugins()
 	sections := make(map[string]htmltemplate.HTML)
 
 	for _, p := range plugins {
-		fmt.Fprintf(os.Stderr, "Looking at: chart%s...\n", p.Order())
-		html, err := p.Render(db, tmplDir)
+		files := pluginFiles(p, tmpls, outDir)
+		if !opts.Force {
+			if html, ok := cache.load(p, files); ok {
+				fmt.Fprintf(progress, "Unchanged: chart%s\n", p.Order())
+				sections[p.Order()] = htmltemplate.HTML(html)
+				continue
+			}
+		}
+
+		fmt.Fprintf(progress, "Looking at: chart%s...\n", p.Order())
+		html, t, err := p.Render(db, tmpls, opts)
 		if err != nil {
 			return fmt.Errorf("rendering chart%s: %w", p.Order(), err)
 		}
 		sections[p.Order()] = htmltemplate.HTML(html)
+
+		if t == nil {
+			if t, err = PluginData(context.Background(), p, db, opts); err != nil {
+				return fmt.Errorf("reading chart%s data: %w", p.Order(), err)
+			}
+		}
+		if err := writeDataFiles(outDir, p.Order(), t); err != nil {
+			return err
+		}
+		if err := cache.store(p, html, files); err != nil {
+			return err
+		}
 	}
 
+	page, err := assemblePage(tmpls, sections, opts)
+	if err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(outputFile, page, 0644); err != nil {
+		return fmt.Errorf("writing output: %w", err)
+	}
+	cssOut := filepath.Join(filepath.Dir(outputFile), cssFile)
+	if err := os.WriteFile(cssOut, tmpls.CSS(), 0644); err != nil {
+		return fmt.Errorf("writing stylesheet: %w", err)
+	}
+	return nil
+}
+
+// RenderPage renders the interactive page in memory, without the fragment
+// cache or data files.  It is used by serve, with templates from
+// Templates.ForServer so that the tables page through /api/dt.
+func RenderPage(db *sql.DB, tmpls *Templates, opts Options) ([]byte, error) {
+	sections := make(map[string]htmltemplate.HTML)
+	for _, p := range AllPlugins() {
+		html, _, err := p.Render(db, tmpls, opts)
+		if err != nil {
+			return nil, fmt.Errorf("rendering chart%s: %w", p.Order(), err)
+		}
+		sections[p.Order()] = htmltemplate.HTML(html)
+	}
+	return assemblePage(tmpls, sections, opts)
+}
+
+// assemblePage puts the rendered sections, keyed by plugin order, into
+// the main page.
+func assemblePage(tmpls *Templates, sections map[string]htmltemplate.HTML, opts Options) ([]byte, error) {
 	// Sort section keys numerically so "2" < "5a" < "10" < "11".
 	// strconv.Atoi("5a") returns 0, so we must parse only the leading digit run.
 	leadingInt := func(s string) int {
@@ -87,44 +181,120 @@ func RenderAll(db *sql.DB, tmplDir, outputFile string) error {
 		Sections  []htmltemplate.HTML
 		Timestamp string
 	}{
-		Timestamp: time.Now().Format("Mon Jan 2 15:04:05 2006"),
+		Timestamp: opts.now().Format(timestampLayout),
 	}
 	for _, k := range keys {
 		mainData.Sections = append(mainData.Sections, sections[k])
 	}
 
-	mainTmpl, err := htmltemplate.ParseFiles(tmplDir + "/main.tmpl")
+	return executePage(tmpls, mainTemplate, mainData)
+}
+
+// RenderStatic writes every chart that implements SeriesPlugin to outDir
+// as an SVG file, along with a page (and stylesheet) that shows them
+// without needing any JavaScript.  The DataTables sections are left out.
+// Progress is reported as RenderAll reports it.
+func RenderStatic(db *sql.DB, tmpls *Templates, outDir string, opts Options, progress io.Writer) error {
+	sections, err := renderSpecs(db, opts, outDir, ".svg", progress, func(spec *ChartSpec) ([]byte, error) {
+		return renderSVG(spec), nil
+	})
 	if err != nil {
-		return fmt.Errorf("parsing main template: %w", err)
+		return err
 	}
 
-	var buf bytes.Buffer
-	if err := mainTmpl.Execute(&buf, mainData); err != nil {
-		return fmt.Errorf("executing main template: %w", err)
+	pageData := struct {
+		Sections  []*Section
+		Timestamp string
+	}{
+		Sections:  sections,
+		Timestamp: opts.now().Format(timestampLayout),
+	}
+	page, err := executePage(tmpls, staticTemplate, pageData)
+	if err != nil {
+		return err
 	}
 
-	if err := os.WriteFile(outputFile, buf.Bytes(), 0644); err != nil {
+	if err := os.WriteFile(filepath.Join(outDir, "gcc-analysis.html"), page, 0644); err != nil {
 		return fmt.Errorf("writing output: %w", err)
 	}
+	if err := os.WriteFile(filepath.Join(outDir, cssFile), tmpls.CSS(), 0644); err != nil {
+		return fmt.Errorf("writing stylesheet: %w", err)
+	}
 	return nil
 }
 
+// RenderVegaLite writes every chart that implements SeriesPlugin to
+// outDir as a Vega-Lite specification named <id>.vl.json.  Progress is
+// reported as RenderAll reports it.
+func RenderVegaLite(db *sql.DB, outDir string, opts Options, progress io.Writer) error {
+	_, err := renderSpecs(db, opts, outDir, ".vl.json", progress, func(spec *ChartSpec) ([]byte, error) {
+		return json.MarshalIndent(vegaLiteDef(spec), "", "  ")
+	})
+	return err
+}
+
+// renderSpecs runs every SeriesPlugin and writes each of its charts to
+// outDir as <id><ext>, using emit to produce the file contents, along
+// with the section's data as CSV and JSON.  It returns the sections in
+// display order.
+func renderSpecs(db *sql.DB, opts Options, outDir, ext string, progress io.Writer, emit func(*ChartSpec) ([]byte, error)) ([]*Section, error) {
+	if err := os.MkdirAll(outDir, 0755); err != nil {
+		return nil, fmt.Errorf("creating %s: %w", outDir, err)
+	}
+
+	var sections []*Section
+	for _, p := range AllPlugins() {
+		sp, ok := p.(SeriesPlugin)
+		if !ok {
+			continue
+		}
+		fmt.Fprintf(progress, "Looking at: chart%s...\n", p.Order())
+		sec, err := sp.Series(db, opts)
+		if err != nil {
+			return nil, fmt.Errorf("rendering chart%s: %w", p.Order(), err)
+		}
+		for _, spec := range sec.Charts {
+			out, err := emit(spec)
+			if err != nil {
+				return nil, fmt.Errorf("rendering %s: %w", spec.ID, err)
+			}
+			file := filepath.Join(outDir, spec.ID+ext)
+			if err := os.WriteFile(file, out, 0644); err != nil {
+				return nil, fmt.Errorf("writing %s: %w", file, err)
+			}
+		}
+		if err := writeDataFiles(outDir, p.Order(), sectionTable(sec)); err != nil {
+			return nil, err
+		}
+		sections = append(sections, sec)
+	}
+	return sections, nil
+}
+
 // --- Helpers ---
 
+// timestampLayout is the format of the "Last Updated" line on each page.
+const timestampLayout = "Mon Jan 2 15:04:05 2006"
+
 // barColorPalette is a 12-colour palette for single-series bar charts.
 // Because c3's color.pattern applies per *series* (not per bar), we use
 // data.color — a JS callback — to cycle colours across individual bars.
-const barColorPalette = `["#4e79a7","#f28e2b","#e15759","#76b7b2","#59a14f","#edc948","#b07aa1","#ff9da7","#9c755f","#bab0ac","#d37295","#499894"]`
+// The static SVG renderer uses the same palette for ColorByCategory.
+var barColorPalette = []string{
+	"#4e79a7", "#f28e2b", "#e15759", "#76b7b2", "#59a14f", "#edc948",
+	"#b07aa1", "#ff9da7", "#9c755f", "#bab0ac", "#d37295", "#499894",
+}
 
 // barColorJS returns a JS snippet that patches a c3 chart instance (named
 // jsVar) so each bar cycles through the palette by x-index.
 // It must be injected after c3.generate() is called.
 func barColorJS(jsVar string) string {
+	pal, _ := json.Marshal(barColorPalette)
 	return fmt.Sprintf(`(function(){
 				var _pal = %s;
 				%s.internal.color = function(d) { return _pal[d.index %% _pal.length]; };
 				%s.flush();
-			})();`, barColorPalette, jsVar, jsVar)
+			})();`, pal, jsVar, jsVar)
 }
 
 // toJSON converts a value to pretty-printed JSON for embedding in templates.
@@ -136,25 +306,6 @@ func toJSON(v any) string {
 	return string(b)
 }
 
-// writeAjaxFile writes a DataTables-compatible AJAX JSON file.
-// It uses SetEscapeHTML(false) so that HTML tags (e.g. <a href=...>)
-// embedded in the data are written literally, not escaped to \u003c etc.
-func writeAjaxFile(filename string, data [][]string) error {
-	wrapper := struct {
-		Data [][]string `json:"data"`
-	}{
-		Data: data,
-	}
-	var buf bytes.Buffer
-	enc := json.NewEncoder(&buf)
-	enc.SetEscapeHTML(false)
-	enc.SetIndent("", "   ")
-	if err := enc.Encode(wrapper); err != nil {
-		return err
-	}
-	return os.WriteFile(filename, buf.Bytes(), 0644)
-}
-
 // toStringSlice converts a []any (as returned by DuckDB's LIST() aggregate
 // via the go-duckdb driver) into a []string.
 func toStringSlice(v any) []string {
@@ -171,23 +322,3 @@ func toStringSlice(v any) []string {
 		return nil
 	}
 }
-
-// executeTemplate parses and executes a text/template file with data.
-// We use text/template (not html/template) because chart sub-templates
-// produce HTML fragments containing raw JavaScript and JSON that must
-// not be escaped. The fragments are injected into the main page via
-// html/template's template.HTML type, which prevents double-escaping.
-func executeTemplate(tmplDir, tmplFile string, data any) (string, error) {
-	funcMap := texttemplate.FuncMap{
-		"toJSON": toJSON,
-	}
-	t, err := texttemplate.New(tmplFile).Funcs(funcMap).ParseFiles(tmplDir + "/" + tmplFile)
-	if err != nil {
-		return "", fmt.Errorf("parsing template %s: %w", tmplFile, err)
-	}
-	var buf bytes.Buffer
-	if err := t.Execute(&buf, data); err != nil {
-		return "", fmt.Errorf("executing template %s: %w", tmplFile, err)
-	}
-	return buf.String(), nil
-}
@@ -0,0 +1,96 @@
+package charts
+
+import "database/sql"
+
+// ChartKind is the default drawing style of a chart.
+type ChartKind string
+
+const (
+	KindBar  ChartKind = "bar"
+	KindArea ChartKind = "area"
+	KindLine ChartKind = "line"
+)
+
+// Series is one named row of values, one per category.
+type Series struct {
+	Name   string
+	Values []float64
+}
+
+// Axis describes how an axis is labelled and scaled.  Zero values mean
+// "let the backend decide".
+type Axis struct {
+	Label string
+	// Min and Max fix the ends of a value axis.
+	Min, Max *float64
+	// TickRotate is the angle category labels are rotated by, in degrees.
+	TickRotate int
+	// TickSteps is the preferred spacing between value ticks.
+	TickSteps int
+	// Height reserves space (in pixels) for the category labels.
+	Height int
+}
+
+// ChartSpec is a backend-neutral description of one chart: its series
+// data and how it should be drawn.  Every chart plugin produces these,
+// and the c3, Vega-Lite and SVG emitters are all built from them.
+type ChartSpec struct {
+	// ID names the chart, e.g. "chart1" or "chart4-12".  It is used for
+	// output file names and, in the c3 page, the target element.
+	ID         string
+	Title      string
+	Kind       ChartKind
+	Stacked    bool
+	Categories []string
+	Series     []Series
+	XAxis      Axis
+	YAxis      Axis
+	// Width and Height are the plot size in pixels; a zero Width fills
+	// the available space.
+	Width  int
+	Height int
+	// Colors optionally fixes the colour of a series by name.
+	Colors map[string]string
+	// ColorByCategory cycles the palette across the bars of a
+	// single-series bar chart rather than colouring by series.
+	ColorByCategory bool
+	// Tooltips optionally holds extra hover text, one per category.
+	Tooltips []string
+	// HideLegend and HideTooltip switch off those chart elements.
+	HideLegend  bool
+	HideTooltip bool
+	// EmptyLabel is shown in place of the chart when it has no data.
+	EmptyLabel string
+}
+
+// Section is everything needed to draw a plugin's section without
+// JavaScript: its heading, preamble and one or more charts.
+type Section struct {
+	Order    string
+	Title    string
+	Preamble string
+	Charts   []*ChartSpec
+}
+
+// SeriesPlugin is implemented by chart plugins whose data is a set of
+// series, as opposed to the DataTables sections (5, 5a and 6).
+type SeriesPlugin interface {
+	ChartPlugin
+	// Series queries the DB and returns the section's chart specs.
+	Series(db *sql.DB, opts Options) (*Section, error)
+}
+
+// limit returns a pointer to v, for Axis.Min and Axis.Max.
+func limit(v float64) *float64 {
+	return &v
+}
+
+// renderSeries renders a SeriesPlugin's section with its template, and
+// returns it along with sec's data.
+func renderSeries(tmpls *Templates, sec *Section, tmplFile string, data any) (string, *Table, error) {
+	html, err := executeTemplate(tmpls, tmplFile, data)
+	if err != nil {
+		return "", nil, err
+	}
+	return html, sectionTable(sec), nil
+}
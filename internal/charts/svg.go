@@ -0,0 +1,290 @@
+package charts
+
+import (
+	"encoding/xml"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Layout constants for static SVG charts, in pixels.
+const (
+	svgWidth       = 1000
+	svgMarginTop   = 20
+	svgMarginRight = 20
+	svgMarginLeft  = 80
+	svgCharWidth   = 7
+	svgLegendRow   = 18
+	svgTickCount   = 5
+)
+
+// seriesPalette matches c3's default category10 colour scheme, so the SVG
+// output looks like the interactive page.
+var seriesPalette = []string{
+	"#1f77b4", "#ff7f0e", "#2ca02c", "#d62728", "#9467bd",
+	"#8c564b", "#e377c2", "#7f7f7f", "#bcbd22", "#17becf",
+}
+
+// renderSVG draws spec as a standalone SVG document.  Bar charts (stacked
+// or grouped), area charts and line charts are supported; every bar or
+// point carries a <title> so that hovering shows its value without any
+// JavaScript.
+func renderSVG(spec *ChartSpec) []byte {
+	plotHeight := spec.Height
+	if plotHeight <= 0 {
+		plotHeight = 400
+	}
+	width := svgWidth
+	if spec.Width > 0 {
+		width = spec.Width
+	}
+	plotWidth := width - svgMarginLeft - svgMarginRight
+	n := len(spec.Categories)
+	band := float64(plotWidth)
+	if n > 0 {
+		band = float64(plotWidth) / float64(n)
+	}
+
+	// Category labels are rotated when they would not fit side by side.
+	maxLabel := 0
+	for _, c := range spec.Categories {
+		maxLabel = max(maxLabel, len([]rune(c)))
+	}
+	rotate := float64(maxLabel*svgCharWidth) > band
+	labelHeight := 20
+	if rotate {
+		labelHeight = int(float64(maxLabel*svgCharWidth)*math.Sin(math.Pi/3)) + 20
+	}
+
+	legendRows := legendLayout(spec, plotWidth)
+	legendHeight := 0
+	if len(legendRows) > 0 {
+		legendHeight = len(legendRows)*svgLegendRow + 10
+	}
+
+	height := svgMarginTop + plotHeight + labelHeight + legendHeight
+	top, step := niceScale(seriesMax(spec))
+	if m := spec.YAxis.Max; m != nil && *m >= seriesMax(spec) {
+		top, step = niceScale(*m)
+	}
+	y := func(v float64) float64 {
+		return float64(svgMarginTop) + float64(plotHeight)*(1-v/top)
+	}
+	x := func(i int) float64 {
+		return float64(svgMarginLeft) + band*float64(i)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" font-family="sans-serif" font-size="11">`+"\n",
+		width, height, width, height)
+	fmt.Fprintf(&b, "<title>%s</title>\n", svgEscape(spec.Title))
+	fmt.Fprintf(&b, `<rect width="%d" height="%d" fill="#fff"/>`+"\n", width, height)
+
+	// Y axis: grid lines, tick labels and the axis label.
+	for k := 0; float64(k)*step <= top+step/2; k++ {
+		v := math.Round(float64(k)*step*1e6) / 1e6
+		fmt.Fprintf(&b, `<line x1="%d" x2="%d" y1="%.1f" y2="%.1f" stroke="#ddd"/>`+"\n",
+			svgMarginLeft, svgMarginLeft+plotWidth, y(v), y(v))
+		fmt.Fprintf(&b, `<text x="%d" y="%.1f" text-anchor="end" dominant-baseline="middle">%s</text>`+"\n",
+			svgMarginLeft-6, y(v), formatValue(v))
+	}
+	if spec.YAxis.Label != "" {
+		fmt.Fprintf(&b, `<text transform="translate(16,%d) rotate(-90)" text-anchor="middle">%s</text>`+"\n",
+			svgMarginTop+plotHeight/2, svgEscape(spec.YAxis.Label))
+	}
+
+	switch spec.Kind {
+	case KindArea, KindLine:
+		drawLines(&b, spec, x, y, band)
+	default:
+		drawBars(&b, spec, x, y, band)
+	}
+
+	// X axis and category labels.
+	base := svgMarginTop + plotHeight
+	fmt.Fprintf(&b, `<line x1="%d" x2="%d" y1="%d" y2="%d" stroke="#000"/>`+"\n",
+		svgMarginLeft, svgMarginLeft+plotWidth, base, base)
+	fmt.Fprintf(&b, `<line x1="%d" x2="%d" y1="%d" y2="%d" stroke="#000"/>`+"\n",
+		svgMarginLeft, svgMarginLeft, svgMarginTop, base)
+	for i, c := range spec.Categories {
+		cx := x(i) + band/2
+		if rotate {
+			fmt.Fprintf(&b, `<text transform="translate(%.1f,%d) rotate(-60)" text-anchor="end">%s</text>`+"\n",
+				cx, base+10, svgEscape(c))
+		} else {
+			fmt.Fprintf(&b, `<text x="%.1f" y="%d" text-anchor="middle">%s</text>`+"\n",
+				cx, base+16, svgEscape(c))
+		}
+	}
+
+	// Legend, one row per line of entries.
+	ly := base + labelHeight + 10
+	for _, row := range legendRows {
+		lx := svgMarginLeft
+		for _, item := range row {
+			fmt.Fprintf(&b, `<rect x="%d" y="%d" width="10" height="10" fill="%s"/>`+"\n",
+				lx, ly, seriesColor(spec, item.index))
+			fmt.Fprintf(&b, `<text x="%d" y="%d">%s</text>`+"\n",
+				lx+14, ly+9, svgEscape(spec.Series[item.index].Name))
+			lx += item.width
+		}
+		ly += svgLegendRow
+	}
+
+	b.WriteString("</svg>\n")
+	return []byte(b.String())
+}
+
+// drawBars draws stacked or side-by-side bars, one group per category.
+func drawBars(b *strings.Builder, spec *ChartSpec, x func(int) float64, y func(float64) float64, band float64) {
+	groups := len(spec.Series)
+	if spec.Stacked || groups == 0 {
+		groups = 1
+	}
+	pad := band * 0.1
+	barWidth := (band - 2*pad) / float64(groups)
+
+	for i, cat := range spec.Categories {
+		stack := 0.0
+		for si, s := range spec.Series {
+			if i >= len(s.Values) {
+				continue
+			}
+			v := s.Values[i]
+			bx := x(i) + pad
+			lo, hi := 0.0, v
+			if spec.Stacked {
+				lo, hi = stack, stack+v
+				stack = hi
+			} else {
+				bx += barWidth * float64(si)
+			}
+			fill := seriesColor(spec, si)
+			if spec.ColorByCategory && len(spec.Series) == 1 {
+				fill = barColorPalette[i%len(barColorPalette)]
+			}
+			fmt.Fprintf(b, `<rect x="%.1f" y="%.1f" width="%.1f" height="%.1f" fill="%s"><title>%s</title></rect>`+"\n",
+				bx, y(hi), barWidth, y(lo)-y(hi), fill, svgEscape(pointTitle(spec, i, cat, s.Name, v)))
+		}
+	}
+}
+
+// drawLines draws each series as a line through the category centres,
+// filling the area beneath it for area charts.
+func drawLines(b *strings.Builder, spec *ChartSpec, x func(int) float64, y func(float64) float64, band float64) {
+	for si, s := range spec.Series {
+		if len(s.Values) == 0 {
+			continue
+		}
+		colour := seriesColor(spec, si)
+		var pts []string
+		for i, v := range s.Values {
+			pts = append(pts, fmt.Sprintf("%.1f,%.1f", x(i)+band/2, y(v)))
+		}
+		if spec.Kind == KindArea {
+			first := x(0) + band/2
+			last := x(len(s.Values)-1) + band/2
+			fmt.Fprintf(b, `<polygon points="%.1f,%.1f %s %.1f,%.1f" fill="%s" fill-opacity="0.2" stroke="none"/>`+"\n",
+				first, y(0), strings.Join(pts, " "), last, y(0), colour)
+		}
+		fmt.Fprintf(b, `<polyline points="%s" fill="none" stroke="%s" stroke-width="1.5"/>`+"\n",
+			strings.Join(pts, " "), colour)
+		for i, v := range s.Values {
+			if i >= len(spec.Categories) {
+				break
+			}
+			fmt.Fprintf(b, `<circle cx="%.1f" cy="%.1f" r="2.5" fill="%s"><title>%s</title></circle>`+"\n",
+				x(i)+band/2, y(v), colour, svgEscape(pointTitle(spec, i, spec.Categories[i], s.Name, v)))
+		}
+	}
+}
+
+type legendItem struct {
+	index int
+	width int
+}
+
+// legendLayout wraps the legend entries into rows no wider than width.
+// Charts with a single series have no legend.
+func legendLayout(spec *ChartSpec, width int) [][]legendItem {
+	if len(spec.Series) < 2 || spec.HideLegend {
+		return nil
+	}
+	var rows [][]legendItem
+	var row []legendItem
+	used := 0
+	for i, s := range spec.Series {
+		w := len([]rune(s.Name))*svgCharWidth + 28
+		if used+w > width && len(row) > 0 {
+			rows = append(rows, row)
+			row, used = nil, 0
+		}
+		row = append(row, legendItem{index: i, width: w})
+		used += w
+	}
+	return append(rows, row)
+}
+
+// seriesMax returns the largest value drawn, summing stacked series.
+func seriesMax(spec *ChartSpec) float64 {
+	m := 0.0
+	for i := range spec.Categories {
+		sum := 0.0
+		for _, s := range spec.Series {
+			if i >= len(s.Values) {
+				continue
+			}
+			if spec.Stacked {
+				sum += s.Values[i]
+			} else {
+				m = max(m, s.Values[i])
+			}
+		}
+		m = max(m, sum)
+	}
+	return m
+}
+
+// niceScale rounds m up to a readable axis maximum and returns it along
+// with the tick step (1, 2 or 5 times a power of ten).
+func niceScale(m float64) (top, step float64) {
+	if m <= 0 {
+		return 1, 1.0 / svgTickCount
+	}
+	raw := m / svgTickCount
+	mag := math.Pow(10, math.Floor(math.Log10(raw)))
+	step = 10 * mag
+	for _, f := range []float64{1, 2, 5} {
+		if raw <= f*mag {
+			step = f * mag
+			break
+		}
+	}
+	return math.Ceil(m/step) * step, step
+}
+
+func seriesColor(spec *ChartSpec, i int) string {
+	if c, ok := spec.Colors[spec.Series[i].Name]; ok {
+		return c
+	}
+	return seriesPalette[i%len(seriesPalette)]
+}
+
+func pointTitle(spec *ChartSpec, i int, category, series string, v float64) string {
+	t := fmt.Sprintf("%s, %s: %s", category, series, formatValue(v))
+	if i < len(spec.Tooltips) && spec.Tooltips[i] != "" {
+		t += "\n" + spec.Tooltips[i]
+	}
+	return t
+}
+
+func formatValue(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+func svgEscape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
@@ -0,0 +1,175 @@
+package charts
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Table is the tabular data behind a chart section, as offered for
+// download.  Cells are strings, numbers or lists of strings.  For a
+// TablePlugin the cells are the plain values of its rows, without any
+// HTML (see TableDataset.ExportValues); a SeriesPlugin's charts are
+// flattened by sectionTable.
+type Table struct {
+	Columns []string `json:"columns"`
+	Rows    [][]any  `json:"rows"`
+}
+
+// ListSeparator joins a list value (e.g. a setter's clues for an answer)
+// into one CSV or TSV field.
+const ListSeparator = " | "
+
+// PluginData returns the data behind p's section as a table.
+func PluginData(ctx context.Context, p ChartPlugin, db *sql.DB, opts Options) (*Table, error) {
+	switch p := p.(type) {
+	case TablePlugin:
+		ds := p.Table()
+		t := ds.exportTable()
+		err := ds.EachRow(ctx, db, func(row TableRow) error {
+			t.Rows = append(t.Rows, ds.ExportValues(row))
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		return t, nil
+	case SeriesPlugin:
+		sec, err := p.Series(db, opts)
+		if err != nil {
+			return nil, err
+		}
+		return sectionTable(sec), nil
+	}
+	return nil, fmt.Errorf("chart%s has no tabular data", p.Order())
+}
+
+// FindPlugin returns the plugin with the given order key, or nil.
+func FindPlugin(order string) ChartPlugin {
+	for _, p := range AllPlugins() {
+		if p.Order() == order {
+			return p
+		}
+	}
+	return nil
+}
+
+// sectionTable flattens a section's charts into a table.  A section with a
+// single chart gets one row per category and one column per series; a
+// section with several charts (e.g. one per setter) is written in long
+// form, one row per chart, category and series.
+func sectionTable(sec *Section) *Table {
+	if len(sec.Charts) == 1 {
+		spec := sec.Charts[0]
+		t := &Table{Columns: []string{categoryColumn(spec)}}
+		for _, s := range spec.Series {
+			t.Columns = append(t.Columns, s.Name)
+		}
+		for i, cat := range spec.Categories {
+			row := []any{cat}
+			for _, s := range spec.Series {
+				if i < len(s.Values) {
+					row = append(row, s.Values[i])
+				} else {
+					row = append(row, nil)
+				}
+			}
+			t.Rows = append(t.Rows, row)
+		}
+		return t
+	}
+
+	t := &Table{Columns: []string{"chart", "category", "series", "value"}}
+	for _, spec := range sec.Charts {
+		for _, s := range spec.Series {
+			for i, v := range s.Values {
+				if i < len(spec.Categories) {
+					t.Rows = append(t.Rows, []any{spec.Title, spec.Categories[i], s.Name, v})
+				}
+			}
+		}
+	}
+	return t
+}
+
+func categoryColumn(spec *ChartSpec) string {
+	if spec.XAxis.Label != "" {
+		return spec.XAxis.Label
+	}
+	return "category"
+}
+
+// WriteCSV writes t as CSV with a header row.
+func WriteCSV(w io.Writer, t *Table) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(t.Columns); err != nil {
+		return err
+	}
+	record := make([]string, len(t.Columns))
+	for _, row := range t.Rows {
+		for i := range record {
+			record[i] = ""
+			if i < len(row) {
+				record[i] = cellString(row[i])
+			}
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteJSON writes t as a JSON object with "columns" and "rows".
+func WriteJSON(w io.Writer, t *Table) error {
+	if t.Rows == nil {
+		t.Rows = [][]any{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	return enc.Encode(t)
+}
+
+func cellString(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case []string:
+		return strings.Join(v, ListSeparator)
+	case float64:
+		return formatValue(v)
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
+
+// writeDataFiles writes t as chart<order>.csv and chart<order>.json in dir.
+func writeDataFiles(dir, order string, t *Table) error {
+	for ext, write := range map[string]func(io.Writer, *Table) error{
+		".csv":  WriteCSV,
+		".json": WriteJSON,
+	} {
+		name := filepath.Join(dir, "chart"+order+ext)
+		f, err := os.Create(name)
+		if err != nil {
+			return err
+		}
+		if err := write(f, t); err != nil {
+			f.Close()
+			return fmt.Errorf("writing %s: %w", name, err)
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
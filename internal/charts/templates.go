@@ -0,0 +1,197 @@
+package charts
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	texttemplate "text/template"
+
+	"github.com/ThomasAdam/guardian-cc/ui"
+)
+
+// mainTemplate is the page template that the chart sections are injected into.
+const mainTemplate = "main.tmpl"
+
+// staticTemplate is the JavaScript-free page that embeds the SVG charts.
+const staticTemplate = "static.tmpl"
+
+// cssFile is the stylesheet shipped alongside the rendered page.
+const cssFile = "gcc.css"
+
+// Templates is the parsed set of page and chart templates.  It is built
+// once at startup by LoadTemplates and shared by every plugin's Render.
+type Templates struct {
+	pages  map[string]*htmltemplate.Template
+	charts map[string]*texttemplate.Template
+	css    []byte
+	// sum is a hash of the chart templates' source, so that cached
+	// fragments are not reused after a template changes.
+	sum string
+	// serverSide is set on the copy returned by ForServer.
+	serverSide bool
+}
+
+// LoadTemplates parses the templates embedded in the binary.  If
+// overrideDir is non-empty, any file present there (a *.tmpl or gcc.css)
+// replaces the embedded file of the same name; anything missing falls
+// back to the default.  All templates are parsed here, so a broken
+// override is reported before any chart is rendered.
+func LoadTemplates(overrideDir string) (*Templates, error) {
+	names, err := templateNames(overrideDir)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &Templates{
+		pages:  make(map[string]*htmltemplate.Template),
+		charts: make(map[string]*texttemplate.Template),
+	}
+	h := sha256.New()
+	funcMap := texttemplate.FuncMap{
+		"toJSON":  toJSON,
+		"dataURL": fileDataURL,
+	}
+	for _, name := range names {
+		src, err := readUIFile(overrideDir, name, path.Join("chart_defs", name))
+		if err != nil {
+			return nil, err
+		}
+		if name == mainTemplate || name == staticTemplate {
+			t.pages[name], err = htmltemplate.New(name).Parse(string(src))
+		} else {
+			t.charts[name], err = texttemplate.New(name).Funcs(funcMap).Parse(string(src))
+			fmt.Fprintf(h, "%s %d\n", name, len(src))
+			h.Write(src)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("parsing template %s: %w", name, err)
+		}
+	}
+	t.sum = hex.EncodeToString(h.Sum(nil))
+	for _, name := range []string{mainTemplate, staticTemplate} {
+		if t.pages[name] == nil {
+			return nil, fmt.Errorf("missing template %s", name)
+		}
+	}
+
+	t.css, err = readUIFile(overrideDir, cssFile, cssFile)
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// ForServer returns a copy of t for a page backed by guardian-cc serve:
+// the tables page through /api/dt instead of loading an ajax file, and
+// the download links fetch each section's data from /api/charts.
+func (t *Templates) ForServer() (*Templates, error) {
+	st := *t
+	st.serverSide = true
+	st.sum = t.sum + " server"
+	st.charts = make(map[string]*texttemplate.Template, len(t.charts))
+	for name, ct := range t.charts {
+		clone, err := ct.Clone()
+		if err != nil {
+			return nil, fmt.Errorf("cloning template %s: %w", name, err)
+		}
+		st.charts[name] = clone.Funcs(texttemplate.FuncMap{"dataURL": apiDataURL})
+	}
+	return &st, nil
+}
+
+// fileDataURL links to a section's data file, as written by RenderAll.
+func fileDataURL(order any, format string) string {
+	return fmt.Sprintf("chart%v.%s", order, format)
+}
+
+// apiDataURL links to a section's data as served by /api/charts.
+func apiDataURL(order any, format string) string {
+	return fmt.Sprintf("api/charts/%v/data?format=%s", order, format)
+}
+
+// CSS returns the stylesheet that accompanies the rendered page.
+func (t *Templates) CSS() []byte {
+	return t.css
+}
+
+// templateNames returns the sorted union of the embedded template names
+// and any *.tmpl files in overrideDir.
+func templateNames(overrideDir string) ([]string, error) {
+	embedded, err := fs.Glob(ui.FS, "chart_defs/*.tmpl")
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	for _, p := range embedded {
+		seen[path.Base(p)] = true
+	}
+	if overrideDir != "" {
+		local, err := filepath.Glob(filepath.Join(overrideDir, "*.tmpl"))
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range local {
+			seen[filepath.Base(p)] = true
+		}
+	}
+
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// readUIFile returns name from overrideDir if it exists there, otherwise
+// the embedded file at embeddedPath.
+func readUIFile(overrideDir, name, embeddedPath string) ([]byte, error) {
+	if overrideDir != "" {
+		b, err := os.ReadFile(filepath.Join(overrideDir, name))
+		if err == nil {
+			return b, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("reading %s: %w", name, err)
+		}
+	}
+	b, err := fs.ReadFile(ui.FS, embeddedPath)
+	if err != nil {
+		return nil, fmt.Errorf("reading embedded %s: %w", name, err)
+	}
+	return b, nil
+}
+
+// executeTemplate executes the named chart template with data.
+// Chart templates use text/template (not html/template) because they
+// produce HTML fragments containing raw JavaScript and JSON that must
+// not be escaped. The fragments are injected into the main page via
+// html/template's template.HTML type, which prevents double-escaping.
+func executeTemplate(tmpls *Templates, tmplFile string, data any) (string, error) {
+	t, ok := tmpls.charts[tmplFile]
+	if !ok {
+		return "", fmt.Errorf("template %s not found", tmplFile)
+	}
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("executing template %s: %w", tmplFile, err)
+	}
+	return buf.String(), nil
+}
+
+// executePage executes one of the html/template page templates with data.
+func executePage(tmpls *Templates, name string, data any) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := tmpls.pages[name].Execute(&buf, data); err != nil {
+		return nil, fmt.Errorf("executing template %s: %w", name, err)
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,161 @@
+package charts
+
+// Vega-Lite (v5) output.  Specs carry their data inline in long format,
+// one value per (category, series) pair, so they can be dropped straight
+// into Observable, Jupyter (via Altair) or any vega-embed page.
+
+const vegaLiteSchema = "https://vega.github.io/schema/vega-lite/v5.json"
+
+// vegaLiteWidth is used when a chart does not fix its own width.
+const vegaLiteWidth = 900
+
+type vlSpec struct {
+	Schema   string     `json:"$schema"`
+	Title    string     `json:"title,omitempty"`
+	Width    int        `json:"width"`
+	Height   int        `json:"height"`
+	Data     vlData     `json:"data"`
+	Mark     vlMark     `json:"mark"`
+	Encoding vlEncoding `json:"encoding"`
+}
+
+type vlData struct {
+	Values []vlDatum `json:"values"`
+}
+
+type vlDatum struct {
+	Category string  `json:"category"`
+	Series   string  `json:"series"`
+	Value    float64 `json:"value"`
+	Note     string  `json:"note,omitempty"`
+}
+
+type vlMark struct {
+	Type    ChartKind `json:"type"`
+	Tooltip bool      `json:"tooltip,omitempty"`
+	Opacity float64   `json:"opacity,omitempty"`
+}
+
+type vlEncoding struct {
+	X       vlChannel   `json:"x"`
+	Y       vlChannel   `json:"y"`
+	Color   *vlChannel  `json:"color,omitempty"`
+	XOffset *vlChannel  `json:"xOffset,omitempty"`
+	Tooltip []vlChannel `json:"tooltip,omitempty"`
+}
+
+type vlChannel struct {
+	Field  string    `json:"field"`
+	Type   string    `json:"type"`
+	Title  string    `json:"title,omitempty"`
+	Sort   []string  `json:"sort,omitempty"`
+	Stack  *bool     `json:"stack,omitempty"`
+	Scale  *vlScale  `json:"scale,omitempty"`
+	Axis   *vlAxis   `json:"axis,omitempty"`
+	Legend *vlLegend `json:"legend,omitempty"`
+}
+
+type vlScale struct {
+	Domain    []string `json:"domain,omitempty"`
+	Range     []string `json:"range,omitempty"`
+	DomainMin *float64 `json:"domainMin,omitempty"`
+	DomainMax *float64 `json:"domainMax,omitempty"`
+}
+
+type vlAxis struct {
+	LabelAngle int `json:"labelAngle"`
+}
+
+type vlLegend struct {
+	Disable bool `json:"disable"`
+}
+
+// vegaLiteDef converts spec into a Vega-Lite specification.
+func vegaLiteDef(spec *ChartSpec) vlSpec {
+	vl := vlSpec{
+		Schema: vegaLiteSchema,
+		Title:  spec.Title,
+		Width:  spec.Width,
+		Height: spec.Height,
+		Mark:   vlMark{Type: spec.Kind, Tooltip: !spec.HideTooltip},
+		Encoding: vlEncoding{
+			X: vlChannel{
+				Field: "category",
+				Type:  "nominal",
+				Title: spec.XAxis.Label,
+				Sort:  spec.Categories,
+				Axis:  &vlAxis{LabelAngle: -spec.XAxis.TickRotate},
+			},
+			Y: vlChannel{
+				Field: "value",
+				Type:  "quantitative",
+				Title: spec.YAxis.Label,
+				Stack: &spec.Stacked,
+			},
+		},
+	}
+	if vl.Width == 0 {
+		vl.Width = vegaLiteWidth
+	}
+	if spec.Kind == KindArea && !spec.Stacked {
+		vl.Mark.Opacity = 0.4
+	}
+	if spec.YAxis.Min != nil || spec.YAxis.Max != nil {
+		vl.Encoding.Y.Scale = &vlScale{DomainMin: spec.YAxis.Min, DomainMax: spec.YAxis.Max}
+	}
+
+	names := make([]string, len(spec.Series))
+	colours := make([]string, len(spec.Series))
+	for i, s := range spec.Series {
+		names[i] = s.Name
+		colours[i] = seriesColor(spec, i)
+	}
+	if spec.ColorByCategory && len(spec.Series) == 1 {
+		palette := make([]string, len(spec.Categories))
+		for i := range palette {
+			palette[i] = barColorPalette[i%len(barColorPalette)]
+		}
+		vl.Encoding.Color = &vlChannel{
+			Field:  "category",
+			Type:   "nominal",
+			Scale:  &vlScale{Domain: spec.Categories, Range: palette},
+			Legend: &vlLegend{Disable: true},
+		}
+	} else {
+		vl.Encoding.Color = &vlChannel{
+			Field:  "series",
+			Type:   "nominal",
+			Scale:  &vlScale{Domain: names, Range: colours},
+			Legend: &vlLegend{Disable: spec.HideLegend || len(spec.Series) < 2},
+		}
+		if spec.Kind == KindBar && !spec.Stacked && len(spec.Series) > 1 {
+			vl.Encoding.XOffset = &vlChannel{Field: "series", Type: "nominal", Sort: names}
+		}
+	}
+
+	if !spec.HideTooltip {
+		vl.Encoding.Tooltip = []vlChannel{
+			{Field: "category", Type: "nominal"},
+			{Field: "series", Type: "nominal"},
+			{Field: "value", Type: "quantitative"},
+		}
+		if len(spec.Tooltips) > 0 {
+			vl.Encoding.Tooltip = append(vl.Encoding.Tooltip, vlChannel{Field: "note", Type: "nominal"})
+		}
+	}
+
+	vl.Data.Values = make([]vlDatum, 0, len(spec.Categories)*len(spec.Series))
+	for _, s := range spec.Series {
+		for i, v := range s.Values {
+			if i >= len(spec.Categories) {
+				break
+			}
+			d := vlDatum{Category: spec.Categories[i], Series: s.Name, Value: v}
+			if i < len(spec.Tooltips) {
+				d.Note = spec.Tooltips[i]
+			}
+			vl.Data.Values = append(vl.Data.Values, d)
+		}
+	}
+	return vl
+}
@@ -3,6 +3,7 @@ package db
 import (
 	"database/sql"
 	"fmt"
+	"time"
 
 	_ "github.com/marcboeker/go-duckdb"
 )
@@ -48,6 +49,59 @@ func CreateSchema(db *sql.DB) error {
 			pos_y        INTEGER,
 			FOREIGN KEY (crossword_id) REFERENCES crosswords(id)
 		)`,
+		// Key/value bookkeeping: the generation counter, the time of the
+		// last import and the versions of the search indexes.
+		`CREATE TABLE IF NOT EXISTS meta (
+			key   VARCHAR PRIMARY KEY,
+			value BIGINT
+		)`,
+		// The clue search index (see package search), maintained by the
+		// importer: one row per indexed clue with its length in terms,
+		// and one per occurrence of a term in it.
+		`CREATE TABLE IF NOT EXISTS clue_docs (
+			crossword_id VARCHAR,
+			entry_id     VARCHAR,
+			length       INTEGER
+		)`,
+		`CREATE TABLE IF NOT EXISTS clue_terms (
+			term         VARCHAR,
+			crossword_id VARCHAR,
+			entry_id     VARCHAR,
+			pos          INTEGER
+		)`,
+		// The anagram index (see package search), maintained by the
+		// importer: one row per use of an answer, with answers spread
+		// over several lights put back together.  enumeration is NULL
+		// when the clue does not give one that fits; signature is the
+		// answer's letters in order.
+		`CREATE TABLE IF NOT EXISTS answers (
+			crossword_id VARCHAR,
+			entry_id     VARCHAR,
+			answer       VARCHAR,
+			enumeration  VARCHAR,
+			signature    VARCHAR
+		)`,
+		// The crosswords each search index has been built from, so that
+		// one giving it no rows is not read again on every import.
+		`CREATE TABLE IF NOT EXISTS indexed_crosswords (
+			index_name   VARCHAR,
+			crossword_id VARCHAR
+		)`,
+		// Background jobs run by serve (see package jobs), newest last.
+		// output holds the job's progress lines, less the first dropped
+		// of them if there were many.
+		`CREATE SEQUENCE IF NOT EXISTS job_ids`,
+		`CREATE TABLE IF NOT EXISTS jobs (
+			id       BIGINT PRIMARY KEY,
+			kind     VARCHAR,
+			state    VARCHAR,
+			created  TIMESTAMP,
+			started  TIMESTAMP,
+			finished TIMESTAMP,
+			error    VARCHAR,
+			output   VARCHAR,
+			dropped  INTEGER
+		)`,
 		// View that resolves "See N" / "See N across" / "See N (M)" style
 		// cross-reference clues by looking up the target entry in the same
 		// crossword.  When a bare "See N" matches both across and down, we
@@ -127,3 +181,81 @@ func CreateSchema(db *sql.DB) error {
 	}
 	return nil
 }
+
+// LatestDate returns the publication date of the most recent crossword in
+// the DB, or the zero time if there are none.
+func LatestDate(db *sql.DB) (time.Time, error) {
+	var latest sql.NullTime
+	if err := db.QueryRow(`SELECT MAX(date) FROM crosswords`).Scan(&latest); err != nil {
+		return time.Time{}, fmt.Errorf("finding latest crossword date: %w", err)
+	}
+	return latest.Time, nil
+}
+
+// Generation returns the DB's generation counter, which the importer bumps
+// whenever it adds crosswords.  A DB that has never been imported into
+// (since the counter was introduced) is at generation 0.
+func Generation(db *sql.DB) (int64, error) {
+	return getMeta(db, "generation")
+}
+
+// BumpGeneration increments the generation counter, marking anything
+// derived from the previous contents of the DB as stale.
+func BumpGeneration(db *sql.DB) error {
+	_, err := db.Exec(`INSERT INTO meta (key, value) VALUES ('generation', 1)
+		ON CONFLICT (key) DO UPDATE SET value = meta.value + 1`)
+	if err != nil {
+		return fmt.Errorf("bumping generation: %w", err)
+	}
+	return nil
+}
+
+// LastImport returns when an import last finished, or the zero time if
+// none has been recorded.
+func LastImport(db *sql.DB) (time.Time, error) {
+	secs, err := getMeta(db, "last_import")
+	if err != nil || secs == 0 {
+		return time.Time{}, err
+	}
+	return time.Unix(secs, 0), nil
+}
+
+// RecordImport records t as the time an import last finished.
+func RecordImport(db *sql.DB, t time.Time) error {
+	_, err := db.Exec(`INSERT INTO meta (key, value) VALUES ('last_import', ?)
+		ON CONFLICT (key) DO UPDATE SET value = excluded.value`, t.Unix())
+	if err != nil {
+		return fmt.Errorf("recording import time: %w", err)
+	}
+	return nil
+}
+
+// IndexVersion returns the version of the search index name (see package
+// search) the DB holds, or 0 if it has never been built.
+func IndexVersion(db *sql.DB, name string) (int64, error) {
+	return getMeta(db, name)
+}
+
+// SetIndexVersion records, as part of tx, that the search index name has
+// been built at version v.
+func SetIndexVersion(tx *sql.Tx, name string, v int64) error {
+	_, err := tx.Exec(`INSERT INTO meta (key, value) VALUES (?, ?)
+		ON CONFLICT (key) DO UPDATE SET value = excluded.value`, name, v)
+	if err != nil {
+		return fmt.Errorf("recording %s version: %w", name, err)
+	}
+	return nil
+}
+
+// getMeta returns the value stored under key in the meta table, or 0.
+func getMeta(db *sql.DB, key string) (int64, error) {
+	var v int64
+	err := db.QueryRow(`SELECT value FROM meta WHERE key = ?`, key).Scan(&v)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("reading %s: %w", key, err)
+	}
+	return v, nil
+}
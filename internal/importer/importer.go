@@ -1,14 +1,19 @@
 package importer
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/fs"
 	"os"
 	"path/filepath"
 	"strings"
 	"time"
+
+	gccdb "github.com/ThomasAdam/guardian-cc/internal/db"
+	"github.com/ThomasAdam/guardian-cc/internal/search"
 )
 
 // CrosswordJSON matches the JSON structure from the Guardian scraper.
@@ -49,7 +54,11 @@ type DimensionJSON struct {
 
 // Import imports one or more JSON files into the database.
 // If files is empty, it walks the default crossword directories.
-func Import(db *sql.DB, files []string) error {
+// If any crossword is added, the DB's generation counter is bumped; the
+// time the import finished is recorded either way.  The search indexes
+// are brought up to date first (see search.UpdateIndexes).  A line is
+// written to progress for each file and for the indexing.
+func Import(db *sql.DB, files []string, progress io.Writer) (Result, error) {
 	if len(files) == 0 {
 		dirs := []string{
 			"./crosswords/cryptic/setter",
@@ -66,16 +75,23 @@ func Import(db *sql.DB, files []string) error {
 				return nil
 			})
 			if err != nil && !os.IsNotExist(err) {
-				return fmt.Errorf("walking %s: %w", dir, err)
+				return Result{}, fmt.Errorf("walking %s: %w", dir, err)
 			}
 		}
 	}
 
+	_, res, err := importFiles(db, files, progress)
+	return res, err
+}
+
+// importFiles imports files as Import does, and reports for each whether
+// it added a new crossword.
+func importFiles(db *sql.DB, files []string, progress io.Writer) ([]bool, Result, error) {
 	insCW, err := db.Prepare(`INSERT OR IGNORE INTO crosswords
 		(id, number, name, creator_name, creator_weburl, date, crossword_type, pdf)
 		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`)
 	if err != nil {
-		return fmt.Errorf("preparing crossword insert: %w", err)
+		return nil, Result{}, fmt.Errorf("preparing crossword insert: %w", err)
 	}
 	defer insCW.Close()
 
@@ -83,37 +99,56 @@ func Import(db *sql.DB, files []string) error {
 		(crossword_id, entry_id, number, human_number, clue, direction, length, solution, pos_x, pos_y)
 		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
 	if err != nil {
-		return fmt.Errorf("preparing entry insert: %w", err)
+		return nil, Result{}, fmt.Errorf("preparing entry insert: %w", err)
 	}
 	defer insEntry.Close()
 
 	existsStmt, err := db.Prepare("SELECT 1 FROM crosswords WHERE id = ?")
 	if err != nil {
-		return fmt.Errorf("preparing exists check: %w", err)
+		return nil, Result{}, fmt.Errorf("preparing exists check: %w", err)
 	}
 	defer existsStmt.Close()
 
-	for _, f := range files {
-		if err := importFile(db, f, insCW, insEntry, existsStmt); err != nil {
-			fmt.Fprintf(os.Stderr, "Error importing %s: %v\n", f, err)
+	res := Result{Files: len(files)}
+	added := make([]bool, len(files))
+	for i, f := range files {
+		ok, err := importFile(db, f, insCW, insEntry, existsStmt, progress)
+		if err != nil {
+			fmt.Fprintf(progress, "Error importing %s: %v\n", f, err)
+			res.Failed++
 			continue
 		}
+		if ok {
+			res.Added++
+		}
+		added[i] = ok
 	}
-	return nil
-}
-
-func importFile(db *sql.DB, path string, insCW, insEntry, existsStmt *sql.Stmt) error {
-	data, err := os.ReadFile(path)
+	clues, answers, err := search.UpdateIndexes(context.Background(), db)
 	if err != nil {
-		return err
+		return added, res, err
 	}
-
-	var cw CrosswordJSON
-	if err := json.Unmarshal(data, &cw); err != nil {
-		return fmt.Errorf("parsing JSON: %w", err)
+	if clues > 0 || answers > 0 {
+		fmt.Fprintf(progress, "Indexed %d clues and %d answers\n", clues, answers)
 	}
+	if res.Added > 0 {
+		if err := gccdb.BumpGeneration(db); err != nil {
+			return added, res, err
+		}
+	}
+	return added, res, gccdb.RecordImport(db, time.Now())
+}
 
-	// Normalise creator
+// Result summarises an import: how many files were looked at, how many
+// new crosswords they added and how many could not be imported.  Files
+// for crosswords already in the DB are neither added nor failed.
+type Result struct {
+	Files  int
+	Added  int
+	Failed int
+}
+
+// normalise fills in a missing creator and tidies the creator's name.
+func (cw *CrosswordJSON) normalise() {
 	if cw.Creator.Name == "" {
 		cw.Creator.Name = "Unknown"
 	}
@@ -121,6 +156,22 @@ func importFile(db *sql.DB, path string, insCW, insEntry, existsStmt *sql.Stmt)
 	if cw.Creator.WebURL == "" {
 		cw.Creator.WebURL = "http://www.example.org"
 	}
+}
+
+// importFile imports a single file, reporting whether it added a new
+// crossword (as opposed to skipping one that already exists).
+func importFile(db *sql.DB, path string, insCW, insEntry, existsStmt *sql.Stmt, progress io.Writer) (bool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return false, err
+	}
+
+	var cw CrosswordJSON
+	if err := json.Unmarshal(data, &cw); err != nil {
+		return false, fmt.Errorf("parsing JSON: %w", err)
+	}
+
+	cw.normalise()
 
 	// Convert number to string
 	numStr := fmt.Sprintf("%v", cw.Number)
@@ -133,13 +184,13 @@ func importFile(db *sql.DB, path string, insCW, insEntry, existsStmt *sql.Stmt)
 	var dummy int
 	err = existsStmt.QueryRow(cw.ID).Scan(&dummy)
 	if err == nil {
-		fmt.Printf("Skipped (exists): %s\n", cw.ID)
-		return nil
+		fmt.Fprintf(progress, "Skipped (exists): %s\n", cw.ID)
+		return false, nil
 	}
 
 	tx, err := db.Begin()
 	if err != nil {
-		return err
+		return false, err
 	}
 
 	var pdf *string
@@ -153,7 +204,7 @@ func importFile(db *sql.DB, path string, insCW, insEntry, existsStmt *sql.Stmt)
 		dateStr, cw.CrosswordType, pdf,
 	); err != nil {
 		tx.Rollback()
-		return fmt.Errorf("inserting crossword: %w", err)
+		return false, fmt.Errorf("inserting crossword: %w", err)
 	}
 
 	entryStmt := tx.Stmt(insEntry)
@@ -164,13 +215,13 @@ func importFile(db *sql.DB, path string, insCW, insEntry, existsStmt *sql.Stmt)
 			e.Position.X, e.Position.Y,
 		); err != nil {
 			tx.Rollback()
-			return fmt.Errorf("inserting entry: %w", err)
+			return false, fmt.Errorf("inserting entry: %w", err)
 		}
 	}
 
 	if err := tx.Commit(); err != nil {
-		return err
+		return false, err
 	}
-	fmt.Printf("Added: %s\n", cw.ID)
-	return nil
+	fmt.Fprintf(progress, "Added: %s\n", cw.ID)
+	return true, nil
 }
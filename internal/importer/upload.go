@@ -0,0 +1,174 @@
+package importer
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// crosswordTypes are the types of crossword kept in the archive, each
+// under crosswords/<type>/setter.
+var crosswordTypes = []string{"cryptic", "prize"}
+
+// crosswordIDRE matches a crossword ID, e.g. "crosswords/cryptic/21625".
+var crosswordIDRE = regexp.MustCompile(`^crosswords/([a-z]+)/([0-9]+)$`)
+
+// InvalidError reports a crossword that cannot be imported as given.
+type InvalidError struct {
+	// Index is the crossword's position among those uploaded.
+	Index int
+	Err   error
+}
+
+func (e *InvalidError) Error() string {
+	return fmt.Sprintf("crossword %d: %v", e.Index+1, e.Err)
+}
+
+func (e *InvalidError) Unwrap() error { return e.Err }
+
+// ErrConflict is wrapped in the *InvalidError for an uploaded crossword
+// that is already imported, unless the upload is the very file it is
+// kept in.
+var ErrConflict = errors.New("already imported from a different file")
+
+// Upload is the outcome of one uploaded crossword: where it was written
+// and whether it was new to the DB.
+type Upload struct {
+	ID    string
+	Path  string
+	Added bool
+}
+
+// ImportUploads imports crosswords pushed to serve, each a JSON document
+// in the scraper's format (see CrosswordJSON).  They are all checked
+// first, and if any is invalid, or is already imported and is not the
+// same as the file in the archive it would be written to (see
+// ErrConflict), nothing is imported and an *InvalidError is returned.
+// Each is then written to crosswords/<type>/setter/<name>/ under root,
+// as the scraper would have written it, replacing any different file
+// there, and the files are imported as Import imports them, reporting
+// progress the same way.
+func ImportUploads(db *sql.DB, root string, docs []json.RawMessage, progress io.Writer) ([]Upload, Result, error) {
+	uploads := make([]Upload, len(docs))
+	unchanged := make([]bool, len(docs))
+	seen := make(map[string]bool)
+	for i, doc := range docs {
+		var cw CrosswordJSON
+		if err := json.Unmarshal(doc, &cw); err != nil {
+			return nil, Result{}, &InvalidError{i, err}
+		}
+		cw.normalise()
+		path, err := cw.archivePath()
+		if err != nil {
+			return nil, Result{}, &InvalidError{i, err}
+		}
+		if seen[cw.ID] {
+			return nil, Result{}, &InvalidError{i, fmt.Errorf("%s is uploaded twice", cw.ID)}
+		}
+		seen[cw.ID] = true
+		uploads[i] = Upload{ID: cw.ID, Path: filepath.Join(root, path)}
+
+		var one int
+		err = db.QueryRow("SELECT 1 FROM crosswords WHERE id = ?", cw.ID).Scan(&one)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		} else if err != nil {
+			return nil, Result{}, fmt.Errorf("checking for %s: %w", cw.ID, err)
+		}
+		// An imported crossword may be uploaded again only as the file
+		// it is kept in, which must still be where the upload would go.
+		old, err := os.ReadFile(uploads[i].Path)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, Result{}, err
+		}
+		if unchanged[i] = err == nil && bytes.Equal(old, doc); !unchanged[i] {
+			return nil, Result{}, &InvalidError{i, fmt.Errorf("%s: %w", cw.ID, ErrConflict)}
+		}
+	}
+
+	files := make([]string, len(uploads))
+	for i, u := range uploads {
+		if !unchanged[i] {
+			if err := writeFile(u.Path, docs[i]); err != nil {
+				return nil, Result{}, err
+			}
+		}
+		files[i] = u.Path
+	}
+	added, res, err := importFiles(db, files, progress)
+	for i := range uploads {
+		uploads[i].Added = added != nil && added[i]
+	}
+	return uploads, res, err
+}
+
+// archivePath checks that cw can be imported and returns the file it is
+// kept in, relative to the archive's root.
+func (cw *CrosswordJSON) archivePath() (string, error) {
+	m := crosswordIDRE.FindStringSubmatch(cw.ID)
+	switch {
+	case m == nil:
+		return "", fmt.Errorf("invalid id %q (want e.g. crosswords/cryptic/21625)", cw.ID)
+	case !slices.Contains(crosswordTypes, cw.CrosswordType):
+		return "", fmt.Errorf("%s: unknown crosswordType %q (want %s)", cw.ID, cw.CrosswordType, strings.Join(crosswordTypes, " or "))
+	case m[1] != cw.CrosswordType:
+		return "", fmt.Errorf("%s: id does not match crosswordType %q", cw.ID, cw.CrosswordType)
+	case cw.Date <= 0:
+		return "", fmt.Errorf("%s: missing date", cw.ID)
+	case len(cw.Entries) == 0:
+		return "", fmt.Errorf("%s: no entries", cw.ID)
+	}
+	ids := make(map[string]bool)
+	for _, e := range cw.Entries {
+		switch {
+		case e.ID == "" || ids[e.ID]:
+			return "", fmt.Errorf("%s: missing or repeated entry id %q", cw.ID, e.ID)
+		case e.Direction != "across" && e.Direction != "down":
+			return "", fmt.Errorf("%s: entry %s: invalid direction %q", cw.ID, e.ID, e.Direction)
+		case e.Length <= 0:
+			return "", fmt.Errorf("%s: entry %s: invalid length %d", cw.ID, e.ID, e.Length)
+		}
+		ids[e.ID] = true
+	}
+	name := cw.Creator.Name
+	if name == "." || name == ".." || strings.ContainsFunc(name, func(r rune) bool {
+		return r == '/' || r == '\\' || unicode.IsControl(r)
+	}) {
+		return "", fmt.Errorf("%s: creator name %q cannot be used as a directory", cw.ID, name)
+	}
+	return filepath.Join("crosswords", cw.CrosswordType, "setter", name, m[2]+".JSON"), nil
+}
+
+// writeFile writes data to path, creating its directory.  The file
+// appears complete or not at all.
+func writeFile(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Chmod(0o644); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
@@ -0,0 +1,197 @@
+package importer
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	gccdb "github.com/ThomasAdam/guardian-cc/internal/db"
+)
+
+// crossword returns a crossword in the scraper's format, with its fields
+// replaced by those of edits, e.g. {"crosswordType": "prize"}.
+func crossword(t *testing.T, id string, edits map[string]any) json.RawMessage {
+	t.Helper()
+	cw := map[string]any{
+		"id":            id,
+		"number":        strings.TrimPrefix(id, "crosswords/cryptic/"),
+		"name":          "Cryptic crossword No " + id,
+		"creator":       map[string]any{"name": "Araucaria", "webUrl": "https://www.theguardian.com/profile/araucaria"},
+		"date":          1700000000000,
+		"crosswordType": "cryptic",
+		"entries": []any{
+			map[string]any{"id": "1-across", "number": 1, "humanNumber": "1", "clue": "Flower of London (6)",
+				"direction": "across", "length": 6, "solution": "THAMES", "position": map[string]any{"x": 0, "y": 0}},
+			map[string]any{"id": "1-down", "number": 1, "humanNumber": "1", "clue": "Ten (3)",
+				"direction": "down", "length": 3, "solution": "TEN", "position": map[string]any{"x": 0, "y": 0}},
+		},
+	}
+	for k, v := range edits {
+		if v == nil {
+			delete(cw, k)
+		} else {
+			cw[k] = v
+		}
+	}
+	b, err := json.Marshal(cw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// testDB returns an empty DB with the schema, closed when t ends.
+func testDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := gccdb.Open(filepath.Join(t.TempDir(), "test.duckdb"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := gccdb.CreateSchema(db); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestImportUploadsInvalid(t *testing.T) {
+	db := testDB(t)
+	root := t.TempDir()
+	good := crossword(t, "crosswords/cryptic/1", nil)
+	entry := func(edits map[string]any) []any {
+		e := map[string]any{"id": "1-across", "direction": "across", "length": 6}
+		for k, v := range edits {
+			e[k] = v
+		}
+		return []any{e}
+	}
+
+	for _, tt := range []struct {
+		doc  json.RawMessage
+		want string
+	}{
+		{json.RawMessage(`{"id": 1}`), "cannot unmarshal"},
+		{crossword(t, "crosswords/cryptic/x", nil), `invalid id "crosswords/cryptic/x"`},
+		{crossword(t, "../../etc/passwd", nil), "invalid id"},
+		{crossword(t, "crosswords/quick/1", map[string]any{"crosswordType": "quick"}), `unknown crosswordType "quick"`},
+		{crossword(t, "crosswords/prize/1", nil), `id does not match crosswordType "cryptic"`},
+		{crossword(t, "crosswords/cryptic/1", map[string]any{"date": nil}), "missing date"},
+		{crossword(t, "crosswords/cryptic/1", map[string]any{"entries": []any{}}), "no entries"},
+		{crossword(t, "crosswords/cryptic/1", map[string]any{"entries": append(entry(nil), entry(nil)...)}), `repeated entry id "1-across"`},
+		{crossword(t, "crosswords/cryptic/1", map[string]any{"entries": entry(map[string]any{"direction": "up"})}), `invalid direction "up"`},
+		{crossword(t, "crosswords/cryptic/1", map[string]any{"entries": entry(map[string]any{"length": 0})}), "invalid length 0"},
+		{crossword(t, "crosswords/cryptic/1", map[string]any{"creator": map[string]any{"name": "../x"}}), `creator name "../x" cannot be used`},
+		{crossword(t, "crosswords/cryptic/1", map[string]any{"creator": map[string]any{"name": ".."}}), `creator name ".." cannot be used`},
+		{crossword(t, "crosswords/cryptic/1", map[string]any{"creator": map[string]any{"name": "a\x00b"}}), "cannot be used"},
+	} {
+		_, _, err := ImportUploads(db, root, []json.RawMessage{good, tt.doc}, io.Discard)
+		var ie *InvalidError
+		if !errors.As(err, &ie) || ie.Index != 1 || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("ImportUploads(%s) = %v, want an InvalidError for crossword 2 containing %q", tt.doc, err, tt.want)
+		}
+	}
+
+	_, _, err := ImportUploads(db, root, []json.RawMessage{good, good}, io.Discard)
+	if err == nil || !strings.Contains(err.Error(), "uploaded twice") {
+		t.Errorf("ImportUploads of a crossword twice = %v, want an error", err)
+	}
+
+	// Nothing was written or imported.
+	if entries, err := os.ReadDir(root); err != nil || len(entries) != 0 {
+		t.Errorf("root holds %d files (%v) after invalid uploads, want none", len(entries), err)
+	}
+	var n int
+	if err := db.QueryRow("SELECT COUNT(*) FROM crosswords").Scan(&n); err != nil || n != 0 {
+		t.Errorf("%d crosswords imported (%v), want none", n, err)
+	}
+}
+
+func TestImportUploads(t *testing.T) {
+	db := testDB(t)
+	root := t.TempDir()
+	first := crossword(t, "crosswords/cryptic/1", nil)
+	path := filepath.Join(root, "crosswords", "cryptic", "setter", "Araucaria", "1.JSON")
+
+	upload := func(docs ...json.RawMessage) ([]Upload, Result, error) {
+		t.Helper()
+		return ImportUploads(db, root, docs, io.Discard)
+	}
+	uploads, res, err := upload(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(uploads) != 1 || uploads[0] != (Upload{ID: "crosswords/cryptic/1", Path: path, Added: true}) || res.Added != 1 {
+		t.Errorf("first upload = %+v, %+v; want crossword 1 added at %s", uploads, res, path)
+	}
+	if b, err := os.ReadFile(path); err != nil || string(b) != string(first) {
+		t.Errorf("%s holds %q (%v), want the upload", path, b, err)
+	}
+
+	// The same crossword again is left alone.
+	uploads, res, err = upload(first)
+	if err != nil || uploads[0].Added || res.Added != 0 || res.Failed != 0 {
+		t.Errorf("second upload = %+v, %+v, %v; want nothing added", uploads, res, err)
+	}
+
+	// A different one with the same id is refused, along with the rest.
+	second := crossword(t, "crosswords/cryptic/2", nil)
+	changed := crossword(t, "crosswords/cryptic/1", map[string]any{"name": "Changed"})
+	_, _, err = upload(second, changed)
+	var ie *InvalidError
+	if !errors.Is(err, ErrConflict) || !errors.As(err, &ie) || ie.Index != 1 {
+		t.Errorf("conflicting upload = %v, want ErrConflict for crossword 2", err)
+	}
+	if b, err := os.ReadFile(path); err != nil || string(b) != string(first) {
+		t.Errorf("%s was overwritten by a conflicting upload: %q, %v", path, b, err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "crosswords", "cryptic", "setter", "Araucaria", "2.JSON")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("crossword 2 was written despite the conflict: %v", err)
+	}
+
+	// A file for a crossword not yet imported is replaced by the upload.
+	other := filepath.Join(root, "crosswords", "cryptic", "setter", "Araucaria", "3.JSON")
+	if err := os.WriteFile(other, []byte("{truncated"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	third := crossword(t, "crosswords/cryptic/3", nil)
+	uploads, res, err = upload(third)
+	if err != nil || !uploads[0].Added || res.Added != 1 {
+		t.Errorf("upload over a stale file = %+v, %+v, %v; want crossword 3 added", uploads, res, err)
+	}
+	if b, err := os.ReadFile(other); err != nil || string(b) != string(third) {
+		t.Errorf("%s holds %q (%v), want the upload", other, b, err)
+	}
+
+	// So is an imported crossword whose file would go elsewhere, because
+	// its setter's name has changed, or whose file has gone.
+	renamed := crossword(t, "crosswords/cryptic/1", map[string]any{
+		"creator": map[string]any{"name": "Paul", "webUrl": "https://www.theguardian.com/profile/paul"},
+	})
+	if err := os.Remove(other); err != nil {
+		t.Fatal(err)
+	}
+	for _, doc := range []json.RawMessage{renamed, third} {
+		if _, _, err := upload(doc); !errors.Is(err, ErrConflict) {
+			t.Errorf("upload of an imported crossword to a new file = %v, want ErrConflict", err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(root, "crosswords", "cryptic", "setter", "Paul")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("crossword 1 was written under its new setter despite the conflict: %v", err)
+	}
+	if _, err := os.Stat(other); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("crossword 3 was written again despite the conflict: %v", err)
+	}
+
+	var n int
+	if err := db.QueryRow("SELECT COUNT(*) FROM crosswords").Scan(&n); err != nil || n != 2 {
+		t.Errorf("%d crosswords imported (%v), want 2", n, err)
+	}
+	if leftovers, _ := filepath.Glob(filepath.Join(filepath.Dir(path), ".upload-*")); len(leftovers) > 0 {
+		t.Errorf("temporary files left behind: %q", leftovers)
+	}
+}
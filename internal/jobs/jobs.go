@@ -0,0 +1,319 @@
+// Package jobs runs serve's background jobs (import, refresh and render)
+// one at a time, keeping their history in the jobs table and their
+// progress in memory while they run, so that it can be followed live.
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ThomasAdam/guardian-cc/api"
+)
+
+// Job states.
+const (
+	Queued    = "queued"
+	Running   = "running"
+	Succeeded = "succeeded"
+	Failed    = "failed"
+)
+
+// Limits on what is kept.  A job's output beyond maxOutputLines loses
+// its earliest lines (see api.Job.Dropped); only the latest maxHistory
+// jobs are kept.
+const (
+	maxQueued      = 10
+	maxOutputLines = 2000
+	maxHistory     = 500
+)
+
+var (
+	// ErrUnknownKind is returned by Submit for a kind of job it cannot run.
+	ErrUnknownKind = errors.New("unknown kind of job")
+	// ErrQueueFull is returned by Submit when too many jobs are waiting.
+	ErrQueueFull = fmt.Errorf("too many jobs queued (at most %d)", maxQueued)
+	// ErrNotFound is returned for a job that is not in the history.
+	ErrNotFound = errors.New("job not found")
+)
+
+// Task is the work of a kind of job.  It writes its progress to w, a line
+// at a time.
+type Task func(w io.Writer) error
+
+// Runner queues and runs jobs.
+type Runner struct {
+	db    *sql.DB
+	tasks map[string]Task
+	// lock is held while a job runs, so that other writers to the DB
+	// (crossword uploads) wait for it.
+	lock  sync.Locker
+	queue chan int64
+
+	mu   sync.Mutex
+	live map[int64]*liveJob // queued and running jobs
+}
+
+// liveJob is the progress of a queued or running job.
+type liveJob struct {
+	kind  string
+	lines []string // the last maxOutputLines lines
+	first int      // the number of lines dropped from the front
+	// changed is closed, and replaced, whenever a line is added or the
+	// job finishes.
+	changed chan struct{}
+}
+
+// New returns a Runner for the given kinds of job.  Jobs left queued or
+// running by a previous server are marked failed.
+func New(db *sql.DB, tasks map[string]Task, lock sync.Locker) (*Runner, error) {
+	_, err := db.Exec(`UPDATE jobs SET state = ?, error = 'interrupted: the server stopped', finished = now()
+		WHERE state IN (?, ?)`, Failed, Queued, Running)
+	if err != nil {
+		return nil, fmt.Errorf("marking interrupted jobs: %w", err)
+	}
+	return &Runner{
+		db:    db,
+		tasks: tasks,
+		lock:  lock,
+		queue: make(chan int64, maxQueued),
+		live:  make(map[int64]*liveJob),
+	}, nil
+}
+
+// Run runs queued jobs, one at a time, until ctx is cancelled.  A job
+// already running is finished first.
+func (r *Runner) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case id := <-r.queue:
+			r.run(id)
+		}
+	}
+}
+
+// Submit queues a job of the given kind.
+func (r *Runner) Submit(kind string) (api.Job, error) {
+	if _, ok := r.tasks[kind]; !ok {
+		return api.Job{}, ErrUnknownKind
+	}
+	r.mu.Lock()
+	if len(r.live) >= maxQueued {
+		r.mu.Unlock()
+		return api.Job{}, ErrQueueFull
+	}
+
+	var id int64
+	err := r.db.QueryRow(`INSERT INTO jobs (id, kind, state, created)
+		VALUES (nextval('job_ids'), ?, ?, now()) RETURNING id`, kind, Queued).Scan(&id)
+	if err != nil {
+		r.mu.Unlock()
+		return api.Job{}, fmt.Errorf("queueing job: %w", err)
+	}
+	_, err = r.db.Exec(`DELETE FROM jobs WHERE id NOT IN (SELECT id FROM jobs ORDER BY id DESC LIMIT ?)`, maxHistory)
+	if err != nil {
+		log.Printf("error pruning job history: %v", err)
+	}
+	r.live[id] = &liveJob{kind: kind, changed: make(chan struct{})}
+	r.queue <- id
+	r.mu.Unlock()
+	return r.get(id)
+}
+
+// run runs a queued job and records how it went.
+func (r *Runner) run(id int64) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.mu.Lock()
+	job := r.live[id]
+	r.mu.Unlock()
+	if _, err := r.db.Exec(`UPDATE jobs SET state = ?, started = now() WHERE id = ?`, Running, id); err != nil {
+		log.Printf("error starting job %d: %v", id, err)
+	}
+	log.Printf("Running job %d (%s)", id, job.kind)
+
+	w := &lineWriter{r: r, job: job}
+	err := runTask(r.tasks[job.kind], w)
+	w.flush()
+
+	state, msg := Succeeded, ""
+	if err != nil {
+		state, msg = Failed, err.Error()
+		r.addLine(job, "Error: "+msg)
+	}
+	log.Printf("Job %d (%s) %s", id, job.kind, state)
+
+	r.mu.Lock()
+	output, dropped := strings.Join(job.lines, "\n"), job.first
+	r.mu.Unlock()
+	_, dbErr := r.db.Exec(`UPDATE jobs SET state = ?, finished = now(), error = ?, output = ?, dropped = ? WHERE id = ?`,
+		state, msg, output, dropped, id)
+	if dbErr != nil {
+		log.Printf("error recording job %d: %v", id, dbErr)
+	}
+
+	r.mu.Lock()
+	delete(r.live, id)
+	close(job.changed)
+	r.mu.Unlock()
+}
+
+// runTask runs t, turning a panic into an error so that one bad job does
+// not take the server down.
+func runTask(t Task, w io.Writer) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("panic: %v", p)
+		}
+	}()
+	return t(w)
+}
+
+// addLine appends a line to a job's output and wakes its followers.
+func (r *Runner) addLine(job *liveJob, line string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	job.lines = append(job.lines, strings.TrimRight(line, "\r"))
+	if len(job.lines) > maxOutputLines {
+		n := len(job.lines) - maxOutputLines
+		job.lines = append(job.lines[:0:0], job.lines[n:]...)
+		job.first += n
+	}
+	close(job.changed)
+	job.changed = make(chan struct{})
+}
+
+// lineWriter is the io.Writer a job's task writes its progress to.
+type lineWriter struct {
+	r       *Runner
+	job     *liveJob
+	partial []byte
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.partial = append(w.partial, p...)
+	for {
+		i := strings.IndexByte(string(w.partial), '\n')
+		if i < 0 {
+			break
+		}
+		w.r.addLine(w.job, string(w.partial[:i]))
+		w.partial = w.partial[i+1:]
+	}
+	return len(p), nil
+}
+
+// flush adds any unfinished last line.
+func (w *lineWriter) flush() {
+	if len(w.partial) > 0 {
+		w.r.addLine(w.job, string(w.partial))
+		w.partial = nil
+	}
+}
+
+// Get returns a job with its output so far.
+func (r *Runner) Get(id int64) (api.Job, error) {
+	return r.get(id)
+}
+
+func (r *Runner) get(id int64) (api.Job, error) {
+	row := r.db.QueryRow(`SELECT `+jobColumns+`, COALESCE(output, ''), COALESCE(dropped, 0) FROM jobs WHERE id = ?`, id)
+	var output string
+	var dropped int
+	j, err := scanJob(row, &output, &dropped)
+	if err == sql.ErrNoRows {
+		return j, ErrNotFound
+	}
+	if err != nil {
+		return j, fmt.Errorf("reading job %d: %w", id, err)
+	}
+	r.mu.Lock()
+	if job, ok := r.live[id]; ok {
+		j.Output, j.Dropped = append([]string{}, job.lines...), job.first
+		r.mu.Unlock()
+		return j, nil
+	}
+	r.mu.Unlock()
+	if output != "" {
+		j.Output, j.Dropped = strings.Split(output, "\n"), dropped
+	}
+	return j, nil
+}
+
+// List returns a page of the job history, newest first, without the
+// jobs' output, and how many jobs there are in all.
+func (r *Runner) List(offset, limit int) ([]api.Job, int, error) {
+	var total int
+	if err := r.db.QueryRow(`SELECT COUNT(*) FROM jobs`).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("counting jobs: %w", err)
+	}
+	rows, err := r.db.Query(`SELECT `+jobColumns+` FROM jobs ORDER BY id DESC LIMIT ? OFFSET ?`, limit, offset)
+	if err != nil {
+		return nil, 0, fmt.Errorf("listing jobs: %w", err)
+	}
+	defer rows.Close()
+	jobs := make([]api.Job, 0)
+	for rows.Next() {
+		j, err := scanJob(rows)
+		if err != nil {
+			return nil, 0, fmt.Errorf("listing jobs: %w", err)
+		}
+		jobs = append(jobs, j)
+	}
+	return jobs, total, rows.Err()
+}
+
+// Follow returns the lines of a job's output from line from on, the
+// number of the line after them, and a channel that is closed when there
+// is more to read.  The channel is nil once the job has finished and
+// there is no more.  Lines no longer kept are skipped, and from past the
+// end of the output reads nothing.
+func (r *Runner) Follow(id int64, from int) ([]string, int, <-chan struct{}, error) {
+	r.mu.Lock()
+	if job, ok := r.live[id]; ok {
+		defer r.mu.Unlock()
+		end := job.first + len(job.lines)
+		from = min(max(from, job.first), end)
+		lines := append([]string{}, job.lines[from-job.first:]...)
+		return lines, end, job.changed, nil
+	}
+	r.mu.Unlock()
+
+	j, err := r.get(id)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	end := j.Dropped + len(j.Output)
+	from = max(from, j.Dropped)
+	if from >= end {
+		return nil, end, nil, nil
+	}
+	return j.Output[from-j.Dropped:], end, nil, nil
+}
+
+// jobColumns selects a job in the order scanJob reads it.
+const jobColumns = `id, kind, state, created, started, finished, COALESCE(error, '')`
+
+func scanJob(s interface{ Scan(...any) error }, extra ...any) (api.Job, error) {
+	var j api.Job
+	var created, started, finished sql.NullTime
+	err := s.Scan(append([]any{&j.ID, &j.Kind, &j.State, &created, &started, &finished, &j.Error}, extra...)...)
+	for _, t := range []struct {
+		src sql.NullTime
+		dst *string
+	}{{created, &j.Created}, {started, &j.Started}, {finished, &j.Finished}} {
+		if t.src.Valid {
+			*t.dst = t.src.Time.UTC().Format(time.RFC3339)
+		}
+	}
+	return j, err
+}
@@ -0,0 +1,159 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ThomasAdam/guardian-cc/internal/db"
+)
+
+// newRunner returns a running Runner, on a fresh DB, for the given tasks.
+func newRunner(t *testing.T, tasks map[string]Task) *Runner {
+	t.Helper()
+	database, err := db.Open(filepath.Join(t.TempDir(), "jobs.duckdb"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { database.Close() })
+	if err := db.CreateSchema(database); err != nil {
+		t.Fatal(err)
+	}
+	r, err := New(database, tasks, &sync.Mutex{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		r.Run(ctx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return r
+}
+
+// waitFor follows a job until it has at least n lines of output, or
+// until it has finished if n is -1.
+func waitFor(t *testing.T, r *Runner, id int64, n int) {
+	t.Helper()
+	deadline := time.After(10 * time.Second)
+	for {
+		_, next, changed, err := r.Follow(id, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (n >= 0 && next >= n) || changed == nil {
+			return
+		}
+		select {
+		case <-changed:
+		case <-deadline:
+			t.Fatalf("job %d: timed out waiting for %d lines", id, n)
+		}
+	}
+}
+
+func TestFollowPastEnd(t *testing.T) {
+	release := make(chan struct{})
+	r := newRunner(t, map[string]Task{
+		"test": func(w io.Writer) error {
+			for i := range 3 {
+				fmt.Fprintf(w, "line %d\n", i)
+			}
+			<-release
+			return nil
+		},
+	})
+	j, err := r.Submit("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, r, j.ID, 3)
+
+	// While the job runs.
+	for _, from := range []int{3, 4, 100000} {
+		lines, next, changed, err := r.Follow(j.ID, from)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(lines) != 0 || next != 3 || changed == nil {
+			t.Errorf("Follow(%d) while running = %q, %d, %v; want no lines, 3 and a channel", from, lines, next, changed)
+		}
+	}
+	lines, next, _, err := r.Follow(j.ID, 1)
+	if err != nil || next != 3 || len(lines) != 2 || lines[0] != "line 1" {
+		t.Errorf("Follow(1) while running = %q, %d, %v; want line 1 and line 2, 3", lines, next, err)
+	}
+
+	// Once it has finished.
+	close(release)
+	waitFor(t, r, j.ID, -1)
+	for _, from := range []int{3, 100000} {
+		lines, next, changed, err := r.Follow(j.ID, from)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(lines) != 0 || next != 3 || changed != nil {
+			t.Errorf("Follow(%d) when finished = %q, %d, %v; want no lines, 3 and no channel", from, lines, next, changed)
+		}
+	}
+}
+
+func TestDroppedLines(t *testing.T) {
+	const n = maxOutputLines + 5
+	r := newRunner(t, map[string]Task{
+		"test": func(w io.Writer) error {
+			for i := range n {
+				fmt.Fprintf(w, "line %d\n", i)
+			}
+			return nil
+		},
+	})
+	j, err := r.Submit("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, r, j.ID, -1)
+
+	got, err := r.Get(j.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.State != Succeeded || got.Dropped != 5 || len(got.Output) != maxOutputLines || got.Output[0] != "line 5" {
+		t.Errorf("Get = %s, dropped %d, %d lines from %q; want succeeded, 5, %d from line 5",
+			got.State, got.Dropped, len(got.Output), got.Output[0], maxOutputLines)
+	}
+	lines, next, _, err := r.Follow(j.ID, 0)
+	if err != nil || next != n || len(lines) != maxOutputLines {
+		t.Errorf("Follow(0) = %d lines, %d, %v; want %d, %d", len(lines), next, err, maxOutputLines, n)
+	}
+}
+
+func TestFailedTask(t *testing.T) {
+	r := newRunner(t, map[string]Task{
+		"panic": func(w io.Writer) error { panic("oops") },
+	})
+	if _, err := r.Submit("nope"); err != ErrUnknownKind {
+		t.Errorf("Submit(nope) = %v, want ErrUnknownKind", err)
+	}
+	j, err := r.Submit("panic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, r, j.ID, -1)
+	got, err := r.Get(j.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.State != Failed || got.Error != "panic: oops" {
+		t.Errorf("Get = %s, %q; want failed, panic: oops", got.State, got.Error)
+	}
+}
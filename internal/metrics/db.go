@@ -0,0 +1,55 @@
+package metrics
+
+import (
+	"database/sql"
+	"log"
+
+	gccdb "github.com/ThomasAdam/guardian-cc/internal/db"
+)
+
+// dbTables are the tables whose row counts are reported.
+var dbTables = []string{"crosswords", "entries"}
+
+// RegisterDB adds gauges describing the contents of db to r, read afresh
+// on every write: row counts, the generation, when the last import
+// finished and the date of the latest crossword.  The last two are what
+// stale-data alerts want.  constLabels are name, value pairs added to
+// every series, so that several textfiles can report on the same DB
+// without clashing.
+func RegisterDB(r *Registry, db *sql.DB, constLabels ...string) {
+	var names, values []string
+	for i := 0; i+1 < len(constLabels); i += 2 {
+		names = append(names, constLabels[i])
+		values = append(values, constLabels[i+1])
+	}
+	rows := r.Gauge("gcc_db_rows", "Rows in each DuckDB table.", append([]string{"table"}, names...)...)
+	generation := r.Gauge("gcc_db_generation", "Generation counter, bumped by every import that adds crosswords.", names...)
+	lastImport := r.Gauge("gcc_last_import_timestamp_seconds", "When the last import finished, as a Unix time.", names...)
+	latest := r.Gauge("gcc_latest_crossword_timestamp_seconds", "Publication date of the newest crossword, as a Unix time.", names...)
+
+	r.OnCollect(func() {
+		for _, t := range dbTables {
+			var n int64
+			if err := db.QueryRow("SELECT COUNT(*) FROM " + t).Scan(&n); err != nil {
+				log.Printf("metrics: counting %s: %v", t, err)
+				continue
+			}
+			rows.Set(float64(n), append([]string{t}, values...)...)
+		}
+		if gen, err := gccdb.Generation(db); err != nil {
+			log.Printf("metrics: %v", err)
+		} else {
+			generation.Set(float64(gen), values...)
+		}
+		if t, err := gccdb.LastImport(db); err != nil {
+			log.Printf("metrics: %v", err)
+		} else {
+			lastImport.SetTime(t, values...)
+		}
+		if t, err := gccdb.LatestDate(db); err != nil {
+			log.Printf("metrics: %v", err)
+		} else {
+			latest.SetTime(t, values...)
+		}
+	})
+}
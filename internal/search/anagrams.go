@@ -0,0 +1,152 @@
+package search
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/ThomasAdam/guardian-cc/api"
+)
+
+// lightRefRE matches one of the lights an answer is spread over, as listed
+// in its first light's human number: "14" or "16 down".
+var lightRefRE = regexp.MustCompile(`^(\d+)(?:\s+(across|down))?$`)
+
+// anagramIndex holds each answer in the archive with its signature, so
+// that anagrams are found by looking the signature up.
+var anagramIndex = derivedIndex{
+	name:    "anagram_index",
+	version: 2,
+	tables:  []string{"answers"},
+	rows: func(entries []entry) [][][]driver.Value {
+		var rows [][]driver.Value
+		for _, e := range entries {
+			if crossRefClue.MatchString(e.clue) {
+				continue
+			}
+			answer, ok := fullAnswer(e, entries)
+			if !ok {
+				continue
+			}
+			var en any
+			if m := enumSuffixRE.FindString(clueText(e.clue)); m != "" {
+				if s, length, err := parseEnumeration(m); err == nil && length == utf8.RuneCountInString(answer) {
+					en = s
+				}
+			}
+			rows = append(rows, []driver.Value{e.crosswordID, e.entryID, answer, en, signature(answer)})
+		}
+		return [][][]driver.Value{rows}
+	},
+}
+
+// fullAnswer returns the letters of the answer clued at e, which is spread
+// over further lights of the same crossword when e's human number lists
+// them ("14, 26" or "17, 16 down").  It reports false if e has no answer
+// or a light it lists is missing.
+func fullAnswer(e entry, entries []entry) (string, bool) {
+	var b strings.Builder
+	b.WriteString(letters(e.solution))
+	parts := strings.Split(e.humanNumber, ",")
+	for _, part := range parts[1:] {
+		m := lightRefRE.FindStringSubmatch(strings.TrimSpace(part))
+		if m == nil {
+			return "", false
+		}
+		n, _ := strconv.Atoi(m[1])
+		// A bare number may be either direction; the light that is
+		// part of this answer is the one clued as a cross-reference.
+		var light *entry
+		for i := range entries {
+			t := &entries[i]
+			if t.number != n || (m[2] != "" && t.direction != m[2]) || t.entryID == e.entryID {
+				continue
+			}
+			if light == nil || crossRefClue.MatchString(t.clue) {
+				light = t
+			}
+		}
+		if light == nil {
+			return "", false
+		}
+		b.WriteString(letters(light.solution))
+	}
+	return b.String(), b.Len() > 0
+}
+
+// letters returns the letters of s, upper-cased.
+func letters(s string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) {
+			return unicode.ToUpper(r)
+		}
+		return -1
+	}, s)
+}
+
+// signature returns the letters of an upper-cased answer in order, which
+// its anagrams share.
+func signature(answer string) string {
+	rs := []rune(answer)
+	slices.Sort(rs)
+	return string(rs)
+}
+
+// anagramUses selects, as "enumerated", every use of an answer with the
+// signature and LIKE pattern that are its first two args, other than the
+// answer that is its third.
+const anagramUses = `
+	WITH enumerated AS (
+		SELECT a.answer, a.enumeration,
+		       COALESCE(e.clue, '') AS clue, a.answer AS solution,
+		       COALESCE(e.human_number, '') || ' ' || e.direction AS entry, e.entry_id,
+		       c.id, COALESCE(c.number, '') AS number, COALESCE(c.creator_name, '') AS setter,
+		       c.date, COALESCE(c.crossword_type, '') AS type
+		FROM answers a
+		JOIN entries e ON e.crossword_id = a.crossword_id AND e.entry_id = a.entry_id
+		JOIN crosswords c ON c.id = a.crossword_id
+		WHERE a.signature = ? AND a.answer LIKE ? AND a.answer <> ?
+	)`
+
+// Anagrams returns a page of the answers that are anagrams of the letters
+// in s, ranked as Answers ranks them, and how many there are in all.
+// Answers spread over several lights count, as whole answers.  p, which
+// may be the zero Pattern, narrows the search to answers fitting it; it
+// must be as long as the letters.  Bad input is reported as a
+// *QueryError, and an index that needs rebuilding as ErrStaleIndex.
+func Anagrams(ctx context.Context, db *sql.DB, s string, p Pattern, examples, offset, limit int) ([]api.AnswerMatch, int, error) {
+	ls := letters(s)
+	n := utf8.RuneCountInString(ls)
+	switch {
+	case ls == "":
+		return nil, 0, queryErrorf("no letters to find anagrams of")
+	case p.Length == 0:
+		p.like = strings.Repeat("_", n)
+	case p.Length != n:
+		return nil, 0, queryErrorf("the pattern has %d letters but there are %d", p.Length, n)
+	}
+	if err := checkIndex(db, anagramIndex); err != nil {
+		return nil, 0, err
+	}
+	return rankAnswers(ctx, db, anagramUses, []any{signature(ls), p.like, ls}, p.Enumeration, examples, offset, limit)
+}
+
+// AnagramPattern parses the optional pattern and enumeration narrowing an
+// anagram search (see ParsePattern), returning the zero Pattern if both
+// are empty.
+func AnagramPattern(pattern, enumeration string) (Pattern, error) {
+	if pattern == "" && enumeration == "" {
+		return Pattern{}, nil
+	}
+	p, err := ParsePattern(pattern, enumeration)
+	if err != nil {
+		return p, &QueryError{err.Error()}
+	}
+	return p, nil
+}
@@ -0,0 +1,245 @@
+// Package search looks things up in the archive for solvers: answers that
+// fit a pattern of known and unknown letters, and the clues given for
+// them.  It is shared by serve's /api/search endpoints and the search
+// command.
+package search
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/ThomasAdam/guardian-cc/api"
+)
+
+// MaxExamples bounds how many example clues are returned per answer.
+const MaxExamples = 10
+
+// Pattern is an answer pattern: the letters of the answer, some of them
+// unknown, and optionally its enumeration.
+type Pattern struct {
+	// like matches the answer's letters, with _ for each unknown.
+	like string
+	// Length is the number of letters.
+	Length int
+	// Enumeration is the answer's word lengths as a clue gives them,
+	// e.g. "3,4" or "1-5", or empty if not known.
+	Enumeration string
+}
+
+// enumerationRE matches a normalised enumeration: word lengths separated
+// by commas (word breaks) or hyphens.
+var enumerationRE = regexp.MustCompile(`^[0-9]+([,-][0-9]+)*$`)
+
+// ParsePattern parses pattern and enumeration, either of which may be
+// empty but not both.  In pattern, letters and digits are known, '?', '.'
+// and '_' are unknown, and a space, ',' or '-' marks where a word ends,
+// which gives the enumeration: "?A? ?B?E" is the same as "?A??B?E" with
+// enumeration "3,4".  An enumeration alone, e.g. "3,4", matches any answer
+// with those word lengths.
+func ParsePattern(pattern, enumeration string) (Pattern, error) {
+	var p Pattern
+	var like strings.Builder
+	var words []string
+	word := 0
+	sep := ""
+	for _, r := range strings.TrimSpace(pattern) {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			like.WriteRune(unicode.ToUpper(r))
+		case r == '?' || r == '.' || r == '_':
+			like.WriteByte('_')
+		case r == ' ' || r == ',' || r == '-':
+			if word == 0 {
+				return p, fmt.Errorf("pattern %q has a word break in the wrong place", pattern)
+			}
+			words = append(words, sep+strconv.Itoa(word))
+			word = 0
+			sep = ","
+			if r == '-' {
+				sep = "-"
+			}
+			continue
+		default:
+			return p, fmt.Errorf("pattern %q: unexpected %q (use letters, ? for unknowns and spaces or hyphens between words)", pattern, r)
+		}
+		word++
+		p.Length++
+	}
+	if len(words) > 0 {
+		if word == 0 {
+			return p, fmt.Errorf("pattern %q has a word break in the wrong place", pattern)
+		}
+		p.Enumeration = strings.Join(words, "") + sep + strconv.Itoa(word)
+	}
+
+	if enumeration != "" {
+		en, length, err := parseEnumeration(enumeration)
+		if err != nil {
+			return p, err
+		}
+		switch {
+		case p.Length == 0:
+			p.Length = length
+			like.WriteString(strings.Repeat("_", length))
+		case length != p.Length:
+			return p, fmt.Errorf("enumeration %s is %d letters but the pattern has %d", en, length, p.Length)
+		case p.Enumeration != "" && p.Enumeration != en:
+			return p, fmt.Errorf("enumeration %s does not match the pattern's (%s)", en, p.Enumeration)
+		}
+		p.Enumeration = en
+	}
+	if p.Length == 0 {
+		return p, fmt.Errorf("empty pattern")
+	}
+	p.like = like.String()
+	return p, nil
+}
+
+// parseEnumeration normalises an enumeration such as "(3, 4)" to "3,4"
+// and returns it with the number of letters it adds up to.
+func parseEnumeration(s string) (string, int, error) {
+	en := strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) {
+			return -1
+		}
+		return r
+	}, strings.Trim(s, " ()"))
+	if !enumerationRE.MatchString(en) {
+		return "", 0, fmt.Errorf("invalid enumeration %q (want e.g. 3,4 or 1-5)", s)
+	}
+	length := 0
+	for _, f := range strings.FieldsFunc(en, func(r rune) bool { return r == ',' || r == '-' }) {
+		n, _ := strconv.Atoi(f)
+		if n == 0 {
+			return "", 0, fmt.Errorf("invalid enumeration %q: empty word", s)
+		}
+		length += n
+	}
+	return en, length, nil
+}
+
+// answerUses selects, as "enumerated", every entry whose answer fits a
+// pattern (the length and LIKE pattern are its two args) along with the
+// enumeration its clue gives.  An enumeration that does not add up to the
+// entry's length belongs to an answer spread over several lights and is
+// treated as unknown.  Cross-references ("See 5") are resolved to the
+// clue they point at.
+const answerUses = `
+	WITH clued AS (
+		SELECT e.*,
+		       regexp_replace(regexp_extract(e.clue, '\(([0-9][0-9,\- ]*)\)\s*$', 1), '\s+', '', 'g') AS en
+		FROM resolved_entries e
+		WHERE e.length = ? AND upper(e.solution) LIKE ?
+	),
+	enumerated AS (
+		SELECT upper(e.solution) AS answer,
+		       CASE WHEN e.en <> '' AND list_sum(list_transform(regexp_extract_all(e.en, '[0-9]+'),
+		                                                        x -> CAST(x AS INTEGER))) = e.length
+		            THEN e.en END AS enumeration,
+		       COALESCE(e.clue, '') AS clue, e.solution,
+		       COALESCE(e.human_number, '') || ' ' || e.direction AS entry, e.entry_id,
+		       c.id, COALESCE(c.number, '') AS number, COALESCE(c.creator_name, '') AS setter,
+		       c.date, COALESCE(c.crossword_type, '') AS type
+		FROM clued e JOIN crosswords c ON e.crossword_id = c.id
+	)`
+
+// usesWindow follows a WITH selecting "enumerated" and adds, as "uses",
+// whether any use of the same answer gives the enumeration that is its
+// arg (fits), and whether any gives one at all (known).
+const usesWindow = `,
+	uses AS (
+		SELECT *,
+		       COALESCE(bool_or(enumeration = ?) OVER (PARTITION BY answer), false) AS fits,
+		       bool_or(enumeration IS NOT NULL) OVER (PARTITION BY answer) AS known
+		FROM enumerated
+	)`
+
+// usesWhere keeps, when the pattern has an enumeration (given twice as
+// args), the uses whose clue gives that enumeration, and those whose clue
+// gives none unless other clues show the answer has a different one.
+const usesWhere = `WHERE (? = '' OR enumeration = ? OR (enumeration IS NULL AND (fits OR NOT known)))`
+
+// Answers returns a page of the answers fitting p, most used first, each
+// with up to examples of its most recent clues, and the number of answers
+// fitting p in all.  When p has an enumeration, only answers whose clues
+// give it, or give none at all, are returned (see usesWhere), and those
+// whose clues give it come first.
+func Answers(ctx context.Context, db *sql.DB, p Pattern, examples, offset, limit int) ([]api.AnswerMatch, int, error) {
+	return rankAnswers(ctx, db, answerUses, []any{p.Length, p.like}, p.Enumeration, examples, offset, limit)
+}
+
+// rankAnswers runs the search whose uses are selected by with, a WITH
+// selecting "enumerated" given withArgs, and returns a page of its
+// answers as Answers describes, with the number of answers in all.
+// enumeration is the one asked for, if any.
+func rankAnswers(ctx context.Context, db *sql.DB, with string, withArgs []any, enumeration string, examples, offset, limit int) ([]api.AnswerMatch, int, error) {
+	with += usesWindow
+	args := append(append([]any{}, withArgs...), enumeration, enumeration, enumeration)
+
+	var total int
+	err := db.QueryRowContext(ctx, with+`
+		SELECT COUNT(DISTINCT answer) FROM uses `+usesWhere, args...).Scan(&total)
+	if err != nil {
+		return nil, 0, fmt.Errorf("counting answers: %w", err)
+	}
+
+	rows, err := db.QueryContext(ctx, with+`
+		SELECT answer, COALESCE(mode(enumeration), ''), COUNT(*) AS n, COUNT(DISTINCT setter)
+		FROM uses `+usesWhere+`
+		GROUP BY answer
+		ORDER BY bool_or(fits) DESC, n DESC, answer
+		LIMIT ? OFFSET ?`, append(args, limit, offset)...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("finding answers: %w", err)
+	}
+	defer rows.Close()
+	matches := make([]api.AnswerMatch, 0)
+	index := make(map[string]int)
+	for rows.Next() {
+		m := api.AnswerMatch{Examples: make([]api.AnswerClue, 0)}
+		if err := rows.Scan(&m.Answer, &m.Enumeration, &m.Uses, &m.Setters); err != nil {
+			return nil, 0, fmt.Errorf("finding answers: %w", err)
+		}
+		index[m.Answer] = len(matches)
+		matches = append(matches, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("finding answers: %w", err)
+	}
+	if examples <= 0 || len(matches) == 0 {
+		return matches, total, nil
+	}
+
+	in := strings.TrimSuffix(strings.Repeat("?, ", len(matches)), ", ")
+	exArgs := append([]any{}, args...)
+	for _, m := range matches {
+		exArgs = append(exArgs, m.Answer)
+	}
+	rows, err = db.QueryContext(ctx, with+`
+		SELECT answer, clue, solution, entry, id, number, setter, COALESCE(CAST(date AS VARCHAR), ''), type
+		FROM uses `+usesWhere+` AND answer IN (`+in+`)
+		QUALIFY row_number() OVER (PARTITION BY answer ORDER BY date DESC, id DESC, entry_id) <= ?
+		ORDER BY answer, date DESC, id DESC, entry_id`, append(exArgs, min(examples, MaxExamples))...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("finding example clues: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var answer string
+		var a api.AnswerClue
+		if err := rows.Scan(&answer, &a.Clue, &a.Solution, &a.Entry, &a.Crossword, &a.Number, &a.Setter, &a.Date, &a.Type); err != nil {
+			return nil, 0, fmt.Errorf("finding example clues: %w", err)
+		}
+		m := &matches[index[answer]]
+		m.Examples = append(m.Examples, a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("finding example clues: %w", err)
+	}
+	return matches, total, nil
+}
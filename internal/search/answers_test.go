@@ -0,0 +1,56 @@
+package search
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParsePattern(t *testing.T) {
+	for _, tt := range []struct {
+		pattern, enumeration string
+		want                 Pattern
+	}{
+		{"?a?b??e", "", Pattern{like: "_A_B__E", Length: 7}},
+		{".A._B_E", "", Pattern{like: "_A__B_E", Length: 7}},
+		{"?A? ?B?E", "", Pattern{like: "_A__B_E", Length: 7, Enumeration: "3,4"}},
+		{"?A?,?B?E", "", Pattern{like: "_A__B_E", Length: 7, Enumeration: "3,4"}},
+		{"A-?????", "", Pattern{like: "A_____", Length: 6, Enumeration: "1-5"}},
+		{"A-B C", "", Pattern{like: "ABC", Length: 3, Enumeration: "1-1,1"}},
+		{"  ?A?  ", "", Pattern{like: "_A_", Length: 3}},
+		{"?A??B?E", "3,4", Pattern{like: "_A__B_E", Length: 7, Enumeration: "3,4"}},
+		{"?A? ?B?E", "(3, 4)", Pattern{like: "_A__B_E", Length: 7, Enumeration: "3,4"}},
+		{"", "3,4", Pattern{like: "_______", Length: 7, Enumeration: "3,4"}},
+		{"", "1-5", Pattern{like: "______", Length: 6, Enumeration: "1-5"}},
+		{"é??", "", Pattern{like: "É__", Length: 3}},
+		{"R2D2", "", Pattern{like: "R2D2", Length: 4}},
+	} {
+		got, err := ParsePattern(tt.pattern, tt.enumeration)
+		if err != nil || got != tt.want {
+			t.Errorf("ParsePattern(%q, %q) = %+v, %v; want %+v", tt.pattern, tt.enumeration, got, err, tt.want)
+		}
+	}
+
+	for _, tt := range []struct {
+		pattern, enumeration, want string
+	}{
+		{"", "", "empty pattern"},
+		{"   ", "", "empty pattern"},
+		{" ?A?", "3,1", "3,1 is 4 letters but the pattern has 3"},
+		{"?A? ?B", "4", "4 is 4 letters but the pattern has 5"},
+		{"?A? ?B?E", "4,3", "does not match the pattern's (3,4)"},
+		{"?A?-?B?E", "3,4", "does not match the pattern's (3-4)"},
+		{"-ABC", "", "word break in the wrong place"},
+		{"ABC-", "", "word break in the wrong place"},
+		{"AB  C", "", "word break in the wrong place"},
+		{"A*C", "", `unexpected '*'`},
+		{"A%C", "", `unexpected '%'`},
+		{"", "3,,4", "invalid enumeration"},
+		{"", "three", "invalid enumeration"},
+		{"", "3,0", "empty word"},
+	} {
+		_, err := ParsePattern(tt.pattern, tt.enumeration)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("ParsePattern(%q, %q) = %v, want an error containing %q", tt.pattern, tt.enumeration, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,278 @@
+package search
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"html"
+	"strings"
+	"time"
+
+	"github.com/ThomasAdam/guardian-cc/api"
+)
+
+// maxPhrases bounds how many words and phrases a clue query may have.
+const maxPhrases = 10
+
+// snippetLen is roughly how many bytes of a long clue a snippet shows.
+const snippetLen = 160
+
+// ClueQuery is a clue search.  Query holds words, all of which must be in
+// the clue, and "quoted phrases", whose words must be in it in that order;
+// case and punctuation are ignored.  The other fields narrow the search
+// when set.
+type ClueQuery struct {
+	Query    string
+	Setter   string
+	Type     string
+	From, To time.Time // inclusive publication dates
+	Length   int       // letters in the answer
+	// Sort is "relevance" (the default), "newest" or "oldest".
+	Sort string
+}
+
+// clueSorts maps ClueQuery.Sort to an ORDER BY clause.
+var clueSorts = map[string]string{
+	"":          "score DESC, c.date DESC, c.id DESC, e.entry_id",
+	"relevance": "score DESC, c.date DESC, c.id DESC, e.entry_id",
+	"newest":    "c.date DESC, c.id DESC, e.entry_id",
+	"oldest":    "c.date, c.id, e.entry_id",
+}
+
+// QueryError reports a clue query that cannot be run as given.
+type QueryError struct{ msg string }
+
+func (e *QueryError) Error() string { return e.msg }
+
+func queryErrorf(format string, args ...any) error {
+	return &QueryError{fmt.Sprintf(format, args...)}
+}
+
+// parseQuery splits a query into phrases, each the terms of a quoted
+// phrase or of a single word.  A word such as "Macbeth's" that is more
+// than one term is a phrase.
+func parseQuery(q string) ([][]string, error) {
+	var phrases [][]string
+	add := func(text string) {
+		var terms []string
+		for _, t := range tokens(text) {
+			terms = append(terms, t.term)
+		}
+		if len(terms) > 0 {
+			phrases = append(phrases, terms)
+		}
+	}
+	for i, part := range strings.Split(q, `"`) {
+		if i%2 == 1 {
+			add(part)
+			continue
+		}
+		for _, w := range strings.Fields(part) {
+			add(w)
+		}
+	}
+	switch {
+	case len(phrases) == 0:
+		return nil, queryErrorf("empty query")
+	case len(phrases) > maxPhrases:
+		return nil, queryErrorf("too many words in query (at most %d)", maxPhrases)
+	}
+	return phrases, nil
+}
+
+// Clues returns a page of the clues matching q, and how many match in all.
+// Relevance is BM25 over the clue index, which the importer keeps (see
+// UpdateIndexes).  A query that cannot be run is reported as a
+// *QueryError, and an index that needs rebuilding as ErrStaleIndex.
+func Clues(ctx context.Context, db *sql.DB, q ClueQuery, offset, limit int) ([]api.ClueHit, int, error) {
+	phrases, err := parseQuery(q.Query)
+	if err != nil {
+		return nil, 0, err
+	}
+	order, ok := clueSorts[q.Sort]
+	if !ok {
+		return nil, 0, queryErrorf("unknown sort %q (want relevance, newest or oldest)", q.Sort)
+	}
+	if err := checkIndex(db, clueIndex); err != nil {
+		return nil, 0, err
+	}
+
+	// matched: the clues containing every phrase.  Each phrase joins one
+	// posting per term, at consecutive positions.
+	var matched []string
+	var matchArgs []any
+	seen := make(map[string]bool)
+	var terms []string
+	for _, ph := range phrases {
+		sel := "SELECT t0.crossword_id, t0.entry_id FROM clue_terms t0"
+		for i, t := range ph[1:] {
+			sel += fmt.Sprintf(` JOIN clue_terms t%[1]d ON t%[1]d.crossword_id = t0.crossword_id
+				AND t%[1]d.entry_id = t0.entry_id AND t%[1]d.pos = t0.pos + %[1]d AND t%[1]d.term = ?`, i+1)
+			matchArgs = append(matchArgs, t)
+		}
+		matched = append(matched, sel+" WHERE t0.term = ?")
+		matchArgs = append(matchArgs, ph[0])
+		for _, t := range ph {
+			if !seen[t] {
+				seen[t] = true
+				terms = append(terms, t)
+			}
+		}
+	}
+	inTerms := strings.TrimSuffix(strings.Repeat("?, ", len(terms)), ", ")
+	var termArgs []any
+	for _, t := range terms {
+		termArgs = append(termArgs, t)
+	}
+
+	var conds []string
+	var filterArgs []any
+	if q.Setter != "" {
+		conds = append(conds, "c.creator_name = ?")
+		filterArgs = append(filterArgs, q.Setter)
+	}
+	if q.Type != "" {
+		conds = append(conds, "c.crossword_type = ?")
+		filterArgs = append(filterArgs, q.Type)
+	}
+	if !q.From.IsZero() {
+		conds = append(conds, "c.date >= ?")
+		filterArgs = append(filterArgs, q.From)
+	}
+	if !q.To.IsZero() {
+		conds = append(conds, "c.date <= ?")
+		filterArgs = append(filterArgs, q.To)
+	}
+	if q.Length > 0 {
+		conds = append(conds, "e.length = ?")
+		filterArgs = append(filterArgs, q.Length)
+	}
+	where := ""
+	if len(conds) > 0 {
+		where = "WHERE " + strings.Join(conds, " AND ")
+	}
+
+	withMatched := "WITH matched AS (" + strings.Join(matched, " INTERSECT ") + ")"
+	const from = `FROM matched m
+		JOIN entries e ON e.crossword_id = m.crossword_id AND e.entry_id = m.entry_id
+		JOIN crosswords c ON c.id = e.crossword_id`
+
+	var total int
+	err = db.QueryRowContext(ctx, withMatched+" SELECT COUNT(*) "+from+" "+where,
+		append(append([]any{}, matchArgs...), filterArgs...)...).Scan(&total)
+	if err != nil {
+		return nil, 0, fmt.Errorf("counting clues: %w", err)
+	}
+
+	// BM25 with the usual k1 = 1.2 and b = 0.75, over the query's terms.
+	args := append([]any{}, matchArgs...)
+	args = append(args, termArgs...)
+	args = append(args, termArgs...)
+	args = append(args, filterArgs...)
+	rows, err := db.QueryContext(ctx, withMatched+`,
+		stats AS (SELECT COUNT(*) AS n, AVG(length) AS avgdl FROM clue_docs),
+		df AS (
+			SELECT term, COUNT(DISTINCT (crossword_id, entry_id)) AS df
+			FROM clue_terms WHERE term IN (`+inTerms+`) GROUP BY term
+		),
+		tf AS (
+			SELECT t.crossword_id, t.entry_id, t.term, COUNT(*) AS tf
+			FROM clue_terms t JOIN matched m ON t.crossword_id = m.crossword_id AND t.entry_id = m.entry_id
+			WHERE t.term IN (`+inTerms+`)
+			GROUP BY ALL
+		),
+		scored AS (
+			SELECT tf.crossword_id, tf.entry_id,
+			       SUM(ln(1 + (s.n - df.df + 0.5) / (df.df + 0.5)) * tf.tf * 2.2 /
+			           (tf.tf + 1.2 * (0.25 + 0.75 * d.length / s.avgdl))) AS score
+			FROM tf
+			JOIN df ON df.term = tf.term
+			JOIN clue_docs d ON d.crossword_id = tf.crossword_id AND d.entry_id = tf.entry_id
+			CROSS JOIN stats s
+			GROUP BY ALL
+		)
+		SELECT COALESCE(e.clue, ''), COALESCE(e.solution, ''),
+		       COALESCE(e.human_number, '') || ' ' || e.direction,
+		       c.id, COALESCE(c.number, ''), COALESCE(c.creator_name, ''),
+		       COALESCE(CAST(c.date AS VARCHAR), ''), COALESCE(c.crossword_type, ''), sc.score
+		FROM scored sc
+		JOIN entries e ON e.crossword_id = sc.crossword_id AND e.entry_id = sc.entry_id
+		JOIN crosswords c ON c.id = e.crossword_id
+		`+where+`
+		ORDER BY `+order+`
+		LIMIT ? OFFSET ?`, append(args, limit, offset)...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("searching clues: %w", err)
+	}
+	defer rows.Close()
+	hits := make([]api.ClueHit, 0)
+	for rows.Next() {
+		var h api.ClueHit
+		if err := rows.Scan(&h.Clue, &h.Solution, &h.Entry, &h.Crossword, &h.Number, &h.Setter, &h.Date, &h.Type, &h.Score); err != nil {
+			return nil, 0, fmt.Errorf("searching clues: %w", err)
+		}
+		h.Clue = clueText(h.Clue)
+		h.Highlights = highlights(h.Clue, seen)
+		h.Snippet = snippet(h.Clue, h.Highlights)
+		h.URL = api.CrosswordURL(h.Crossword)
+		hits = append(hits, h)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("searching clues: %w", err)
+	}
+	return hits, total, nil
+}
+
+// highlights returns the byte ranges of text's terms that are in terms.
+func highlights(text string, terms map[string]bool) [][2]int {
+	spans := make([][2]int, 0)
+	for _, t := range tokens(text) {
+		if terms[t.term] {
+			spans = append(spans, [2]int{t.start, t.end})
+		}
+	}
+	return spans
+}
+
+// snippet renders text as HTML with the spans in <mark>.  Text longer than
+// snippetLen is cut to about that much around the first span, at spaces.
+func snippet(text string, spans [][2]int) string {
+	start, end := 0, len(text)
+	if len(text) > snippetLen {
+		if len(spans) > 0 {
+			start = max(0, spans[0][0]-snippetLen/3)
+		}
+		end = min(len(text), start+snippetLen)
+		if start > 0 {
+			if i := strings.IndexByte(text[start:], ' '); i >= 0 && start+i < spans[0][0] {
+				start += i + 1
+			}
+		}
+		if end < len(text) {
+			if i := strings.LastIndexByte(text[start:end], ' '); i > 0 {
+				end = start + i
+			}
+		}
+	}
+
+	var b strings.Builder
+	if start > 0 {
+		b.WriteString("…")
+	}
+	pos := start
+	for _, s := range spans {
+		if s[0] < start || s[1] > end {
+			continue
+		}
+		b.WriteString(html.EscapeString(text[pos:s[0]]))
+		b.WriteString("<mark>")
+		b.WriteString(html.EscapeString(text[s[0]:s[1]]))
+		b.WriteString("</mark>")
+		pos = s[1]
+	}
+	b.WriteString(html.EscapeString(text[pos:end]))
+	if end < len(text) {
+		b.WriteString("…")
+	}
+	return b.String()
+}
@@ -0,0 +1,252 @@
+package search
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"html"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode"
+
+	gccdb "github.com/ThomasAdam/guardian-cc/internal/db"
+	"github.com/marcboeker/go-duckdb"
+)
+
+var (
+	tagRE        = regexp.MustCompile(`<[^>]*>`)
+	spaceRE      = regexp.MustCompile(`\s+`)
+	enumSuffixRE = regexp.MustCompile(`\s*\([0-9][0-9,\- ]*\)\s*$`)
+	crossRefClue = regexp.MustCompile(`^See\s+\d+`)
+)
+
+// ErrStaleIndex is returned by searches when the index they use is missing
+// or was built by a version of guardian-cc that built it differently.
+var ErrStaleIndex = errors.New("the search indexes are missing or out of date; run guardian-cc import to rebuild them")
+
+// clueText returns a clue as plain text: HTML tags are dropped, entities
+// decoded and runs of whitespace collapsed.
+func clueText(clue string) string {
+	s := html.UnescapeString(tagRE.ReplaceAllString(clue, ""))
+	return strings.TrimSpace(spaceRE.ReplaceAllString(s, " "))
+}
+
+// token is a term and where it was found in a text, as byte offsets.
+type token struct {
+	term       string
+	start, end int
+}
+
+// tokens splits text into terms: runs of letters and digits, lower-cased.
+// Everything else, apostrophes included, separates terms, so "Macbeth's"
+// is "macbeth" followed by "s".
+func tokens(text string) []token {
+	var toks []token
+	start := -1
+	for i, r := range text {
+		word := unicode.IsLetter(r) || unicode.IsDigit(r)
+		switch {
+		case word && start < 0:
+			start = i
+		case !word && start >= 0:
+			toks = append(toks, token{strings.ToLower(text[start:i]), start, i})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		toks = append(toks, token{strings.ToLower(text[start:]), start, len(text)})
+	}
+	return toks
+}
+
+// indexTerms returns the terms of a clue as indexed: its plain text
+// without the enumeration.  Cross-references ("See 5") are not indexed.
+func indexTerms(clue string) []string {
+	if crossRefClue.MatchString(clue) {
+		return nil
+	}
+	toks := tokens(enumSuffixRE.ReplaceAllString(clueText(clue), ""))
+	terms := make([]string, len(toks))
+	for i, t := range toks {
+		terms[i] = t.term
+	}
+	return terms
+}
+
+// entry is a light, as the indexes are built from it.
+type entry struct {
+	crosswordID, entryID string
+	number               int
+	humanNumber          string
+	direction            string
+	clue                 string
+	solution             string
+	length               int
+}
+
+// derivedIndex is a set of tables derived from the entries table, which
+// the importer keeps up to date (see update).
+type derivedIndex struct {
+	// name is the meta key recording the version the index was built
+	// at, and its index_name in indexed_crosswords.  Bump version
+	// whenever the rows built change, and the next import rebuilds the
+	// index.
+	name    string
+	version int64
+	// tables are cleared when the index is rebuilt.  Each has a
+	// crossword_id column.
+	tables []string
+	// rows returns the rows for each of tables from one crossword's
+	// entries, in the tables' column order.
+	rows func(entries []entry) [][][]driver.Value
+}
+
+// clueIndex holds each clue's terms, by position, for full-text search.
+var clueIndex = derivedIndex{
+	name:    "clue_index",
+	version: 2,
+	tables:  []string{"clue_docs", "clue_terms"},
+	rows: func(entries []entry) [][][]driver.Value {
+		var docs, terms [][]driver.Value
+		for _, e := range entries {
+			ts := indexTerms(e.clue)
+			if len(ts) == 0 {
+				continue
+			}
+			docs = append(docs, []driver.Value{e.crosswordID, e.entryID, int32(len(ts))})
+			for pos, t := range ts {
+				terms = append(terms, []driver.Value{t, e.crosswordID, e.entryID, int32(pos)})
+			}
+		}
+		return [][][]driver.Value{docs, terms}
+	},
+}
+
+// UpdateIndexes brings the clue and anagram indexes up to date and
+// returns how many clues and answers it added to them.  Every crossword
+// not yet indexed is added, after clearing an index that was built
+// differently.  The importer calls it after adding crosswords, so an
+// interrupted import is caught up by the next.
+func UpdateIndexes(ctx context.Context, db *sql.DB) (clues, answers int, err error) {
+	if clues, err = update(ctx, db, clueIndex); err != nil {
+		return 0, 0, err
+	}
+	answers, err = update(ctx, db, anagramIndex)
+	return clues, answers, err
+}
+
+// update adds the crosswords missing from idx to it, rebuilding it if it
+// is out of date, and returns how many rows it added to idx.tables[0].
+func update(ctx context.Context, db *sql.DB, idx derivedIndex) (int, error) {
+	v, err := gccdb.IndexVersion(db, idx.name)
+	if err != nil {
+		return 0, err
+	}
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	if v != idx.version {
+		for _, table := range idx.tables {
+			if _, err := tx.ExecContext(ctx, "DELETE FROM "+table); err != nil {
+				return 0, fmt.Errorf("clearing %s: %w", idx.name, err)
+			}
+		}
+		if _, err := tx.ExecContext(ctx, `DELETE FROM indexed_crosswords WHERE index_name = ?`, idx.name); err != nil {
+			return 0, fmt.Errorf("clearing %s: %w", idx.name, err)
+		}
+	}
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT crossword_id, entry_id, COALESCE(number, 0), COALESCE(human_number, ''),
+		       COALESCE(direction, ''), COALESCE(clue, ''), COALESCE(solution, ''), COALESCE(length, 0)
+		FROM entries
+		WHERE crossword_id NOT IN (SELECT crossword_id FROM indexed_crosswords WHERE index_name = ?)
+		ORDER BY crossword_id`, idx.name)
+	if err != nil {
+		return 0, fmt.Errorf("reading entries: %w", err)
+	}
+	defer rows.Close()
+	// The crosswords read are recorded as indexed whether or not they
+	// give idx any rows.
+	out := make([][][]driver.Value, len(idx.tables))
+	var indexed [][]driver.Value
+	var crossword []entry
+	flush := func() {
+		if len(crossword) == 0 {
+			return
+		}
+		for i, r := range idx.rows(crossword) {
+			out[i] = append(out[i], r...)
+		}
+		indexed = append(indexed, []driver.Value{idx.name, crossword[0].crosswordID})
+		crossword = crossword[:0]
+	}
+	for rows.Next() {
+		var e entry
+		if err := rows.Scan(&e.crosswordID, &e.entryID, &e.number, &e.humanNumber,
+			&e.direction, &e.clue, &e.solution, &e.length); err != nil {
+			return 0, fmt.Errorf("reading entries: %w", err)
+		}
+		if len(crossword) > 0 && crossword[0].crosswordID != e.crosswordID {
+			flush()
+		}
+		crossword = append(crossword, e)
+	}
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("reading entries: %w", err)
+	}
+	rows.Close()
+	flush()
+
+	// The appender writes through the same connection, so as part of tx.
+	tables := append(slices.Clone(idx.tables), "indexed_crosswords")
+	out = append(out, indexed)
+	err = conn.Raw(func(dc any) error {
+		for i, table := range tables {
+			a, err := duckdb.NewAppenderFromConn(dc.(driver.Conn), "", table)
+			if err != nil {
+				return err
+			}
+			for _, r := range out[i] {
+				if err := a.AppendRow(r...); err != nil {
+					a.Close()
+					return err
+				}
+			}
+			if err := a.Close(); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("building %s: %w", idx.name, err)
+	}
+	if err := gccdb.SetIndexVersion(tx, idx.name, idx.version); err != nil {
+		return 0, err
+	}
+	return len(out[0]), tx.Commit()
+}
+
+// checkIndex returns ErrStaleIndex if idx needs rebuilding.
+func checkIndex(db *sql.DB, idx derivedIndex) error {
+	v, err := gccdb.IndexVersion(db, idx.name)
+	if err != nil {
+		return err
+	}
+	if v != idx.version {
+		return ErrStaleIndex
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package search
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	gccdb "github.com/ThomasAdam/guardian-cc/internal/db"
+)
+
+// testDB returns an empty database with the schema created.
+func testDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := gccdb.Open(filepath.Join(t.TempDir(), "test.duckdb"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := gccdb.CreateSchema(db); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestUpdateIndexes(t *testing.T) {
+	db := testDB(t)
+	for _, q := range []string{
+		`INSERT INTO crosswords (id) VALUES ('cryptic/1'), ('cryptic/2')`,
+		// cryptic/2 gives neither index a row: its only clue is a
+		// cross-reference.
+		`INSERT INTO entries (crossword_id, entry_id, human_number, direction, clue, solution) VALUES
+			('cryptic/1', '1-across', '1', 'across', 'Flower of the river (5)', 'TIBER'),
+			('cryptic/1', '2-down', '2', 'down', 'Stream, say (5)', 'RIVER'),
+			('cryptic/2', '1-across', '1', 'across', 'See 2', '')`,
+	} {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i, want := range []int{2, 0} {
+		clues, answers, err := UpdateIndexes(context.Background(), db)
+		if err != nil || clues != want || answers != want {
+			t.Errorf("update %d = %d clues, %d answers, %v; want %d, %d", i+1, clues, answers, err, want, want)
+		}
+	}
+	var n int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM indexed_crosswords`).Scan(&n); err != nil || n != 4 {
+		t.Errorf("indexed_crosswords has %d rows, %v; want 4", n, err)
+	}
+
+	// A change of version rebuilds the index from every crossword.
+	if _, err := db.Exec(`UPDATE meta SET value = 0 WHERE key = ?`, clueIndex.name); err != nil {
+		t.Fatal(err)
+	}
+	if clues, answers, err := UpdateIndexes(context.Background(), db); err != nil || clues != 2 || answers != 0 {
+		t.Errorf("rebuild = %d clues, %d answers, %v; want 2, 0", clues, answers, err)
+	}
+	if err := db.QueryRow(`SELECT COUNT(*) FROM clue_docs`).Scan(&n); err != nil || n != 2 {
+		t.Errorf("clue_docs has %d rows after the rebuild, %v; want 2", n, err)
+	}
+}
@@ -0,0 +1,89 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+
+	"github.com/ThomasAdam/guardian-cc/api"
+	"github.com/ThomasAdam/guardian-cc/internal/importer"
+)
+
+// maxUploadBytes bounds the body of a crossword upload.
+const maxUploadBytes = 32 << 20
+
+// importMu serializes imports run by the server.
+var importMu sync.Mutex
+
+// handleUpload imports the crosswords POSTed to /api/admin/crosswords: a
+// crossword JSON document, an array of them, or several one after the
+// other.  They are written into the archive under the current directory
+// and imported (see importer.ImportUploads).
+func handleUpload(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	fail := func(status int, format string, args ...any) {
+		writeJSON(w, status, api.Error{Error: fmt.Sprintf(format, args...)})
+	}
+
+	var docs []json.RawMessage
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxUploadBytes))
+	for {
+		var doc json.RawMessage
+		err := dec.Decode(&doc)
+		if err == io.EOF {
+			break
+		}
+		var mbe *http.MaxBytesError
+		if errors.As(err, &mbe) {
+			fail(http.StatusRequestEntityTooLarge, "upload is larger than %d bytes", mbe.Limit)
+			return
+		}
+		if err != nil {
+			fail(http.StatusBadRequest, "invalid JSON: %v", err)
+			return
+		}
+		if len(doc) > 0 && doc[0] == '[' {
+			var list []json.RawMessage
+			if err := json.Unmarshal(doc, &list); err != nil {
+				fail(http.StatusBadRequest, "invalid JSON: %v", err)
+				return
+			}
+			docs = append(docs, list...)
+			continue
+		}
+		docs = append(docs, doc)
+	}
+	if len(docs) == 0 {
+		fail(http.StatusBadRequest, "no crosswords uploaded")
+		return
+	}
+
+	importMu.Lock()
+	defer importMu.Unlock()
+	uploads, res, err := importer.ImportUploads(db, ".", docs, os.Stdout)
+	var ie *importer.InvalidError
+	switch {
+	case errors.Is(err, importer.ErrConflict):
+		fail(http.StatusConflict, "%v", err)
+		return
+	case errors.As(err, &ie):
+		fail(http.StatusBadRequest, "%v", err)
+		return
+	case err != nil:
+		log.Printf("error importing uploads: %v", err)
+		fail(http.StatusInternalServerError, "import failed")
+		return
+	}
+	log.Printf("imported %d uploaded crosswords from %s: %d added, %d failed", res.Files, who(r), res.Added, res.Failed)
+
+	resp := api.ImportResult{Added: res.Added, Failed: res.Failed, Crosswords: make([]api.ImportedCrossword, len(uploads))}
+	for i, u := range uploads {
+		resp.Crosswords[i] = api.ImportedCrossword{ID: u.ID, Path: u.Path, Added: u.Added}
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,406 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/ThomasAdam/guardian-cc/api"
+	"github.com/ThomasAdam/guardian-cc/internal/auth"
+)
+
+// Paging for the JSON API's list endpoints: ?offset=N&limit=M.
+const (
+	defaultPageLimit = 50
+	maxPageLimit     = 1000
+)
+
+// apiQueryTimeout bounds the queries behind a single JSON API request.
+const apiQueryTimeout = 10 * time.Second
+
+// crosswordColumns selects a crossword row in the order scanCrossword reads it.
+const crosswordColumns = `c.id, COALESCE(c.number, ''), COALESCE(c.name, ''),
+	COALESCE(c.creator_name, ''), COALESCE(c.creator_weburl, ''),
+	COALESCE(CAST(c.date AS VARCHAR), ''), COALESCE(c.crossword_type, ''),
+	COALESCE(c.pdf, '')`
+
+func scanCrossword(s interface{ Scan(...any) error }) (api.Crossword, error) {
+	var c api.Crossword
+	err := s.Scan(&c.ID, &c.Number, &c.Name, &c.Setter, &c.SetterURL, &c.Date, &c.Type, &c.PDF)
+	return c, err
+}
+
+// registerAPI adds the JSON API's routes to mux.  If tokens is set and
+// makes the API private, they need a reader's token, as do /api/dt, chart
+// data and /metrics.
+func registerAPI(mux *http.ServeMux, db *sql.DB, tokens *auth.File) {
+	handle := func(pattern string, h func(context.Context, *sql.DB, *http.Request) (any, error)) {
+		var handler http.Handler = withGeneration(db, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx, cancel := context.WithTimeout(r.Context(), apiQueryTimeout)
+			defer cancel()
+			start := time.Now()
+			v, err := h(ctx, db, r)
+			queryDuration.Since(start, pattern)
+			if err != nil {
+				var ae *apiError
+				if !errors.As(err, &ae) {
+					log.Printf("error serving %s: %v", r.URL.Path, err)
+					ae = &apiError{http.StatusInternalServerError, "query error"}
+				}
+				writeJSON(w, ae.status, api.Error{Error: ae.msg})
+				return
+			}
+			writeJSON(w, http.StatusOK, v)
+		}))
+		mux.Handle(pattern, requireReaderIfPrivate(tokens, handler))
+	}
+	handle("GET /api/setters", listSetters)
+	handle("GET /api/setters/{name}", getSetter)
+	handle("GET /api/crosswords", listCrosswords)
+	handle("GET /api/crosswords/{id...}", getCrossword)
+	handle("GET /api/answers/{solution}", listAnswerClues)
+	handle("GET /api/search/answers", searchAnswers)
+	handle("GET /api/search/anagrams", searchAnagrams)
+	handle("GET /api/search/clues", searchClues)
+}
+
+// apiError is an error reported to the client with the given status;
+// any other error from a handler is logged and reported as a 500.
+type apiError struct {
+	status int
+	msg    string
+}
+
+func (e *apiError) Error() string { return e.msg }
+
+func badRequest(format string, args ...any) error {
+	return &apiError{http.StatusBadRequest, fmt.Sprintf(format, args...)}
+}
+
+func notFound(what string) error {
+	return &apiError{http.StatusNotFound, what + " not found"}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("error writing response: %v", err)
+	}
+}
+
+// parsePage reads the offset and limit parameters.
+func parsePage(q url.Values) (api.Page, error) {
+	pg := api.Page{Limit: defaultPageLimit}
+	for _, p := range []struct {
+		name string
+		dst  *int
+	}{{"offset", &pg.Offset}, {"limit", &pg.Limit}} {
+		v := q.Get(p.name)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return pg, badRequest("invalid %s %q", p.name, v)
+		}
+		*p.dst = n
+	}
+	if pg.Limit < 1 || pg.Limit > maxPageLimit {
+		return pg, badRequest("limit must be between 1 and %d", maxPageLimit)
+	}
+	return pg, nil
+}
+
+// listPage runs a paged query: countSQL and dataSQL take the same args, and
+// dataSQL ends in "LIMIT ? OFFSET ?".  Each row is passed to scan.
+func listPage[T any](ctx context.Context, db *sql.DB, r *http.Request, countSQL, dataSQL string, args []any, scan func(*sql.Rows) (T, error)) (*api.List[T], error) {
+	pg, err := parsePage(r.URL.Query())
+	if err != nil {
+		return nil, err
+	}
+	if err := db.QueryRowContext(ctx, countSQL, args...).Scan(&pg.Total); err != nil {
+		return nil, err
+	}
+
+	rows, err := db.QueryContext(ctx, dataSQL, append(args, pg.Limit, pg.Offset)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := make([]T, 0)
+	for rows.Next() {
+		item, err := scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return pageOf(r, items, pg), nil
+}
+
+// pageOf wraps items, the page of r's results described by pg, in a List
+// with a link to the next page if there is one.
+func pageOf[T any](r *http.Request, items []T, pg api.Page) *api.List[T] {
+	if pg.Offset+pg.Limit < pg.Total {
+		q := r.URL.Query()
+		q.Set("offset", strconv.Itoa(pg.Offset+pg.Limit))
+		q.Set("limit", strconv.Itoa(pg.Limit))
+		next := *r.URL
+		next.RawQuery = q.Encode()
+		pg.Next = next.RequestURI()
+	}
+	return &api.List[T]{Data: items, Page: pg}
+}
+
+// listSetters serves /api/setters: every setter, by name.
+func listSetters(ctx context.Context, db *sql.DB, r *http.Request) (any, error) {
+	return listPage(ctx, db, r,
+		`SELECT COUNT(DISTINCT creator_name) FROM crosswords`,
+		`SELECT creator_name, COUNT(*), CAST(MIN(date) AS VARCHAR), CAST(MAX(date) AS VARCHAR)
+		 FROM crosswords
+		 WHERE creator_name IS NOT NULL
+		 GROUP BY creator_name
+		 ORDER BY creator_name
+		 LIMIT ? OFFSET ?`,
+		nil,
+		func(rows *sql.Rows) (api.Setter, error) {
+			var s api.Setter
+			err := rows.Scan(&s.Name, &s.Crosswords, &s.First, &s.Last)
+			return s, err
+		})
+}
+
+// getSetter serves /api/setters/{name}: a setter's totals, crosswords per
+// type and per year, and longest streaks.
+func getSetter(ctx context.Context, db *sql.DB, r *http.Request) (any, error) {
+	name := r.PathValue("name")
+	s := api.SetterDetail{
+		Setter: api.Setter{Name: name},
+		Types:  make(map[string]int),
+		Years:  make([]api.YearCount, 0),
+	}
+
+	err := db.QueryRowContext(ctx, `
+		SELECT COUNT(*), CAST(MIN(date) AS VARCHAR), CAST(MAX(date) AS VARCHAR)
+		FROM crosswords WHERE creator_name = ?
+		HAVING COUNT(*) > 0`, name).Scan(&s.Crosswords, &s.First, &s.Last)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, notFound("setter")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT crossword_type, COUNT(*) FROM crosswords
+		WHERE creator_name = ? GROUP BY crossword_type`, name)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var typ string
+		var n int
+		if err := rows.Scan(&typ, &n); err != nil {
+			return nil, err
+		}
+		s.Types[typ] = n
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = db.QueryContext(ctx, `
+		SELECT CAST(EXTRACT(YEAR FROM date) AS INTEGER) AS yr, COUNT(*)
+		FROM crosswords WHERE creator_name = ?
+		GROUP BY yr ORDER BY yr`, name)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var y api.YearCount
+		if err := rows.Scan(&y.Year, &y.Crosswords); err != nil {
+			return nil, err
+		}
+		s.Years = append(s.Years, y)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if s.Streaks.Weeks, err = longestStreak(ctx, db, name, "week", func(t time.Time) time.Time {
+		return t.AddDate(0, 0, 7)
+	}); err != nil {
+		return nil, err
+	}
+	if s.Streaks.Months, err = longestStreak(ctx, db, name, "month", func(t time.Time) time.Time {
+		return t.AddDate(0, 1, 0)
+	}); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// longestStreak returns the setter's longest run of consecutive periods
+// (date_trunc units) with a crossword in each; next gives the start of the
+// period after the one starting at t.  The earliest run wins a tie.
+func longestStreak(ctx context.Context, db *sql.DB, setter, unit string, next func(time.Time) time.Time) (*api.Streak, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT CAST(date_trunc('`+unit+`', date) AS DATE) AS period,
+		       CAST(MIN(date) AS VARCHAR), CAST(MAX(date) AS VARCHAR), COUNT(*)
+		FROM crosswords WHERE creator_name = ?
+		GROUP BY period ORDER BY period`, setter)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var best, cur *api.Streak
+	var last time.Time
+	for rows.Next() {
+		var period time.Time
+		var first, end string
+		var n int
+		if err := rows.Scan(&period, &first, &end, &n); err != nil {
+			return nil, err
+		}
+		if cur == nil || !next(last).Equal(period) {
+			cur = &api.Streak{Start: first}
+		}
+		cur.Length++
+		cur.End = end
+		cur.Crosswords += n
+		last = period
+		if best == nil || cur.Length > best.Length {
+			s := *cur
+			best = &s
+		}
+	}
+	return best, rows.Err()
+}
+
+// listCrosswords serves /api/crosswords, optionally filtered by setter,
+// type and an inclusive from/to date range, oldest first.
+func listCrosswords(ctx context.Context, db *sql.DB, r *http.Request) (any, error) {
+	q := r.URL.Query()
+	var conds []string
+	var args []any
+	for _, f := range []struct{ param, column string }{
+		{"setter", "c.creator_name"},
+		{"type", "c.crossword_type"},
+	} {
+		if v := q.Get(f.param); v != "" {
+			conds = append(conds, f.column+" = ?")
+			args = append(args, v)
+		}
+	}
+	for _, f := range []struct{ param, op string }{{"from", ">="}, {"to", "<="}} {
+		v := q.Get(f.param)
+		if v == "" {
+			continue
+		}
+		d, err := time.Parse(time.DateOnly, v)
+		if err != nil {
+			return nil, badRequest("invalid %s date %q (want YYYY-MM-DD)", f.param, v)
+		}
+		conds = append(conds, "c.date "+f.op+" ?")
+		args = append(args, d)
+	}
+	where := ""
+	if len(conds) > 0 {
+		where = "WHERE " + strings.Join(conds, " AND ")
+	}
+
+	return listPage(ctx, db, r,
+		`SELECT COUNT(*) FROM crosswords c `+where,
+		`SELECT `+crosswordColumns+` FROM crosswords c `+where+`
+		 ORDER BY c.date, c.id LIMIT ? OFFSET ?`,
+		args,
+		func(rows *sql.Rows) (api.Crossword, error) {
+			return scanCrossword(rows)
+		})
+}
+
+// getCrossword serves /api/crosswords/{id}: a crossword and its entries,
+// with clues as published.
+func getCrossword(ctx context.Context, db *sql.DB, r *http.Request) (any, error) {
+	id := r.PathValue("id")
+	c, err := scanCrossword(db.QueryRowContext(ctx,
+		`SELECT `+crosswordColumns+` FROM crosswords c WHERE c.id = ?`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, notFound("crossword")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT entry_id, number, COALESCE(human_number, ''), COALESCE(clue, ''),
+		       direction, length, COALESCE(solution, ''), pos_x, pos_y
+		FROM entries WHERE crossword_id = ?
+		ORDER BY direction, number, entry_id`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	cd := api.CrosswordDetail{Crossword: c, Entries: make([]api.Entry, 0)}
+	for rows.Next() {
+		var e api.Entry
+		if err := rows.Scan(&e.ID, &e.Number, &e.HumanNumber, &e.Clue, &e.Direction, &e.Length, &e.Solution, &e.X, &e.Y); err != nil {
+			return nil, err
+		}
+		cd.Entries = append(cd.Entries, e)
+	}
+	return cd, rows.Err()
+}
+
+// listAnswerClues serves /api/answers/{solution}: every clue given for an
+// answer, oldest first.  The answer is matched ignoring case, spaces and
+// punctuation, so "out of hand" finds OUTOFHAND.
+func listAnswerClues(ctx context.Context, db *sql.DB, r *http.Request) (any, error) {
+	solution := normaliseAnswer(r.PathValue("solution"))
+	if solution == "" {
+		return nil, badRequest("empty answer")
+	}
+	const from = `FROM resolved_entries e JOIN crosswords c ON e.crossword_id = c.id
+		WHERE upper(e.solution) = ?`
+	return listPage(ctx, db, r,
+		`SELECT COUNT(*) `+from,
+		`SELECT COALESCE(e.clue, ''), e.solution,
+		        COALESCE(e.human_number, '') || ' ' || e.direction,
+		        c.id, COALESCE(c.number, ''), COALESCE(c.creator_name, ''),
+		        COALESCE(CAST(c.date AS VARCHAR), ''), COALESCE(c.crossword_type, '')
+		 `+from+`
+		 ORDER BY c.date, c.id, e.entry_id LIMIT ? OFFSET ?`,
+		[]any{solution},
+		func(rows *sql.Rows) (api.AnswerClue, error) {
+			var a api.AnswerClue
+			err := rows.Scan(&a.Clue, &a.Solution, &a.Entry, &a.Crossword, &a.Number, &a.Setter, &a.Date, &a.Type)
+			return a, err
+		})
+}
+
+// normaliseAnswer upper-cases s and drops everything but letters and digits.
+func normaliseAnswer(s string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToUpper(r)
+		}
+		return -1
+	}, s)
+}
@@ -0,0 +1,81 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ThomasAdam/guardian-cc/api"
+)
+
+func TestListSetters(t *testing.T) {
+	db := testDB(t)
+	// The importer always sets a creator, but an older DB may have
+	// crosswords without one.
+	if _, err := db.Exec(`INSERT INTO crosswords (id, number, name, creator_name, creator_weburl, date, crossword_type) VALUES
+		('crosswords/cryptic/1', '1', 'Cryptic 1', 'Araucaria', '', '2020-01-01', 'cryptic'),
+		('crosswords/cryptic/2', '2', 'Cryptic 2', 'Araucaria', '', '2020-01-08', 'cryptic'),
+		('crosswords/cryptic/3', '3', 'Cryptic 3', 'Paul', '', '2020-01-15', 'cryptic'),
+		('crosswords/cryptic/4', '4', 'Cryptic 4', NULL, '', '2020-01-22', 'cryptic')`); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, query := range []string{"", "?limit=1", "?limit=1&offset=1"} {
+		v, err := listSetters(context.Background(), db, httptest.NewRequest("GET", "/api/setters"+query, nil))
+		if err != nil {
+			t.Fatalf("listSetters(%q): %v", query, err)
+		}
+		list := v.(*api.List[api.Setter])
+		if list.Page.Total != 2 {
+			t.Errorf("listSetters(%q) total = %d, want 2", query, list.Page.Total)
+		}
+		if list.Page.Offset+len(list.Data) > list.Page.Total || (list.Page.Next == "") != (list.Page.Offset+len(list.Data) == list.Page.Total) {
+			t.Errorf("listSetters(%q) = %d setters from %d of %d, next %q", query, len(list.Data), list.Page.Offset, list.Page.Total, list.Page.Next)
+		}
+	}
+}
+
+func TestListNext(t *testing.T) {
+	db := testDB(t)
+	for _, q := range []string{
+		`INSERT INTO crosswords (id, number, creator_name, date, crossword_type) VALUES
+			('crosswords/cryptic/1', '1', 'Araucaria', '2020-01-01', 'cryptic'),
+			('crosswords/cryptic/2', '2', 'Paul', '2020-01-08', 'cryptic')`,
+		`INSERT INTO entries (crossword_id, entry_id, human_number, direction, clue, solution) VALUES
+			('crosswords/cryptic/1', '1-across', '1', 'across', 'Wild, being out of the fist? (3,2,4)', 'OUTOFHAND'),
+			('crosswords/cryptic/1', '2-down', '2', 'down', 'Uncontrolled (3,2,4)', 'OUTOFHAND'),
+			('crosswords/cryptic/2', '5-across', '5', 'across', 'At once, unruly (3,2,4)', 'OUTOFHAND')`,
+	} {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mux := http.NewServeMux()
+	registerAPI(mux, db, nil)
+
+	// The answer's spaces and hash must be escaped in each link for the
+	// next page to be the same answer's.
+	var clues []api.AnswerClue
+	for next := "/api/answers/out%20of%20hand%23?limit=1"; next != ""; {
+		r, err := http.NewRequest("GET", next, nil)
+		if err != nil {
+			t.Fatalf("next page %q: %v", next, err)
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+		var list api.List[api.AnswerClue]
+		if err := json.Unmarshal(w.Body.Bytes(), &list); w.Code != http.StatusOK || err != nil {
+			t.Fatalf("GET %s = %d %q", next, w.Code, w.Body)
+		}
+		if list.Page.Offset != len(clues) || list.Page.Total != 3 {
+			t.Fatalf("GET %s = clues from %d of %d, want from %d of 3", next, list.Page.Offset, list.Page.Total, len(clues))
+		}
+		clues = append(clues, list.Data...)
+		next = list.Page.Next
+	}
+	if len(clues) != 3 {
+		t.Errorf("paged through %d clues, want 3", len(clues))
+	}
+}
@@ -0,0 +1,67 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/ThomasAdam/guardian-cc/api"
+	"github.com/ThomasAdam/guardian-cc/internal/auth"
+)
+
+// tokenKey is the context key under which requireRole stores the token a
+// request was made with.
+type tokenKey struct{}
+
+// requireRole lets through only requests carrying, as a bearer token in
+// their Authorization header, one of tokens with at least the given role.
+// A missing or unknown token gets a 401, one whose role is not enough a
+// 403.
+func requireRole(tokens *auth.File, role auth.Role, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		secret, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		var tok auth.Token
+		if ok {
+			tok, ok = tokens.Config().Lookup(secret)
+		}
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="guardian-cc"`)
+			writeJSON(w, http.StatusUnauthorized, api.Error{Error: "missing or invalid token"})
+			return
+		}
+		if tok.Role < role {
+			writeJSON(w, http.StatusForbidden, api.Error{
+				Error: fmt.Sprintf("token %q (%s) may not do this; it needs the %s role", tok.Name, tok.Role, role),
+			})
+			return
+		}
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), tokenKey{}, tok)))
+	})
+}
+
+// requireReaderIfPrivate lets every request through unless there is a
+// tokens file and it makes the API private, when it needs a reader's
+// token.
+func requireReaderIfPrivate(tokens *auth.File, h http.Handler) http.Handler {
+	if tokens == nil {
+		return h
+	}
+	private := requireRole(tokens, auth.Reader, h)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if tokens.Config().Private {
+			private.ServeHTTP(w, r)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// who describes the client making r for the log: the name of its token,
+// if it has one, and its address.
+func who(r *http.Request) string {
+	if tok, ok := r.Context().Value(tokenKey{}).(auth.Token); ok {
+		return fmt.Sprintf("%s (%s)", tok.Name, r.RemoteAddr)
+	}
+	return r.RemoteAddr
+}
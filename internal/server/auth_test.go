@@ -0,0 +1,97 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ThomasAdam/guardian-cc/internal/auth"
+)
+
+// testTokens returns a tokens file with a token for each role, named
+// after it, and the tokens themselves by role.
+func testTokens(t *testing.T, private bool) (*auth.File, map[auth.Role]string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "tokens.json")
+	c := auth.Config{Private: private}
+	secrets := map[auth.Role]string{}
+	for _, role := range []auth.Role{auth.Reader, auth.Analyst, auth.Admin} {
+		secret, err := c.Create(role.String(), role)
+		if err != nil {
+			t.Fatal(err)
+		}
+		secrets[role] = secret
+	}
+	if err := c.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	f, err := auth.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f, secrets
+}
+
+// get serves a GET of / by h, with the given Authorization header.
+func get(h http.Handler, authorization string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("GET", "/", nil)
+	if authorization != "" {
+		r.Header.Set("Authorization", authorization)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+// whoHandler is a handler that answers with who made the request.
+var whoHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte(who(r)))
+})
+
+func TestRequireRole(t *testing.T) {
+	tokens, secrets := testTokens(t, false)
+	h := requireRole(tokens, auth.Analyst, whoHandler)
+
+	for _, tt := range []struct {
+		name, authorization string
+		status              int
+		body                string
+	}{
+		{"no token", "", http.StatusUnauthorized, "missing or invalid token"},
+		{"unknown token", "Bearer gcc_nope", http.StatusUnauthorized, "missing or invalid token"},
+		{"not a bearer token", "Basic " + secrets[auth.Admin], http.StatusUnauthorized, "missing or invalid token"},
+		{"reader", "Bearer " + secrets[auth.Reader], http.StatusForbidden, `token \"reader\" (reader) may not do this; it needs the analyst role`},
+		{"analyst", "Bearer " + secrets[auth.Analyst], http.StatusOK, "analyst ("},
+		{"admin", "Bearer " + secrets[auth.Admin], http.StatusOK, "admin ("},
+	} {
+		w := get(h, tt.authorization)
+		if w.Code != tt.status || !strings.Contains(w.Body.String(), tt.body) {
+			t.Errorf("%s: got %d %q, want %d containing %q", tt.name, w.Code, w.Body, tt.status, tt.body)
+		}
+		if got := w.Header().Get("WWW-Authenticate"); (w.Code == http.StatusUnauthorized) != (got != "") {
+			t.Errorf("%s: %d with WWW-Authenticate %q", tt.name, w.Code, got)
+		}
+	}
+}
+
+func TestRequireReaderIfPrivate(t *testing.T) {
+	if w := get(requireReaderIfPrivate(nil, whoHandler), ""); w.Code != http.StatusOK {
+		t.Errorf("no tokens file: got %d, want 200", w.Code)
+	}
+
+	public, _ := testTokens(t, false)
+	if w := get(requireReaderIfPrivate(public, whoHandler), ""); w.Code != http.StatusOK {
+		t.Errorf("public: got %d, want 200", w.Code)
+	}
+
+	private, secrets := testTokens(t, true)
+	h := requireReaderIfPrivate(private, whoHandler)
+	if w := get(h, ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("private, no token: got %d, want 401", w.Code)
+	}
+	if w := get(h, "Bearer "+secrets[auth.Reader]); w.Code != http.StatusOK {
+		t.Errorf("private, reader: got %d, want 200", w.Code)
+	}
+}
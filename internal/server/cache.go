@@ -0,0 +1,223 @@
+package server
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ThomasAdam/guardian-cc/api"
+	gccdb "github.com/ThomasAdam/guardian-cc/internal/db"
+)
+
+// lru is a fixed-size map that evicts the least recently used entry.  It
+// is safe for concurrent use.
+type lru[K comparable, V any] struct {
+	mu    sync.Mutex
+	size  int
+	ll    *list.List // of *lruEntry, most recently used first
+	items map[K]*list.Element
+}
+
+type lruEntry[K comparable, V any] struct {
+	key   K
+	value V
+}
+
+func newLRU[K comparable, V any](size int) *lru[K, V] {
+	return &lru[K, V]{size: size, ll: list.New(), items: make(map[K]*list.Element)}
+}
+
+func (c *lru[K, V]) get(key K) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.items[key]; ok {
+		c.ll.MoveToFront(e)
+		return e.Value.(*lruEntry[K, V]).value, true
+	}
+	var zero V
+	return zero, false
+}
+
+func (c *lru[K, V]) add(key K, value V) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.items[key]; ok {
+		c.ll.MoveToFront(e)
+		e.Value.(*lruEntry[K, V]).value = value
+		return
+	}
+	c.items[key] = c.ll.PushFront(&lruEntry[K, V]{key, value})
+	if c.ll.Len() > c.size {
+		oldest := c.ll.Back()
+		c.ll.Remove(oldest)
+		delete(c.items, oldest.Value.(*lruEntry[K, V]).key)
+	}
+}
+
+func (c *lru[K, V]) purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ll.Init()
+	clear(c.items)
+}
+
+// Sizes of the /api/dt caches, in entries.  A page is at most
+// maxDTLength rows, and usually the default of 10.
+const (
+	dtCountCacheSize = 1024
+	dtPageCacheSize  = 1024
+)
+
+// dtCache holds /api/dt row counts and pages for one DB generation.
+// Counts are keyed by table and search, so paging through a search does
+// not count its rows again; pages also by order and position.  When the
+// generation changes (i.e. after an import) everything is dropped.
+type dtCache struct {
+	mu         sync.Mutex
+	generation int64
+
+	counts *lru[string, int]
+	pages  *lru[string, *api.DataTablesResponse]
+}
+
+func newDTCache() *dtCache {
+	return &dtCache{
+		generation: -1,
+		counts:     newLRU[string, int](dtCountCacheSize),
+		pages:      newLRU[string, *api.DataTablesResponse](dtPageCacheSize),
+	}
+}
+
+// at makes the cache valid for generation gen, emptying it if it held
+// entries for another generation.
+func (c *dtCache) at(gen int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if gen != c.generation {
+		c.counts.purge()
+		c.pages.purge()
+		c.generation = gen
+	}
+}
+
+// count returns the number of rows query matches, from the cache if it
+// has been counted before.
+func (c *dtCache) count(ctx context.Context, db *sql.DB, key, query string, args ...any) (int, error) {
+	n, ok := c.counts.get(key)
+	cacheLookups.Inc("dt_counts", cacheResult(ok))
+	if ok {
+		return n, nil
+	}
+	start := time.Now()
+	if err := db.QueryRowContext(ctx, query, args...).Scan(&n); err != nil {
+		return 0, err
+	}
+	queryDuration.Since(start, "dt_count")
+	c.counts.add(key, n)
+	return n, nil
+}
+
+// cacheKey joins the parts of a cache key unambiguously.
+func cacheKey(parts ...any) string {
+	var b strings.Builder
+	for _, p := range parts {
+		fmt.Fprintf(&b, "%q\x00", fmt.Sprint(p))
+	}
+	return b.String()
+}
+
+// serverStart is when the server started.  It is part of every
+// generation-based ETag, so that a new binary, which may send different
+// responses for the same data, does not match the old one's tags.
+var serverStart = time.Now()
+
+// generationKey is the request context key for the DB generation a
+// response is built from.
+type generationKey struct{}
+
+// requestGeneration returns the DB generation set by withGeneration.
+func requestGeneration(ctx context.Context) int64 {
+	gen, _ := ctx.Value(generationKey{}).(int64)
+	return gen
+}
+
+// withGeneration serves responses built from the DB with an ETag derived
+// from the DB generation and the request, so that a client revalidating
+// between imports gets a 304 without any query being run.  The
+// generation is passed on to h in the request context.
+func withGeneration(db *sql.DB, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gen, err := gccdb.Generation(db)
+		if err != nil {
+			log.Printf("error reading generation: %v", err)
+			http.Error(w, "query error", http.StatusInternalServerError)
+			return
+		}
+
+		sum := sha256.Sum256([]byte(cacheKey(serverStart.UnixNano(), r.URL.Path, r.URL.RawQuery)))
+		etag := fmt.Sprintf(`"g%d-%s"`, gen, hex.EncodeToString(sum[:8]))
+		if etagMatch(r.Header.Get("If-None-Match"), etag) {
+			w.Header().Set("ETag", etag)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), generationKey{}, gen)
+		h.ServeHTTP(&etagWriter{ResponseWriter: w, etag: etag}, r.WithContext(ctx))
+	})
+}
+
+// etagWriter adds an ETag to successful responses only, so that errors
+// are never revalidated as if they were current.
+type etagWriter struct {
+	http.ResponseWriter
+	etag        string
+	wroteHeader bool
+}
+
+func (w *etagWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		if status == http.StatusOK {
+			w.Header().Set("ETag", w.etag)
+			w.Header().Set("Cache-Control", pageCacheControl)
+		}
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *etagWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *etagWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
+
+// etagMatch reports whether an If-None-Match header value matches etag,
+// using the weak comparison RFC 9110 requires for If-None-Match.
+func etagMatch(header, etag string) bool {
+	for _, t := range strings.Split(header, ",") {
+		t = strings.TrimSpace(t)
+		if t == "*" || strings.TrimPrefix(t, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// contentETag returns a strong ETag for a fixed response body.
+func contentETag(b []byte) string {
+	sum := sha256.Sum256(b)
+	return `"` + hex.EncodeToString(sum[:8]) + `"`
+}
@@ -0,0 +1,81 @@
+package server
+
+import (
+	"context"
+	"testing"
+)
+
+func TestLRU(t *testing.T) {
+	c := newLRU[string, int](2)
+	c.add("a", 1)
+	c.add("b", 2)
+	if v, ok := c.get("a"); !ok || v != 1 {
+		t.Errorf(`get("a") = %d, %v; want 1, true`, v, ok)
+	}
+	// "b" is now the least recently used, so "c" evicts it.
+	c.add("c", 3)
+	if _, ok := c.get("b"); ok {
+		t.Error(`"b" was not evicted`)
+	}
+	for k, want := range map[string]int{"a": 1, "c": 3} {
+		if v, ok := c.get(k); !ok || v != want {
+			t.Errorf("get(%q) = %d, %v; want %d, true", k, v, ok, want)
+		}
+	}
+
+	// Replacing a value makes it the most recently used.
+	c.add("a", 10)
+	c.add("d", 4)
+	if _, ok := c.get("c"); ok {
+		t.Error(`"c" was not evicted after "a" was replaced`)
+	}
+	if v, ok := c.get("a"); !ok || v != 10 {
+		t.Errorf(`get("a") = %d, %v; want 10, true`, v, ok)
+	}
+	if c.ll.Len() != 2 || len(c.items) != 2 {
+		t.Errorf("cache holds %d entries (%d in the map), want 2", c.ll.Len(), len(c.items))
+	}
+
+	c.purge()
+	if _, ok := c.get("a"); ok || c.ll.Len() != 0 || len(c.items) != 0 {
+		t.Errorf("cache holds %d entries after purge, want none", c.ll.Len())
+	}
+	c.add("e", 5)
+	if v, ok := c.get("e"); !ok || v != 5 {
+		t.Errorf(`get("e") after purge = %d, %v; want 5, true`, v, ok)
+	}
+}
+
+func TestDTCacheCount(t *testing.T) {
+	db := testDB(t)
+	ctx := context.Background()
+	c := newDTCache()
+	c.at(1)
+
+	count := func(key string) int {
+		t.Helper()
+		n, err := c.count(ctx, db, key, "SELECT COUNT(*) FROM crosswords")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+	if n := count("k"); n != 0 {
+		t.Fatalf("count = %d, want 0", n)
+	}
+	if _, err := db.Exec(`INSERT INTO crosswords (id, number, name, creator_name, creator_weburl, date, crossword_type)
+		VALUES ('crosswords/cryptic/1', '1', 'Cryptic 1', 'Araucaria', '', '2024-01-01', 'cryptic')`); err != nil {
+		t.Fatal(err)
+	}
+	if n := count("k"); n != 0 {
+		t.Errorf("count within a generation = %d, want the cached 0", n)
+	}
+	c.at(1)
+	if n := count("k"); n != 0 {
+		t.Errorf("count for the same generation = %d, want the cached 0", n)
+	}
+	c.at(2)
+	if n := count("k"); n != 1 {
+		t.Errorf("count for a new generation = %d, want 1", n)
+	}
+}
@@ -0,0 +1,51 @@
+package server
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/ThomasAdam/guardian-cc/internal/charts"
+)
+
+// handleChartData serves the data behind a chart section, as CSV (the
+// default) or JSON depending on the format parameter.
+func handleChartData(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	order := r.PathValue("order")
+	p := charts.FindPlugin(order)
+	if p == nil {
+		http.Error(w, "unknown chart", http.StatusNotFound)
+		return
+	}
+
+	var write func(w http.ResponseWriter, t *charts.Table) error
+	switch r.URL.Query().Get("format") {
+	case "", "csv":
+		write = func(w http.ResponseWriter, t *charts.Table) error {
+			w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+			w.Header().Set("Content-Disposition", `attachment; filename="chart`+order+`.csv"`)
+			return charts.WriteCSV(w, t)
+		}
+	case "json":
+		write = func(w http.ResponseWriter, t *charts.Table) error {
+			w.Header().Set("Content-Type", "application/json")
+			return charts.WriteJSON(w, t)
+		}
+	default:
+		http.Error(w, "unknown format", http.StatusBadRequest)
+		return
+	}
+
+	start := time.Now()
+	t, err := charts.PluginData(r.Context(), p, db, charts.Options{})
+	queryDuration.Since(start, "chart_data")
+	if err != nil {
+		log.Printf("error reading chart%s data: %v", order, err)
+		http.Error(w, "query error", http.StatusInternalServerError)
+		return
+	}
+	if err := write(w, t); err != nil {
+		log.Printf("error writing chart%s data: %v", order, err)
+	}
+}
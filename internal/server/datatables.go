@@ -0,0 +1,210 @@
+package server
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/ThomasAdam/guardian-cc/internal/charts"
+)
+
+// maxDTLength caps the page size a client may ask for.
+const maxDTLength = 1000
+
+// dtRequest is the part of a DataTables server-side processing request
+// that decides which rows are returned and in what order.
+type dtRequest struct {
+	draw   int
+	start  int
+	length int
+	// search is the table-wide search box.
+	search dtSearch
+	// columns holds the per-column state, one per dataset column.
+	columns []dtColumn
+	order   []dtOrder
+}
+
+type dtSearch struct {
+	value string
+	regex bool
+}
+
+type dtColumn struct {
+	search     dtSearch
+	searchable bool
+	orderable  bool
+}
+
+type dtOrder struct {
+	column int
+	desc   bool
+}
+
+// parseDTRequest reads a DataTables request for ds from q.  Column
+// entries beyond ds's columns are ignored, as are order entries naming a
+// column that does not exist or is not orderable.  Invalid regular
+// expressions are reported as errors.
+func parseDTRequest(q url.Values, ds *charts.TableDataset) (*dtRequest, error) {
+	req := &dtRequest{
+		search: dtSearch{
+			value: q.Get("search[value]"),
+			regex: q.Get("search[regex]") == "true",
+		},
+		columns: make([]dtColumn, len(ds.Columns)),
+	}
+	req.draw, _ = strconv.Atoi(q.Get("draw"))
+	req.start, _ = strconv.Atoi(q.Get("start"))
+	req.length, _ = strconv.Atoi(q.Get("length"))
+	if req.start < 0 {
+		req.start = 0
+	}
+	if req.length <= 0 {
+		req.length = 10
+	}
+	req.length = min(req.length, maxDTLength)
+
+	for i := range req.columns {
+		prefix := fmt.Sprintf("columns[%d]", i)
+		req.columns[i] = dtColumn{
+			search: dtSearch{
+				value: q.Get(prefix + "[search][value]"),
+				regex: q.Get(prefix+"[search][regex]") == "true",
+			},
+			// DataTables sends these for every column; a missing value
+			// means the client is not DataTables, so default to allowing.
+			searchable: q.Get(prefix+"[searchable]") != "false",
+			orderable:  q.Get(prefix+"[orderable]") != "false",
+		}
+	}
+
+	for k := 0; ; k++ {
+		prefix := fmt.Sprintf("order[%d]", k)
+		col := q.Get(prefix + "[column]")
+		if col == "" {
+			break
+		}
+		idx, err := strconv.Atoi(col)
+		if err != nil || idx < 0 || idx >= len(ds.Columns) || !req.columns[idx].orderable {
+			continue
+		}
+		req.order = append(req.order, dtOrder{
+			column: idx,
+			desc:   strings.EqualFold(q.Get(prefix+"[dir]"), "desc"),
+		})
+	}
+
+	searches := []dtSearch{req.search}
+	for _, c := range req.columns {
+		searches = append(searches, c.search)
+	}
+	for _, s := range searches {
+		if s.regex && s.value != "" {
+			if _, err := regexp.Compile(s.value); err != nil {
+				return nil, fmt.Errorf("invalid regular expression %q: %w", s.value, err)
+			}
+		}
+	}
+	return req, nil
+}
+
+// where returns the WHERE clause (or "") applying the table-wide search
+// to every searchable column and each searchable column's own search to
+// that column, along with its arguments.  As in DataTables' client-side filtering, a
+// plain search is split into words (with "quoted phrases" kept together)
+// which must all match, case-insensitively.  Search values only ever
+// reach the query as bound parameters.
+func (req *dtRequest) where(ds *charts.TableDataset) (string, []any) {
+	var conds []string
+	var args []any
+	if req.search.value != "" {
+		var fields []string
+		for i, col := range ds.Columns {
+			if col.Searchable && req.columns[i].searchable {
+				fields = append(fields, col.Field)
+			}
+		}
+		if len(fields) > 0 {
+			c, a := searchCondition(fields, req.search)
+			conds, args = append(conds, c...), append(args, a...)
+		}
+	}
+	for i, col := range ds.Columns {
+		if col.Searchable && req.columns[i].searchable && req.columns[i].search.value != "" {
+			c, a := searchCondition([]string{col.Field}, req.columns[i].search)
+			conds, args = append(conds, c...), append(args, a...)
+		}
+	}
+	if len(conds) == 0 {
+		return "", nil
+	}
+	return "WHERE " + strings.Join(conds, " AND "), args
+}
+
+// searchCondition returns the conditions, all of which must hold, for s
+// to match in at least one of fields, and their arguments.  fields come
+// from the dataset, never from the request.
+func searchCondition(fields []string, s dtSearch) ([]string, []any) {
+	var terms []string
+	if s.regex {
+		terms = []string{s.value}
+	} else {
+		terms = searchTerms(s.value)
+	}
+
+	var conds []string
+	var args []any
+	for _, term := range terms {
+		alts := make([]string, len(fields))
+		for i, f := range fields {
+			if s.regex {
+				alts[i] = fmt.Sprintf("regexp_matches(CAST(%s AS VARCHAR), ?, 'i')", f)
+				args = append(args, term)
+			} else {
+				alts[i] = fmt.Sprintf(`CAST(%s AS VARCHAR) ILIKE ? ESCAPE '\'`, f)
+				args = append(args, "%"+likeEscape(term)+"%")
+			}
+		}
+		conds = append(conds, "("+strings.Join(alts, " OR ")+")")
+	}
+	return conds, args
+}
+
+// likeEscaper escapes the LIKE metacharacters, so that a search for
+// "100%" or "a_b" matches those characters literally.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// likeEscape escapes s for use in a LIKE pattern with ESCAPE '\'.
+func likeEscape(s string) string {
+	return likeEscaper.Replace(s)
+}
+
+// searchTermRE matches a "quoted phrase" or a single word.
+var searchTermRE = regexp.MustCompile(`"[^"]*"|[^\s"]+`)
+
+// searchTerms splits a search into words and quoted phrases.
+func searchTerms(value string) []string {
+	var terms []string
+	for _, t := range searchTermRE.FindAllString(value, -1) {
+		t = strings.Trim(t, `"`)
+		if t != "" {
+			terms = append(terms, t)
+		}
+	}
+	return terms
+}
+
+// orderBy returns the ORDER BY list for the requested ordering, followed
+// by the dataset's own order so that pages are stable.
+func (req *dtRequest) orderBy(ds *charts.TableDataset) string {
+	var parts []string
+	for _, o := range req.order {
+		dir := "ASC"
+		if o.desc {
+			dir = "DESC"
+		}
+		parts = append(parts, ds.Columns[o.column].Field+" "+dir)
+	}
+	return strings.Join(append(parts, ds.OrderBy), ", ")
+}
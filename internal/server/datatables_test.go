@@ -0,0 +1,192 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ThomasAdam/guardian-cc/internal/charts"
+)
+
+// sqlPatterns returns regular expressions matching every WHERE clause and
+// ORDER BY list the code may write for ds.  Their only variable parts are
+// ds's fields, and only its searchable ones in WHERE clauses, so a query
+// they match holds no text from the request.
+func sqlPatterns(ds *charts.TableDataset) (where, orderBy *regexp.Regexp) {
+	var fields, searchable []string
+	for _, c := range ds.Columns {
+		fields = append(fields, regexp.QuoteMeta(c.Field))
+		if c.Searchable {
+			searchable = append(searchable, regexp.QuoteMeta(c.Field))
+		}
+	}
+	f := "(?:" + strings.Join(fields, "|") + ")"
+	s := "(?:" + strings.Join(searchable, "|") + ")"
+	alt := `(?:CAST\(` + s + ` AS VARCHAR\) ILIKE \? ESCAPE '\\'|regexp_matches\(CAST\(` + s + ` AS VARCHAR\), \?, 'i'\))`
+	cond := `\(` + alt + `(?: OR ` + alt + `)*\)`
+	where = regexp.MustCompile(`^(?:WHERE ` + cond + `(?: AND ` + cond + `)*)?$`)
+	orderBy = regexp.MustCompile(`^(?:` + f + ` (?:ASC|DESC), )*` + regexp.QuoteMeta(ds.OrderBy) + `$`)
+	return where, orderBy
+}
+
+// unescapeLike undoes likeEscape on the term inside a "%term%" pattern,
+// reporting false if the pattern has any wildcard of its own there.
+func unescapeLike(pattern string) (string, bool) {
+	if len(pattern) < 2 || pattern[0] != '%' || pattern[len(pattern)-1] != '%' {
+		return "", false
+	}
+	inner := pattern[1 : len(pattern)-1]
+	var b strings.Builder
+	for i := 0; i < len(inner); i++ {
+		switch c := inner[i]; c {
+		case '%', '_':
+			return "", false
+		case '\\':
+			if i+1 == len(inner) || !strings.ContainsRune(`\%_`, rune(inner[i+1])) {
+				return "", false
+			}
+			i++
+			b.WriteByte(inner[i])
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String(), true
+}
+
+// checkArgs fails t unless each of args is the value of one of the
+// regular expression searches, or a LIKE pattern matching part of the
+// value of one of the other searches literally.
+func checkArgs(t *testing.T, args []any, searches []dtSearch) {
+	t.Helper()
+	for _, a := range args {
+		s, ok := a.(string)
+		if !ok {
+			t.Fatalf("argument %#v is not a string", a)
+		}
+		term, like := unescapeLike(s)
+		found := false
+		for _, search := range searches {
+			if search.regex && s == search.value ||
+				!search.regex && like && term != "" && strings.Contains(search.value, term) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Fatalf("argument %q does not come from one of the searches %+v", s, searches)
+		}
+	}
+}
+
+func FuzzParseDTRequest(f *testing.F) {
+	for _, seed := range []string{
+		"draw=1&start=0&length=10&search[value]=&search[regex]=false",
+		"draw=2&start=20&length=50&search[value]=hidden+%22word+play%22&order[0][column]=1&order[0][dir]=desc",
+		"search[value]=100%25+a_b+back%5Cslash&columns[0][search][value]=Araucaria&columns[3][searchable]=false",
+		"search[value]=%5EA.*E%24&search[regex]=true&columns[1][search][value]=(river|sea)&columns[1][search][regex]=true",
+		"search[value]=x')+OR+1%3D1+--&order[0][column]=0;DROP+TABLE+crosswords&order[1][column]=2&order[1][dir]=desc,+1",
+		"columns[0][orderable]=false&order[0][column]=0&order[1][column]=-1&order[2][column]=99",
+		"columns[3][search][value]=cryptic&columns[3][search][regex]=true&columns[2][searchable]=false&columns[2][search][value]=river",
+		"search[value]=(&search[regex]=true",
+		"start=-5&length=100000",
+	} {
+		f.Add(seed)
+	}
+	ds := charts.FindTable("chart5")
+	wherePattern, orderByPattern := sqlPatterns(ds)
+
+	f.Fuzz(func(t *testing.T, raw string) {
+		q, _ := url.ParseQuery(raw)
+		req, err := parseDTRequest(q, ds)
+		if err != nil {
+			return
+		}
+		if req.start < 0 || req.length <= 0 || req.length > maxDTLength {
+			t.Fatalf("start %d, length %d out of range", req.start, req.length)
+		}
+
+		where, args := req.where(ds)
+		if !wherePattern.MatchString(where) {
+			t.Fatalf("WHERE clause %q has text of its own or searches an unsearchable column", where)
+		}
+		if n := strings.Count(where, "?"); n != len(args) {
+			t.Fatalf("WHERE clause %q has %d parameters but %d arguments", where, n, len(args))
+		}
+		searches := []dtSearch{{value: q.Get("search[value]"), regex: q.Get("search[regex]") == "true"}}
+		for i, col := range ds.Columns {
+			prefix := fmt.Sprintf("columns[%d]", i)
+			if col.Searchable && q.Get(prefix+"[searchable]") != "false" {
+				searches = append(searches, dtSearch{value: q.Get(prefix + "[search][value]"), regex: q.Get(prefix+"[search][regex]") == "true"})
+			}
+		}
+		checkArgs(t, args, searches)
+
+		if orderBy := req.orderBy(ds); !orderByPattern.MatchString(orderBy) {
+			t.Fatalf("ORDER BY %q has text of its own", orderBy)
+		}
+	})
+}
+
+func FuzzSearchCondition(f *testing.F) {
+	for _, seed := range []struct {
+		value string
+		regex bool
+	}{
+		{"river", false},
+		{`hidden "word play" sea`, false},
+		{`100% a_b \ %_\`, false},
+		{`"unterminated phrase`, false},
+		{`'; DROP TABLE crosswords; --`, false},
+		{`^A.*E$`, true},
+		{`') OR 1=1 --`, true},
+	} {
+		f.Add(seed.value, seed.regex)
+	}
+	fields := []string{"setter", "clue"}
+	ds := &charts.TableDataset{Columns: []charts.TableColumn{{Field: "setter", Searchable: true}, {Field: "clue", Searchable: true}}}
+	wherePattern, _ := sqlPatterns(ds)
+
+	f.Fuzz(func(t *testing.T, value string, regex bool) {
+		conds, args := searchCondition(fields, dtSearch{value: value, regex: regex})
+		where := ""
+		if len(conds) > 0 {
+			where = "WHERE " + strings.Join(conds, " AND ")
+		}
+		if !wherePattern.MatchString(where) {
+			t.Fatalf("conditions %q have text of their own", conds)
+		}
+		if len(args) != len(conds)*len(fields) || strings.Count(where, "?") != len(args) {
+			t.Fatalf("%d conditions over %d fields with %d arguments", len(conds), len(fields), len(args))
+		}
+		if regex && len(conds) != 1 {
+			t.Fatalf("regular expression %q gave %d conditions, want 1", value, len(conds))
+		}
+		checkArgs(t, args, []dtSearch{{value: value, regex: regex}})
+	})
+}
+
+func TestDataTableTimeout(t *testing.T) {
+	db := testDB(t)
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+	r := httptest.NewRequest("GET", "/api/dt?table=chart5&draw=1", nil).WithContext(ctx)
+	w := httptest.NewRecorder()
+	handleDataTable(db, newDTCache(), w, r)
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") != retryAfter {
+		t.Errorf("timed out request = %d, Retry-After %q; want 503, %s", w.Code, w.Header().Get("Retry-After"), retryAfter)
+	}
+
+	r = httptest.NewRequest("GET", "/api/dt?table=chart5&draw=2", nil)
+	w = httptest.NewRecorder()
+	handleDataTable(db, newDTCache(), w, r)
+	if w.Code != http.StatusOK {
+		t.Errorf("request = %d %q, want 200", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,177 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ThomasAdam/guardian-cc/internal/charts"
+)
+
+// dtExportTimeout bounds an /api/dt export, which reads every matching
+// row rather than a page.
+const dtExportTimeout = writeTimeout
+
+// rowWriter writes exported rows in one format.
+type rowWriter interface {
+	header(names []string) error
+	row(names []string, values []any) error
+	flush() error
+}
+
+// dtExportFormats are the formats /api/dt exports in, by format parameter.
+var dtExportFormats = map[string]struct {
+	contentType string
+	newWriter   func(io.Writer) rowWriter
+}{
+	"csv":   {"text/csv; charset=utf-8", func(w io.Writer) rowWriter { return &csvWriter{csv.NewWriter(w)} }},
+	"tsv":   {"text/tab-separated-values; charset=utf-8", func(w io.Writer) rowWriter { return &tsvWriter{bufio.NewWriter(w)} }},
+	"jsonl": {"application/x-ndjson", func(w io.Writer) rowWriter { return &jsonlWriter{bufio.NewWriter(w)} }},
+}
+
+// handleDTExport streams every row of ds matching where, in the request's
+// order, as a file in the given format.  Links are exported as plain
+// values (see charts.TableColumn.Export).
+func handleDTExport(db *sql.DB, ds *charts.TableDataset, format, where string, args []any, orderBy string, w http.ResponseWriter, r *http.Request) {
+	f, ok := dtExportFormats[format]
+	if !ok {
+		http.Error(w, "unknown format", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), dtExportTimeout)
+	defer cancel()
+
+	query := "SELECT * FROM (" + ds.Query + ") _t " + where + " ORDER BY " + orderBy
+	start := time.Now()
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		log.Printf("error querying export: %v (sql: %s)", err, query)
+		http.Error(w, "query error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	w.Header().Set("Content-Type", f.contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.%s"`, ds.Name, format))
+	rw := f.newWriter(w)
+	names := ds.ExportNames()
+	if err := rw.header(names); err != nil {
+		return
+	}
+	err = charts.ScanTableRows(rows, func(row charts.TableRow) error {
+		return rw.row(names, ds.ExportValues(row))
+	})
+	if err == nil {
+		err = rw.flush()
+	}
+	queryDuration.Since(start, "dt_export")
+	if err != nil {
+		// The status has gone; all that can be done is to cut the
+		// response short.
+		log.Printf("error exporting %s: %v", ds.Name, err)
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// exportField formats a value for a CSV or TSV field.
+func exportField(v any) string {
+	switch v := v.(type) {
+	case []string:
+		return strings.Join(v, charts.ListSeparator)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+type csvWriter struct{ w *csv.Writer }
+
+func (c *csvWriter) header(names []string) error { return c.w.Write(names) }
+
+func (c *csvWriter) row(_ []string, values []any) error {
+	fields := make([]string, len(values))
+	for i, v := range values {
+		fields[i] = exportField(v)
+	}
+	return c.w.Write(fields)
+}
+
+func (c *csvWriter) flush() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// tsvWriter writes IANA text/tab-separated-values, which has no quoting:
+// tabs and line breaks inside a field become spaces.
+type tsvWriter struct{ w *bufio.Writer }
+
+var tsvEscaper = strings.NewReplacer("\t", " ", "\r\n", " ", "\n", " ", "\r", " ")
+
+func (t *tsvWriter) line(fields []string) error {
+	for i, f := range fields {
+		if i > 0 {
+			t.w.WriteByte('\t')
+		}
+		tsvEscaper.WriteString(t.w, f)
+	}
+	return t.w.WriteByte('\n')
+}
+
+func (t *tsvWriter) header(names []string) error { return t.line(names) }
+
+func (t *tsvWriter) row(_ []string, values []any) error {
+	fields := make([]string, len(values))
+	for i, v := range values {
+		fields[i] = exportField(v)
+	}
+	return t.line(fields)
+}
+
+func (t *tsvWriter) flush() error { return t.w.Flush() }
+
+// jsonlWriter writes one JSON object per row, with the fields in column
+// order and lists as arrays.
+type jsonlWriter struct{ w *bufio.Writer }
+
+func (j *jsonlWriter) header([]string) error { return nil }
+
+func (j *jsonlWriter) row(names []string, values []any) error {
+	j.w.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			j.w.WriteByte(',')
+		}
+		if err := writeJSONValue(j.w, name); err != nil {
+			return err
+		}
+		j.w.WriteByte(':')
+		if err := writeJSONValue(j.w, values[i]); err != nil {
+			return err
+		}
+	}
+	_, err := j.w.WriteString("}\n")
+	return err
+}
+
+func (j *jsonlWriter) flush() error { return j.w.Flush() }
+
+// writeJSONValue writes v as compact JSON without escaping HTML
+// characters, which would only obscure clues like "Tom & Jerry".
+func writeJSONValue(w *bufio.Writer, v any) error {
+	var b strings.Builder
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return err
+	}
+	_, err := w.WriteString(strings.TrimSuffix(b.String(), "\n"))
+	return err
+}
@@ -0,0 +1,255 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ThomasAdam/guardian-cc/api"
+	"github.com/ThomasAdam/guardian-cc/internal/charts"
+	"github.com/ThomasAdam/guardian-cc/internal/importer"
+	"github.com/ThomasAdam/guardian-cc/internal/jobs"
+	"github.com/ThomasAdam/guardian-cc/ui"
+)
+
+// renderOutput is where render jobs write the page, as render does.
+const renderOutput = "./gcc-analysis.html"
+
+// keepAliveInterval is how often a quiet event stream gets a comment, so
+// that proxies do not drop it.
+const keepAliveInterval = 30 * time.Second
+
+// jobTasks returns the kinds of background job serve runs: import, as
+// the import command does with no files, render, as the render command
+// does with no flags, and refresh, an import then a render.
+func jobTasks(db *sql.DB, tmpls *charts.Templates) map[string]jobs.Task {
+	importTask := func(w io.Writer) error {
+		res, err := importer.Import(db, nil, w)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "Imported %d files: %d added, %d failed\n", res.Files, res.Added, res.Failed)
+		return nil
+	}
+	renderTask := func(w io.Writer) error {
+		if err := charts.RenderAll(db, tmpls, renderOutput, charts.Options{}, w); err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "Written: %s\n", renderOutput)
+		return nil
+	}
+	return map[string]jobs.Task{
+		"import": importTask,
+		"render": renderTask,
+		"refresh": func(w io.Writer) error {
+			if err := importTask(w); err != nil {
+				return err
+			}
+			return renderTask(w)
+		},
+	}
+}
+
+// registerJobs adds the routes for background jobs to mux, each behind
+// auth:
+//
+//	GET  /api/admin/jobs              the job history, newest first
+//	POST /api/admin/jobs              queue a job (kind=import|refresh|render)
+//	GET  /api/admin/jobs/{id}         a job with its output so far
+//	GET  /api/admin/jobs/{id}/events  its output as Server-Sent Events
+//	GET  /admin/jobs                  a page to start and watch jobs
+//
+// The page itself holds no data, so it needs no token.  Event streams end
+// when ctx is cancelled, so that they do not hold up shutdown.
+func registerJobs(ctx context.Context, mux *http.ServeMux, runner *jobs.Runner, auth func(http.Handler) http.Handler) {
+	handle := func(pattern string, h http.HandlerFunc) {
+		mux.Handle(pattern, auth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Cache-Control", "no-store")
+			h(w, r)
+		})))
+	}
+	handle("GET /api/admin/jobs", func(w http.ResponseWriter, r *http.Request) {
+		pg, err := parsePage(r.URL.Query())
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, api.Error{Error: err.Error()})
+			return
+		}
+		list, total, err := runner.List(pg.Offset, pg.Limit)
+		if err != nil {
+			log.Printf("error listing jobs: %v", err)
+			writeJSON(w, http.StatusInternalServerError, api.Error{Error: "query error"})
+			return
+		}
+		pg.Total = total
+		writeJSON(w, http.StatusOK, pageOf(r, list, pg))
+	})
+	handle("POST /api/admin/jobs", func(w http.ResponseWriter, r *http.Request) {
+		kind := r.FormValue("kind")
+		j, err := runner.Submit(kind)
+		switch {
+		case errors.Is(err, jobs.ErrUnknownKind):
+			writeJSON(w, http.StatusBadRequest, api.Error{Error: fmt.Sprintf("unknown kind of job %q (want import, refresh or render)", kind)})
+			return
+		case errors.Is(err, jobs.ErrQueueFull):
+			writeJSON(w, http.StatusServiceUnavailable, api.Error{Error: err.Error()})
+			return
+		case err != nil:
+			log.Printf("error queueing job: %v", err)
+			writeJSON(w, http.StatusInternalServerError, api.Error{Error: "could not queue job"})
+			return
+		}
+		log.Printf("job %d (%s) queued by %s", j.ID, j.Kind, who(r))
+		w.Header().Set("Location", fmt.Sprintf("/api/admin/jobs/%d", j.ID))
+		writeJSON(w, http.StatusAccepted, j)
+	})
+	handle("GET /api/admin/jobs/{id}", func(w http.ResponseWriter, r *http.Request) {
+		id, ok := jobID(w, r)
+		if !ok {
+			return
+		}
+		j, err := runner.Get(id)
+		if err != nil {
+			writeJobError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, j)
+	})
+	handle("GET /api/admin/jobs/{id}/events", func(w http.ResponseWriter, r *http.Request) {
+		id, ok := jobID(w, r)
+		if !ok {
+			return
+		}
+		streamJob(ctx, runner, id, w, r)
+	})
+	mux.Handle("GET /admin/jobs", uiPage("jobs.html"))
+}
+
+// jobID reads the id in a job's path, reporting a bad one.
+func jobID(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeJSON(w, http.StatusNotFound, api.Error{Error: "job not found"})
+		return 0, false
+	}
+	return id, true
+}
+
+func writeJobError(w http.ResponseWriter, err error) {
+	if errors.Is(err, jobs.ErrNotFound) {
+		writeJSON(w, http.StatusNotFound, api.Error{Error: err.Error()})
+		return
+	}
+	log.Printf("error reading job: %v", err)
+	writeJSON(w, http.StatusInternalServerError, api.Error{Error: "query error"})
+}
+
+// streamJob sends a job's output as Server-Sent Events: one "data" event
+// per line, from the start or from the line after the Last-Event-ID the
+// client reconnects with, each with the line's number as its id.  When
+// the job has finished a "done" event carries the job, without its
+// output, and the stream ends.
+func streamJob(ctx context.Context, runner *jobs.Runner, id int64, w http.ResponseWriter, r *http.Request) {
+	from := 0
+	if v := r.Header.Get("Last-Event-ID"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			from = n + 1
+		}
+	}
+	lines, next, changed, err := runner.Follow(id, from)
+	if err != nil {
+		writeJobError(w, err)
+		return
+	}
+
+	// A job may run for longer than the server's write timeout.
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		log.Printf("error clearing write deadline: %v", err)
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	keepAlive := time.NewTicker(keepAliveInterval)
+	defer keepAlive.Stop()
+	for {
+		for i, line := range lines {
+			writeEvent(w, next-len(lines)+i, line)
+		}
+		if changed == nil {
+			j, err := runner.Get(id)
+			if err != nil {
+				log.Printf("error reading job %d: %v", id, err)
+				return
+			}
+			j.Output, j.Dropped = nil, 0
+			b, _ := json.Marshal(j)
+			fmt.Fprintf(w, "event: done\ndata: %s\n\n", b)
+			rc.Flush()
+			return
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+
+	wait:
+		for {
+			select {
+			case <-changed:
+				break wait
+			case <-keepAlive.C:
+				if _, err := io.WriteString(w, ": keep-alive\n\n"); err != nil {
+					return
+				}
+				if err := rc.Flush(); err != nil {
+					return
+				}
+			case <-r.Context().Done():
+				return
+			case <-ctx.Done():
+				return
+			}
+		}
+		lines, next, changed, err = runner.Follow(id, next)
+		if err != nil {
+			log.Printf("error following job %d: %v", id, err)
+			return
+		}
+	}
+}
+
+// writeEvent writes a line of a job's output as an event with the given
+// id.  A carriage return in the line would end an SSE field, so each
+// part of the line between them goes in a data field of its own, and
+// the client gets them back joined by newlines.
+func writeEvent(w io.Writer, id int, line string) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "id: %d\n", id)
+	line = strings.ReplaceAll(strings.ReplaceAll(line, "\r\n", "\n"), "\r", "\n")
+	for _, part := range strings.Split(line, "\n") {
+		fmt.Fprintf(&b, "data: %s\n", part)
+	}
+	b.WriteString("\n")
+	io.WriteString(w, b.String())
+}
+
+// uiPage serves one of the static pages in package ui.
+func uiPage(name string) http.Handler {
+	page, err := ui.FS.ReadFile(name)
+	if err != nil {
+		panic(err)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Cache-Control", pageCacheControl)
+		w.Write(page)
+	})
+}
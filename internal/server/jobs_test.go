@@ -0,0 +1,23 @@
+package server
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWriteEvent(t *testing.T) {
+	for _, tt := range []struct {
+		line, want string
+	}{
+		{"Added: crosswords/cryptic/1", "id: 7\ndata: Added: crosswords/cryptic/1\n\n"},
+		{"", "id: 7\ndata: \n\n"},
+		{"10%\r50%\r100%", "id: 7\ndata: 10%\ndata: 50%\ndata: 100%\n\n"},
+		{"a\r\nb\n\nc", "id: 7\ndata: a\ndata: b\ndata: \ndata: c\n\n"},
+	} {
+		var b strings.Builder
+		writeEvent(&b, 7, tt.line)
+		if got := b.String(); got != tt.want {
+			t.Errorf("writeEvent(%q) = %q, want %q", tt.line, got, tt.want)
+		}
+	}
+}
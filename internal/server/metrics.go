@@ -0,0 +1,60 @@
+package server
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ThomasAdam/guardian-cc/internal/metrics"
+)
+
+// registry holds the server's metrics, served at /metrics.
+var registry = metrics.NewRegistry()
+
+var (
+	httpRequests = registry.Counter("gcc_http_requests_total",
+		"HTTP requests by route, method and status code.", "route", "method", "code")
+	httpDuration = registry.Histogram("gcc_http_request_duration_seconds",
+		"Time to serve HTTP requests, by route.", metrics.DefBuckets, "route")
+	dtRequests = registry.Counter("gcc_dt_requests_total",
+		"/api/dt requests by table.", "table")
+	dtDuration = registry.Histogram("gcc_dt_request_duration_seconds",
+		"Time to serve /api/dt requests, by table.", metrics.DefBuckets, "table")
+	queryDuration = registry.Histogram("gcc_db_query_duration_seconds",
+		"Time spent in DuckDB queries, by query.", metrics.DefBuckets, "query")
+	cacheLookups = registry.Counter("gcc_cache_lookups_total",
+		"Cache lookups by cache and result (hit or miss).", "cache", "result")
+)
+
+// cacheResult labels a cache lookup for cacheLookups.
+func cacheResult(hit bool) string {
+	if hit {
+		return "hit"
+	}
+	return "miss"
+}
+
+// instrument counts and times every request by the route it matched.
+func instrument(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		h.ServeHTTP(sw, r)
+
+		route := r.Pattern
+		if route == "" {
+			route = "unmatched"
+		}
+		httpRequests.Inc(route, r.Method, strconv.Itoa(sw.status))
+		httpDuration.Since(start, route)
+	})
+}
+
+// handleMetrics serves the registry in the Prometheus text format.
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if err := registry.WriteText(w); err != nil {
+		log.Printf("error writing metrics: %v", err)
+	}
+}
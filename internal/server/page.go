@@ -0,0 +1,83 @@
+package server
+
+import (
+	"bytes"
+	"database/sql"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/ThomasAdam/guardian-cc/internal/charts"
+	gccdb "github.com/ThomasAdam/guardian-cc/internal/db"
+)
+
+// page is the analysis page as rendered by the server itself, with the
+// tables wired to /api/dt.  It is re-rendered when the DB's generation
+// changes, i.e. after crosswords have been imported.
+type page struct {
+	db    *sql.DB
+	tmpls *charts.Templates
+
+	mu         sync.Mutex
+	generation int64
+	html       []byte
+	rendered   time.Time
+	etag       string
+}
+
+func newPage(db *sql.DB, tmpls *charts.Templates) (*page, error) {
+	st, err := tmpls.ForServer()
+	if err != nil {
+		return nil, err
+	}
+	return &page{db: db, tmpls: st, generation: -1}, nil
+}
+
+// get returns the page, when it was rendered and its ETag, rendering it
+// first if the DB has changed since it was last rendered.
+func (p *page) get() ([]byte, time.Time, string, error) {
+	gen, err := gccdb.Generation(p.db)
+	if err != nil {
+		return nil, time.Time{}, "", err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.html == nil || gen != p.generation {
+		log.Printf("Rendering page (generation %d)", gen)
+		start := time.Now()
+		html, err := charts.RenderPage(p.db, p.tmpls, charts.Options{})
+		queryDuration.Since(start, "page_render")
+		if err != nil {
+			return nil, time.Time{}, "", err
+		}
+		p.html, p.generation, p.rendered = html, gen, time.Now()
+		p.etag = contentETag(html)
+	}
+	return p.html, p.rendered, p.etag, nil
+}
+
+func (p *page) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	html, rendered, etag, err := p.get()
+	if err != nil {
+		log.Printf("error rendering page: %v", err)
+		http.Error(w, "render error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", pageCacheControl)
+	w.Header().Set("ETag", etag)
+	http.ServeContent(w, r, "gcc-analysis.html", rendered, bytes.NewReader(html))
+}
+
+// serveCSS serves the stylesheet that goes with the page.
+func serveCSS(tmpls *charts.Templates) http.Handler {
+	etag := contentETag(tmpls.CSS())
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/css; charset=utf-8")
+		w.Header().Set("Cache-Control", dataCacheControl)
+		w.Header().Set("ETag", etag)
+		http.ServeContent(w, r, "gcc.css", serverStart, bytes.NewReader(tmpls.CSS()))
+	})
+}
@@ -0,0 +1,383 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ThomasAdam/guardian-cc/api"
+	"github.com/ThomasAdam/guardian-cc/ui"
+	"github.com/marcboeker/go-duckdb"
+)
+
+// Limits on ad-hoc queries (see handleQuery).  A query may ask for fewer
+// rows with the limit parameter.
+const (
+	queryTimeout = 30 * time.Second
+	maxQueryRows = 10000
+)
+
+// queryTables are the tables and views ad-hoc queries may read.  The
+// bookkeeping in meta is left out.
+var queryTables = []string{
+	"answers",
+	"clue_docs",
+	"clue_terms",
+	"crosswords",
+	"entries",
+	"resolved_entries",
+}
+
+// handleQuery runs an ad-hoc query, the q parameter (in the URL or a
+// POSTed form), and returns its first rows as JSON or, with format=csv,
+// CSV.  Only a single SELECT reading queryTables and calling funcs is
+// accepted (see checkQuery); it is stopped after queryTimeout, with a
+// 503 so that the client may try again later, and at most limit rows
+// (maxQueryRows by default) are returned.
+func handleQuery(db *sql.DB, funcs map[string]bool, w http.ResponseWriter, r *http.Request) {
+	fail := func(status int, format string, args ...any) {
+		writeJSON(w, status, api.Error{Error: fmt.Sprintf(format, args...)})
+	}
+
+	q := strings.TrimSpace(r.FormValue("q"))
+	format := r.FormValue("format")
+	if format != "" && format != "json" && format != "csv" {
+		fail(http.StatusBadRequest, "unknown format %q (want json or csv)", format)
+		return
+	}
+	limit := maxQueryRows
+	if v := r.FormValue("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxQueryRows {
+			fail(http.StatusBadRequest, "limit must be between 1 and %d", maxQueryRows)
+			return
+		}
+		limit = n
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), queryTimeout)
+	defer cancel()
+	start := time.Now()
+	defer queryDuration.Since(start, "adhoc")
+	queryFailed := func(err error) {
+		if errors.Is(err, context.DeadlineExceeded) || errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			w.Header().Set("Retry-After", retryAfter)
+			fail(http.StatusServiceUnavailable, "query took longer than %s", queryTimeout)
+			return
+		}
+		fail(http.StatusBadRequest, "%v", err)
+	}
+
+	if err := checkQuery(ctx, db, funcs, q); err != nil {
+		queryFailed(err)
+		return
+	}
+	log.Printf("ad-hoc query from %s: %s", who(r), strings.Join(strings.Fields(q), " "))
+
+	res, err := runQuery(ctx, db, q, limit)
+	if err != nil {
+		queryFailed(err)
+		return
+	}
+
+	if format != "csv" {
+		writeJSON(w, http.StatusOK, res)
+		return
+	}
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="query.csv"`)
+	if res.Truncated {
+		w.Header().Set("X-Truncated", "true")
+	}
+	cw := csv.NewWriter(w)
+	header := make([]string, len(res.Columns))
+	for i, c := range res.Columns {
+		header[i] = c.Name
+	}
+	cw.Write(header)
+	record := make([]string, len(res.Columns))
+	for _, row := range res.Rows {
+		for i, v := range row {
+			record[i] = cellText(v)
+		}
+		cw.Write(record)
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		log.Printf("error writing query results: %v", err)
+	}
+}
+
+// runQuery runs q and returns up to limit of its rows.
+func runQuery(ctx context.Context, db *sql.DB, q string, limit int) (*api.QueryResult, error) {
+	rows, err := db.QueryContext(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	types, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, err
+	}
+	res := &api.QueryResult{Columns: make([]api.QueryColumn, len(types)), Rows: make([][]any, 0)}
+	for i, t := range types {
+		res.Columns[i] = api.QueryColumn{Name: t.Name(), Type: t.DatabaseTypeName()}
+	}
+	for rows.Next() {
+		if len(res.Rows) == limit {
+			res.Truncated = true
+			break
+		}
+		row := make([]any, len(types))
+		ptrs := make([]any, len(types))
+		for i := range row {
+			ptrs[i] = &row[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			return nil, err
+		}
+		for i, v := range row {
+			if b, ok := v.([]byte); ok && len(b) == 16 && res.Columns[i].Type == "UUID" {
+				v = fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+			}
+			row[i] = jsonValue(v)
+		}
+		res.Rows = append(res.Rows, row)
+	}
+	return res, rows.Err()
+}
+
+// jsonValue returns a value scanned from a query result as it should be
+// encoded: decimals as numbers, dates, times and anything JSON cannot
+// hold (such as NaN) as text, and everything else as it is.
+func jsonValue(v any) any {
+	switch v := v.(type) {
+	case nil, string, bool, int8, int16, int32, int64, uint8, uint16, uint32, uint64:
+		return v
+	case time.Time, []byte:
+		return cellText(v)
+	case duckdb.Decimal:
+		return v.Float64()
+	}
+	if _, err := json.Marshal(v); err != nil {
+		return fmt.Sprint(v)
+	}
+	return v
+}
+
+// cellText formats a value for CSV.  Dates have no time of day.
+func cellText(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case duckdb.Decimal:
+		return v.String()
+	case time.Time:
+		if v.Equal(v.Truncate(24 * time.Hour)) {
+			return v.Format(time.DateOnly)
+		}
+		return v.Format(time.RFC3339Nano)
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return strings.Trim(string(b), `"`)
+}
+
+// checkQuery returns an error unless q is a single SELECT that reads
+// nothing but queryTables and its own common table expressions, and
+// calls nothing but funcs (see queryFunctions).  q is parsed by DuckDB
+// itself, which only serializes SELECTs, and every table and function
+// it refers to is checked.  Table functions (read_csv and the like) are
+// refused, as are file paths used as tables.
+func checkQuery(ctx context.Context, db *sql.DB, funcs map[string]bool, q string) error {
+	if q == "" {
+		return errors.New("empty query")
+	}
+	var tree string
+	err := db.QueryRowContext(ctx, "SELECT CAST(json_serialize_sql(CAST(? AS VARCHAR)) AS VARCHAR)", q).Scan(&tree)
+	if err != nil {
+		return fmt.Errorf("parsing query: %w", err)
+	}
+	var parsed struct {
+		Error        bool   `json:"error"`
+		ErrorMessage string `json:"error_message"`
+		Statements   []any  `json:"statements"`
+	}
+	if err := json.Unmarshal([]byte(tree), &parsed); err != nil {
+		return fmt.Errorf("parsing query: %w", err)
+	}
+	switch {
+	case parsed.Error && strings.HasPrefix(parsed.ErrorMessage, "Only SELECT"):
+		return errors.New("only SELECT statements may be run")
+	case parsed.Error:
+		return errors.New(parsed.ErrorMessage)
+	case len(parsed.Statements) != 1:
+		return errors.New("exactly one statement may be run")
+	}
+	return checkNode(parsed.Statements[0], nil, funcs)
+}
+
+// checkNode walks a parse tree from json_serialize_sql, checking the
+// tables it reads and the functions it calls.  ctes holds the names of
+// the common table expressions in scope, which may be read as well.
+func checkNode(node any, ctes, funcs map[string]bool) error {
+	switch n := node.(type) {
+	case []any:
+		for _, c := range n {
+			if err := checkNode(c, ctes, funcs); err != nil {
+				return err
+			}
+		}
+	case map[string]any:
+		// A query's CTEs are visible to it and to the CTEs after them,
+		// and a recursive CTE to itself.
+		if cm, ok := n["cte_map"].(map[string]any); ok {
+			scope := make(map[string]bool, len(ctes))
+			for k := range ctes {
+				scope[k] = true
+			}
+			entries, _ := cm["map"].([]any)
+			for _, e := range entries {
+				e, _ := e.(map[string]any)
+				name, _ := e["key"].(string)
+				if err := checkNode(e["value"], scope, funcs); err != nil {
+					return err
+				}
+				scope[strings.ToLower(name)] = true
+			}
+			ctes = scope
+		}
+		if typ, _ := n["type"].(string); typ == "RECURSIVE_CTE_NODE" {
+			if name, ok := n["cte_name"].(string); ok {
+				scope := map[string]bool{strings.ToLower(name): true}
+				for k := range ctes {
+					scope[k] = true
+				}
+				ctes = scope
+			}
+		}
+		switch n["type"] {
+		case "BASE_TABLE":
+			name, _ := n["table_name"].(string)
+			schema, _ := n["schema_name"].(string)
+			catalog, _ := n["catalog_name"].(string)
+			if err := checkTable(name, schema, catalog, ctes); err != nil {
+				return err
+			}
+		case "TABLE_FUNCTION":
+			return errors.New("table functions may not be used")
+		}
+		if n["class"] == "FUNCTION" {
+			name, _ := n["function_name"].(string)
+			schema, _ := n["schema"].(string)
+			catalog, _ := n["catalog"].(string)
+			if (schema != "" && !strings.EqualFold(schema, "main")) || catalog != "" || !funcs[strings.ToLower(name)] {
+				return fmt.Errorf("function %q may not be used", name)
+			}
+		}
+		for k, v := range n {
+			if k == "cte_map" {
+				continue
+			}
+			if err := checkNode(v, ctes, funcs); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// checkTable checks one table a query reads.
+func checkTable(name, schema, catalog string, ctes map[string]bool) error {
+	lower := strings.ToLower(name)
+	if schema == "" && catalog == "" && ctes[lower] {
+		return nil
+	}
+	if (schema == "" || strings.EqualFold(schema, "main")) && catalog == "" && slices.Contains(queryTables, lower) {
+		return nil
+	}
+	return fmt.Errorf("table %q may not be queried (use %s)", name, strings.Join(queryTables, ", "))
+}
+
+// queryFunctionsExcluded are the deterministic built-in functions that
+// nonetheless tell a query about the server rather than the data.
+var queryFunctionsExcluded = []string{"current_query", "current_setting", "getvariable", "version"}
+
+// queryFunctionsExtra are the functions allowed although they are not
+// deterministic: the date and time, as of the start of the query.
+var queryFunctionsExtra = []string{
+	"current_date", "get_current_time", "get_current_timestamp", "now", "today", "transaction_timestamp",
+}
+
+// queryFunctions returns the names of the functions ad-hoc queries may
+// call: DuckDB's built-in scalar and aggregate functions and macros whose
+// result depends only on their arguments and that change nothing, less
+// queryFunctionsExcluded, plus queryFunctionsExtra.  Operators such as +
+// and LIKE are functions too.  This leaves out, for instance, nextval
+// and setseed, which change the database's state.
+func queryFunctions(db *sql.DB) (map[string]bool, error) {
+	rows, err := db.Query(`SELECT DISTINCT lower(function_name) FROM duckdb_functions()
+		WHERE internal AND schema_name = 'main'
+		  AND function_type IN ('scalar', 'aggregate', 'macro')
+		  AND COALESCE(stability, 'CONSISTENT') = 'CONSISTENT'
+		  AND NOT COALESCE(has_side_effects, false)`)
+	if err != nil {
+		return nil, fmt.Errorf("listing functions: %w", err)
+	}
+	defer rows.Close()
+	funcs := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("listing functions: %w", err)
+		}
+		funcs[name] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("listing functions: %w", err)
+	}
+	for _, name := range queryFunctionsExcluded {
+		delete(funcs, name)
+	}
+	for _, name := range queryFunctionsExtra {
+		funcs[name] = true
+	}
+	return funcs, nil
+}
+
+// queryConsole serves the HTML console for /api/query.  It holds no data,
+// so it needs no token; the queries it sends need an analyst's.
+func queryConsole() http.Handler {
+	tmpl := template.Must(template.ParseFS(ui.FS, "query.html"))
+	var b strings.Builder
+	err := tmpl.Execute(&b, map[string]any{
+		"Tables":  queryTables,
+		"MaxRows": maxQueryRows,
+		"Timeout": queryTimeout,
+	})
+	if err != nil {
+		panic(err)
+	}
+	page := b.String()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Cache-Control", pageCacheControl)
+		w.Write([]byte(page))
+	})
+}
@@ -0,0 +1,107 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	gccdb "github.com/ThomasAdam/guardian-cc/internal/db"
+)
+
+// testDB returns an empty DB with the schema, closed when t ends.
+func testDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := gccdb.Open(filepath.Join(t.TempDir(), "test.duckdb"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := gccdb.CreateSchema(db); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestCheckQuery(t *testing.T) {
+	db := testDB(t)
+	funcs, err := queryFunctions(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	for _, q := range []string{
+		"SELECT creator_name, COUNT(*) FROM crosswords GROUP BY ALL ORDER BY 2 DESC",
+		"select * from Main.Entries where clue ilike '%river%'",
+		"WITH a AS (SELECT id FROM crosswords), b AS (SELECT * FROM a) SELECT * FROM b",
+		"WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n WHERE i < 5) SELECT * FROM n",
+		"SELECT id FROM crosswords WHERE id IN (SELECT crossword_id FROM entries)",
+		"SELECT row_number() OVER (ORDER BY date), lower(name), date_part('year', date) FROM crosswords",
+		"SELECT list_transform([1, 2], x -> x + 1), count(*) FILTER (WHERE 1 > 0), now(), current_date",
+		"SELECT answer, signature FROM answers WHERE answer LIKE 'A%' AND length(answer) = 5",
+	} {
+		if err := checkQuery(ctx, db, funcs, q); err != nil {
+			t.Errorf("checkQuery(%q) = %v, want nil", q, err)
+		}
+	}
+
+	for _, tt := range []struct {
+		q, want string
+	}{
+		{"", "empty query"},
+		{"DELETE FROM crosswords", "only SELECT"},
+		{"SELECT 1; SELECT 2", "exactly one statement"},
+		{"SELECT * FROM meta", `table "meta" may not be queried`},
+		{"SELECT * FROM jobs", `table "jobs" may not be queried`},
+		{"SELECT * FROM main.meta", `table "meta" may not be queried`},
+		{"WITH x AS (SELECT * FROM meta) SELECT * FROM x", `table "meta" may not be queried`},
+		{"WITH crosswords AS (SELECT 1) SELECT * FROM meta", `table "meta" may not be queried`},
+		{"SELECT * FROM (SELECT * FROM meta)", `table "meta" may not be queried`},
+		{"SELECT * FROM crosswords WHERE id IN (SELECT key FROM meta)", `table "meta" may not be queried`},
+		{"SELECT * FROM read_csv('/etc/passwd')", "table functions"},
+		{"SELECT * FROM '/etc/passwd'", "may not be queried"},
+		{"SELECT * FROM other.crosswords", "may not be queried"},
+		{"SELECT nextval('job_ids')", `function "nextval" may not be used`},
+		{"SELECT currval('job_ids')", `function "currval" may not be used`},
+		{"SELECT current_setting('threads')", `function "current_setting" may not be used`},
+		{"SELECT pg_catalog.current_setting('threads')", `function "current_setting" may not be used`},
+		{"SELECT setseed(0.5)", `function "setseed" may not be used`},
+		{"SELECT id FROM crosswords WHERE id = (SELECT nextval('job_ids'))", `function "nextval" may not be used`},
+		{"SELECT list_transform([1], x -> nextval('job_ids'))", `function "nextval" may not be used`},
+	} {
+		err := checkQuery(ctx, db, funcs, tt.q)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("checkQuery(%q) = %v, want an error containing %q", tt.q, err, tt.want)
+		}
+	}
+}
+
+func TestQueryTimeout(t *testing.T) {
+	db := testDB(t)
+	funcs, err := queryFunctions(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := "/api/query?q=" + url.QueryEscape("SELECT COUNT(*) FROM crosswords")
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+	r := httptest.NewRequest("GET", q, nil).WithContext(ctx)
+	w := httptest.NewRecorder()
+	handleQuery(db, funcs, w, r)
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") != retryAfter {
+		t.Errorf("timed out query = %d, Retry-After %q; want 503, %s", w.Code, w.Header().Get("Retry-After"), retryAfter)
+	}
+
+	r = httptest.NewRequest("GET", q, nil)
+	w = httptest.NewRecorder()
+	handleQuery(db, funcs, w, r)
+	if w.Code != http.StatusOK {
+		t.Errorf("query = %d %q, want 200", w.Code, w.Body)
+	}
+}
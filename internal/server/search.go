@@ -0,0 +1,136 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ThomasAdam/guardian-cc/internal/search"
+)
+
+// defaultExamples is how many example clues the search endpoints return
+// per result unless asked for another number.
+const defaultExamples = 3
+
+// parseExamples reads the examples parameter.
+func parseExamples(r *http.Request) (int, error) {
+	v := r.URL.Query().Get("examples")
+	if v == "" {
+		return defaultExamples, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 || n > search.MaxExamples {
+		return 0, badRequest("examples must be between 0 and %d", search.MaxExamples)
+	}
+	return n, nil
+}
+
+// searchAnswers serves /api/search/answers?pattern=?A?B??E&enum=3,4: the
+// answers fitting a pattern, most used first, with example clues.
+func searchAnswers(ctx context.Context, db *sql.DB, r *http.Request) (any, error) {
+	q := r.URL.Query()
+	p, err := search.ParsePattern(q.Get("pattern"), q.Get("enum"))
+	if err != nil {
+		return nil, badRequest("%v", err)
+	}
+	examples, err := parseExamples(r)
+	if err != nil {
+		return nil, err
+	}
+	pg, err := parsePage(q)
+	if err != nil {
+		return nil, err
+	}
+	matches, total, err := search.Answers(ctx, db, p, examples, pg.Offset, pg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	pg.Total = total
+	return pageOf(r, matches, pg), nil
+}
+
+// searchAnagrams serves /api/search/anagrams?letters=...: the answers that
+// are anagrams of the letters, optionally fitting a pattern, ranked as
+// searchAnswers ranks them.
+func searchAnagrams(ctx context.Context, db *sql.DB, r *http.Request) (any, error) {
+	q := r.URL.Query()
+	p, err := search.AnagramPattern(q.Get("pattern"), q.Get("enum"))
+	if err != nil {
+		return nil, badRequest("%v", err)
+	}
+	examples, err := parseExamples(r)
+	if err != nil {
+		return nil, err
+	}
+	pg, err := parsePage(q)
+	if err != nil {
+		return nil, err
+	}
+	matches, total, err := search.Anagrams(ctx, db, q.Get("letters"), p, examples, pg.Offset, pg.Limit)
+	if err := searchError(err); err != nil {
+		return nil, err
+	}
+	pg.Total = total
+	return pageOf(r, matches, pg), nil
+}
+
+// searchClues serves /api/search/clues?q=...: clues containing the query's
+// words and phrases, optionally narrowed by setter, type, date range and
+// answer length.
+func searchClues(ctx context.Context, db *sql.DB, r *http.Request) (any, error) {
+	q := r.URL.Query()
+	cq := search.ClueQuery{
+		Query:  q.Get("q"),
+		Setter: q.Get("setter"),
+		Type:   q.Get("type"),
+		Sort:   q.Get("sort"),
+	}
+	for _, f := range []struct {
+		param string
+		dst   *time.Time
+	}{{"from", &cq.From}, {"to", &cq.To}} {
+		v := q.Get(f.param)
+		if v == "" {
+			continue
+		}
+		d, err := time.Parse(time.DateOnly, v)
+		if err != nil {
+			return nil, badRequest("invalid %s date %q (want YYYY-MM-DD)", f.param, v)
+		}
+		*f.dst = d
+	}
+	if v := q.Get("length"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return nil, badRequest("invalid length %q", v)
+		}
+		cq.Length = n
+	}
+	pg, err := parsePage(q)
+	if err != nil {
+		return nil, err
+	}
+
+	hits, total, err := search.Clues(ctx, db, cq, pg.Offset, pg.Limit)
+	if err := searchError(err); err != nil {
+		return nil, err
+	}
+	pg.Total = total
+	return pageOf(r, hits, pg), nil
+}
+
+// searchError maps the errors of the index-backed searches to responses:
+// a bad query is a 400 and an index that needs rebuilding a 503.
+func searchError(err error) error {
+	var qe *search.QueryError
+	switch {
+	case errors.As(err, &qe):
+		return badRequest("%v", err)
+	case errors.Is(err, search.ErrStaleIndex):
+		return &apiError{http.StatusServiceUnavailable, err.Error()}
+	}
+	return err
+}
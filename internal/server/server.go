@@ -1,285 +1,243 @@
 package server
 
 import (
+	"bytes"
+	"context"
 	"database/sql"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"log/slog"
 	"net/http"
-	"strconv"
-	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/ThomasAdam/guardian-cc/api"
+	"github.com/ThomasAdam/guardian-cc/internal/auth"
+	"github.com/ThomasAdam/guardian-cc/internal/charts"
+	"github.com/ThomasAdam/guardian-cc/internal/jobs"
+	"github.com/ThomasAdam/guardian-cc/internal/metrics"
 )
 
-// Serve starts an HTTP server on the given address.
-// It serves static files from the current directory and handles
-// DataTables server-side processing requests at /api/dt.
-func Serve(db *sql.DB, addr string) error {
+// Serve serves the analysis page, the data behind it and the JSON API on
+// addr until ctx is cancelled, then shuts down gracefully, finishing any
+// job that is running.
+func Serve(ctx context.Context, db *sql.DB, tmpls *charts.Templates, addr string, opts Options) error {
 	mux := http.NewServeMux()
+	var draining atomic.Bool
 
-	// DataTables server-side processing endpoint
-	mux.HandleFunc("/api/dt", func(w http.ResponseWriter, r *http.Request) {
-		handleDataTable(db, w, r)
-	})
+	// Liveness and readiness, for process supervisors and load balancers
+	mux.HandleFunc("GET /healthz", handleHealthz)
+	mux.HandleFunc("GET /readyz", handleReadyz(db, &draining))
 
-	// Static files (gcc-analysis.html, ds_ajax*.txt, ui/, etc.)
-	mux.Handle("/", http.FileServer(http.Dir(".")))
+	// Prometheus metrics
+	metrics.RegisterDB(registry, db)
+	mux.Handle("GET /metrics", requireReaderIfPrivate(opts.Tokens, http.HandlerFunc(handleMetrics)))
 
-	log.Printf("Listening on %s", addr)
-	return http.ListenAndServe(addr, mux)
-}
+	// The page, rendered up front so that a broken chart stops startup.
+	pg, err := newPage(db, tmpls)
+	if err != nil {
+		return err
+	}
+	if _, _, _, err := pg.get(); err != nil {
+		return err
+	}
+	mux.Handle("GET /{$}", pg)
+	mux.Handle("GET /gcc-analysis.html", pg)
+	mux.Handle("GET /gcc.css", serveCSS(tmpls))
+
+	// DataTables server-side processing for the page's tables
+	dt := newDTCache()
+	mux.Handle("/api/dt", requireReaderIfPrivate(opts.Tokens, withGeneration(db, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handleDataTable(db, dt, w, r)
+	}))))
+
+	// Per-chart data downloads, e.g. /api/charts/5a/data?format=json
+	mux.Handle("GET /api/charts/{order}/data", requireReaderIfPrivate(opts.Tokens, withGeneration(db, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handleChartData(db, w, r)
+	}))))
+
+	// JSON API: setters, crosswords and answers
+	registerAPI(mux, db, opts.Tokens)
+
+	// Ad-hoc read-only queries for analysts; crossword uploads and
+	// background import, refresh and render jobs, run one at a time and
+	// never alongside an upload, for admins
+	var runnerDone chan struct{}
+	if opts.Tokens != nil {
+		funcs, err := queryFunctions(db)
+		if err != nil {
+			return err
+		}
+		query := requireRole(opts.Tokens, auth.Analyst, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			handleQuery(db, funcs, w, r)
+		}))
+		mux.Handle("GET /api/query", query)
+		mux.Handle("POST /api/query", query)
+		mux.Handle("GET /query", queryConsole())
+		mux.Handle("POST /api/admin/crosswords", requireRole(opts.Tokens, auth.Admin, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			handleUpload(db, w, r)
+		})))
+
+		runner, err := jobs.New(db, jobTasks(db, tmpls), &importMu)
+		if err != nil {
+			return err
+		}
+		runnerDone = make(chan struct{})
+		go func() {
+			runner.Run(ctx)
+			close(runnerDone)
+		}()
+		registerJobs(ctx, mux, runner, func(h http.Handler) http.Handler {
+			return requireRole(opts.Tokens, auth.Admin, h)
+		})
+	}
 
-// tableConfig defines the SQL and column mapping for each DataTable.
-type tableConfig struct {
-	// baseQuery is the FROM + JOIN + WHERE portion (no SELECT, no ORDER/LIMIT).
-	baseQuery string
-	// columns maps DataTables column index to SQL expression.
-	columns []columnDef
-}
+	// OpenAPI description of everything under /api
+	spec, err := api.OpenAPI()
+	if err != nil {
+		return err
+	}
+	specETag := contentETag(spec)
+	mux.HandleFunc("GET /api/openapi.json", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("ETag", specETag)
+		w.Header().Set("Cache-Control", pageCacheControl)
+		http.ServeContent(w, r, "openapi.json", serverStart, bytes.NewReader(spec))
+	})
+
+	// The published output of render (ds_ajax*.txt, chartN.csv, svg/,
+	// ...) in the current directory; nothing else in it is reachable.
+	mux.Handle("/", staticHandler("."))
 
-type columnDef struct {
-	// sql is the SQL expression for SELECT and ORDER BY.
-	sql string
-	// searchable indicates whether this column supports text search.
-	searchable bool
+	h := instrument(mux)
+	if opts.AccessLog != nil {
+		h = accessLog(opts.AccessLog, h)
+	}
+	err = run(ctx, addr, h, &draining)
+	if runnerDone != nil {
+		// Let a running job finish with the DB.
+		<-runnerDone
+	}
+	return err
 }
 
-var tables = map[string]tableConfig{
-	"chart5": {
-		baseQuery: `FROM (
-			SELECT DISTINCT e.crossword_id, e.solution, e.clue,
-			       c.creator_name, c.crossword_type,
-			       c.id AS cw_path, c.number AS cw_number
-			FROM resolved_entries e
-			JOIN crosswords c ON e.crossword_id = c.id
-			WHERE e.clue != ''
-			  AND e.clue NOT SIMILAR TO '\s+\(\d+\)'
-			  AND e.clue NOT SIMILAR TO 'See\s+\d+.*'
-			  AND e.clue NOT SIMILAR TO 'See\s+(clues|special)\s+.*'
-			  AND e.clue NOT SIMILAR TO 'Follow\s+the\s+link\s+below\s+to\s+see\s+today''s\s+clues.*'
-			) d
-			GROUP BY d.creator_name, d.solution
-			HAVING COUNT(DISTINCT d.crossword_id) > 1`,
-		columns: []columnDef{
-			{sql: "d.creator_name", searchable: true},
-			{sql: "d.solution", searchable: true},
-			{sql: "STRING_AGG(d.clue, '<br />')", searchable: true},
-			{sql: "STRING_AGG(d.crossword_type, '<br />')", searchable: false},
-			{sql: "STRING_AGG('<a href=\"https://www.theguardian.com/' || d.cw_path || '\">' || d.cw_number || '</a>', '<br />')", searchable: false},
-		},
-	},
-	"chart5a": {
-		baseQuery: `FROM (
-			SELECT DISTINCT e.crossword_id, e.clue, c.creator_name,
-			       c.crossword_type, c.id AS cw_path, c.number AS cw_number
-			FROM resolved_entries e
-			JOIN crosswords c ON e.crossword_id = c.id
-			WHERE e.clue != ''
-			  AND e.clue NOT SIMILAR TO '\s+\(\d+\)'
-			  AND e.clue NOT SIMILAR TO 'See\s+\d+.*'
-			  AND e.clue NOT SIMILAR TO 'See\s+(clues|special)\s+.*'
-			  AND e.clue NOT SIMILAR TO 'Follow\s+the\s+link\s+below\s+to\s+see\s+today''s\s+clues.*'
-			) d
-			GROUP BY d.creator_name, d.clue
-			HAVING COUNT(DISTINCT d.crossword_id) > 1`,
-		columns: []columnDef{
-			{sql: "d.creator_name", searchable: true},
-			{sql: "STRING_AGG(d.clue, '<br />')", searchable: true},
-			{sql: "STRING_AGG(d.crossword_type, '<br />')", searchable: false},
-			{sql: "STRING_AGG('<a href=\"https://www.theguardian.com/' || d.cw_path || '\">' || d.cw_number || '</a>', '<br />')", searchable: false},
-		},
-	},
-	"chart6": {
-		baseQuery: `FROM crosswords
-			WHERE pdf IS NOT NULL`,
-		columns: []columnDef{
-			{sql: "creator_name", searchable: true},
-			{sql: "'<a href=\"' || pdf || '\">' || number || '</a>'", searchable: true},
-			{sql: "CAST(date AS VARCHAR)", searchable: true},
-		},
-	},
+// Options configures Serve.
+type Options struct {
+	// AccessLog, if set, receives a record for every request.
+	AccessLog *slog.Logger
+	// Tokens, if set, holds the bearer tokens that authorize /api/query
+	// (analysts) and /api/admin/ (admins), and may make the JSON API
+	// need one too (readers).  Without it, neither is served.
+	Tokens *auth.File
 }
 
-// dtResponse is the DataTables server-side processing response format.
-type dtResponse struct {
-	Draw            int        `json:"draw"`
-	RecordsTotal    int        `json:"recordsTotal"`
-	RecordsFiltered int        `json:"recordsFiltered"`
-	Data            [][]string `json:"data"`
+// dtQueryTimeout bounds the queries behind a single /api/dt request.
+const dtQueryTimeout = 10 * time.Second
+
+// retryAfter is the Retry-After, in seconds, sent with a 503 when the
+// queries behind an /api/dt or /api/query request take too long, which
+// they mostly do while a render job is keeping DuckDB busy.
+const retryAfter = "10"
+
+// dtQueryError reports a failed query behind an /api/dt request: a 503
+// if it ran out of time, so that the client tries again later, and
+// otherwise a 500.
+func dtQueryError(ctx context.Context, w http.ResponseWriter, what string, err error) {
+	log.Printf("error %s: %v", what, err)
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		w.Header().Set("Retry-After", retryAfter)
+		http.Error(w, "the database is busy; try again later", http.StatusServiceUnavailable)
+		return
+	}
+	http.Error(w, "query error", http.StatusInternalServerError)
 }
 
-func handleDataTable(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+// handleDataTable pages, sorts and searches one of the chart plugins'
+// table datasets (see charts.TableDataset) for a server-side DataTable.
+// Counts and pages are kept in cache until the next import.  With a
+// format parameter every matching row is exported instead (see
+// handleDTExport).
+func handleDataTable(db *sql.DB, cache *dtCache, w http.ResponseWriter, r *http.Request) {
 	q := r.URL.Query()
 
-	tableName := q.Get("table")
-	tc, ok := tables[tableName]
-	if !ok {
+	ds := charts.FindTable(q.Get("table"))
+	if ds == nil {
 		http.Error(w, "unknown table", http.StatusBadRequest)
 		return
 	}
+	dtRequests.Inc(ds.Name)
+	defer dtDuration.Since(time.Now(), ds.Name)
 
-	draw, _ := strconv.Atoi(q.Get("draw"))
-	start, _ := strconv.Atoi(q.Get("start"))
-	length, _ := strconv.Atoi(q.Get("length"))
-	if length <= 0 {
-		length = 10
+	req, err := parseDTRequest(q, ds)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
-	searchValue := q.Get("search[value]")
-
-	// Build SELECT clause
-	selectCols := make([]string, len(tc.columns))
-	for i, col := range tc.columns {
-		selectCols[i] = col.sql
+	where, args := req.where(ds)
+	orderBy := req.orderBy(ds)
+	if format := q.Get("format"); format != "" {
+		handleDTExport(db, ds, format, where, args, orderBy, w, r)
+		return
 	}
-	selectClause := strings.Join(selectCols, ", ")
 
-	// Build search WHERE clause (applied as a HAVING or wrapping subquery)
-	var searchFilter string
-	if searchValue != "" {
-		escaped := strings.ReplaceAll(searchValue, "'", "''")
-		var conditions []string
-		for _, col := range tc.columns {
-			if col.searchable {
-				conditions = append(conditions,
-					fmt.Sprintf("CAST(%s AS VARCHAR) ILIKE '%%%s%%'", col.sql, escaped))
-			}
-		}
-		if len(conditions) > 0 {
-			searchFilter = "(" + strings.Join(conditions, " OR ") + ")"
-		}
+	gen := requestGeneration(r.Context())
+	cache.at(gen)
+	filterKey := cacheKey(gen, ds.Name, where, args)
+	pageKey := cacheKey(filterKey, orderBy, req.length, req.start)
+	cached, ok := cache.pages.get(pageKey)
+	cacheLookups.Inc("dt_pages", cacheResult(ok))
+	if ok {
+		resp := *cached
+		resp.Draw = req.draw
+		writeJSON(w, http.StatusOK, resp)
+		return
 	}
 
-	// Build ORDER BY
-	orderClause := ""
-	orderCol := q.Get("order[0][column]")
-	orderDir := q.Get("order[0][dir]")
-	if orderCol != "" {
-		colIdx, err := strconv.Atoi(orderCol)
-		if err == nil && colIdx >= 0 && colIdx < len(tc.columns) {
-			dir := "ASC"
-			if strings.EqualFold(orderDir, "desc") {
-				dir = "DESC"
-			}
-			orderClause = fmt.Sprintf("ORDER BY %d %s", colIdx+1, dir)
-		}
-	}
+	ctx, cancel := context.WithTimeout(r.Context(), dtQueryTimeout)
+	defer cancel()
 
-	// We wrap the grouped query as a subquery so we can apply search
-	// filtering and pagination on top of it.
-	innerQuery := fmt.Sprintf("SELECT %s %s", selectClause, tc.baseQuery)
+	from := "FROM (" + ds.Query + ") _t"
 
-	// Total count (unfiltered)
-	totalSQL := fmt.Sprintf("SELECT COUNT(*) FROM (%s) _t", innerQuery)
-	var totalCount int
-	if err := db.QueryRow(totalSQL).Scan(&totalCount); err != nil {
-		log.Printf("error counting total: %v", err)
-		http.Error(w, "query error", http.StatusInternalServerError)
+	totalCount, err := cache.count(ctx, db, cacheKey(gen, ds.Name), "SELECT COUNT(*) "+from)
+	if err != nil {
+		dtQueryError(ctx, w, "counting total", err)
 		return
 	}
-
-	// Filtered count + data query
 	filteredCount := totalCount
-	outerWhere := ""
-	if searchFilter != "" {
-		// Build column aliases for the subquery
-		aliases := make([]string, len(tc.columns))
-		for i := range tc.columns {
-			aliases[i] = fmt.Sprintf("col%d", i)
-		}
-		aliasedCols := make([]string, len(tc.columns))
-		for i, col := range tc.columns {
-			aliasedCols[i] = fmt.Sprintf("%s AS %s", col.sql, aliases[i])
-		}
-		aliasedSelect := strings.Join(aliasedCols, ", ")
-		aliasedInner := fmt.Sprintf("SELECT %s %s", aliasedSelect, tc.baseQuery)
-
-		// Build search on aliases
-		var searchConds []string
-		for i, col := range tc.columns {
-			if col.searchable {
-				escaped := strings.ReplaceAll(searchValue, "'", "''")
-				searchConds = append(searchConds,
-					fmt.Sprintf("CAST(%s AS VARCHAR) ILIKE '%%%s%%'", aliases[i], escaped))
-				_ = col
-			}
-		}
-		outerWhere = "WHERE " + strings.Join(searchConds, " OR ")
-
-		// Filtered count
-		filteredSQL := fmt.Sprintf("SELECT COUNT(*) FROM (%s) _t %s", aliasedInner, outerWhere)
-		if err := db.QueryRow(filteredSQL).Scan(&filteredCount); err != nil {
-			log.Printf("error counting filtered: %v", err)
-			http.Error(w, "query error", http.StatusInternalServerError)
-			return
-		}
-
-		// Data query with aliases
-		selectStar := strings.Join(aliases, ", ")
-		dataSQL := fmt.Sprintf("SELECT %s FROM (%s) _t %s %s LIMIT %d OFFSET %d",
-			selectStar, aliasedInner, outerWhere, orderClause, length, start)
-		rows, err := db.Query(dataSQL)
+	if where != "" {
+		filteredCount, err = cache.count(ctx, db, filterKey, "SELECT COUNT(*) "+from+" "+where, args...)
 		if err != nil {
-			log.Printf("error querying data: %v (sql: %s)", err, dataSQL)
-			http.Error(w, "query error", http.StatusInternalServerError)
+			dtQueryError(ctx, w, "counting filtered", err)
 			return
 		}
-		defer rows.Close()
-
-		resp := dtResponse{
-			Draw:            draw,
-			RecordsTotal:    totalCount,
-			RecordsFiltered: filteredCount,
-			Data:            make([][]string, 0),
-		}
-		numCols := len(tc.columns)
-		for rows.Next() {
-			row := make([]string, numCols)
-			ptrs := make([]any, numCols)
-			for i := range row {
-				ptrs[i] = &row[i]
-			}
-			if err := rows.Scan(ptrs...); err != nil {
-				log.Printf("error scanning: %v", err)
-				continue
-			}
-			resp.Data = append(resp.Data, row)
-		}
-
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(resp)
-		return
 	}
 
-	// No search filter -- simpler query
-	dataSQL := fmt.Sprintf("SELECT * FROM (%s) _t %s LIMIT %d OFFSET %d",
-		innerQuery, orderClause, length, start)
-	rows, err := db.Query(dataSQL)
+	dataSQL := "SELECT * " + from + " " + where + " ORDER BY " + orderBy + " LIMIT ? OFFSET ?"
+	queryStart := time.Now()
+	rows, err := db.QueryContext(ctx, dataSQL, append(args, req.length, req.start)...)
 	if err != nil {
-		log.Printf("error querying data: %v (sql: %s)", err, dataSQL)
-		http.Error(w, "query error", http.StatusInternalServerError)
+		dtQueryError(ctx, w, "querying data", fmt.Errorf("%w (sql: %s)", err, dataSQL))
 		return
 	}
 	defer rows.Close()
 
-	resp := dtResponse{
-		Draw:            draw,
+	resp := api.DataTablesResponse{
+		Draw:            req.draw,
 		RecordsTotal:    totalCount,
 		RecordsFiltered: filteredCount,
 		Data:            make([][]string, 0),
 	}
-	numCols := len(tc.columns)
-	for rows.Next() {
-		row := make([]string, numCols)
-		ptrs := make([]any, numCols)
-		for i := range row {
-			ptrs[i] = &row[i]
-		}
-		if err := rows.Scan(ptrs...); err != nil {
-			log.Printf("error scanning: %v", err)
-			continue
-		}
-		resp.Data = append(resp.Data, row)
+	err = charts.ScanTableRows(rows, func(row charts.TableRow) error {
+		resp.Data = append(resp.Data, ds.Cells(row))
+		return nil
+	})
+	if err != nil {
+		dtQueryError(ctx, w, "scanning", err)
+		return
 	}
+	queryDuration.Since(queryStart, "dt_rows")
+	cache.pages.add(pageKey, &resp)
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(resp)
+	writeJSON(w, http.StatusOK, resp)
 }
@@ -0,0 +1,169 @@
+package server
+
+import (
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// published lists the files under the serve directory that may be fetched,
+// as path.Match patterns, with the Cache-Control header each is sent with.
+// Anything else, including guardian.duckdb, the sources, the crossword tree
+// and every directory, is a 404.  The page and stylesheet are served by
+// the server itself and so are not listed.
+var published = []struct {
+	pattern      string
+	cacheControl string
+}{
+	// DataTables ajax data and per-section downloads; refreshed by render.
+	{"ds_ajax*.txt", dataCacheControl},
+	{"chart*.csv", dataCacheControl},
+	{"chart*.json", dataCacheControl},
+	// Output of render --format svg and render --backend vegalite.
+	{"svg/gcc-analysis.html", pageCacheControl},
+	{"svg/gcc.css", dataCacheControl},
+	{"svg/*.svg", dataCacheControl},
+	{"svg/chart*.csv", dataCacheControl},
+	{"svg/chart*.json", dataCacheControl},
+	{"vegalite/*.vl.json", dataCacheControl},
+	{"vegalite/chart*.csv", dataCacheControl},
+	{"vegalite/chart*.json", dataCacheControl},
+}
+
+const (
+	// pageCacheControl makes clients revalidate pages on every visit, so
+	// that a re-render is seen straight away; unchanged pages cost a 304.
+	pageCacheControl = "no-cache"
+	// dataCacheControl lets clients and shared caches reuse data files for
+	// a few minutes, which covers a session of paging through the tables.
+	dataCacheControl = "public, max-age=300"
+)
+
+// precompressed lists the encodings the renderer writes alongside large
+// files, in order of preference, with their file suffixes.
+var precompressed = []struct {
+	encoding string
+	ext      string
+}{
+	{"br", ".br"},
+	{"gzip", ".gz"},
+}
+
+// staticHandler serves the published files under root.  When a file has
+// an up-to-date precompressed sibling (e.g. ds_ajax.txt.br) in an encoding
+// the client accepts, that is sent instead with the matching
+// Content-Encoding.  Directories are never listed.
+func staticHandler(root string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		name := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+		cacheControl, ok := publishedFile(name)
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		file := filepath.Join(root, filepath.FromSlash(name))
+		orig, err := os.Stat(file)
+		if err != nil || !orig.Mode().IsRegular() {
+			http.NotFound(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Accept-Encoding")
+		w.Header().Set("Cache-Control", cacheControl)
+		if servePrecompressed(w, r, file, orig) {
+			return
+		}
+		f, err := os.Open(file)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		defer f.Close()
+		w.Header().Set("ETag", fileETag(orig, ""))
+		http.ServeContent(w, r, name, orig.ModTime(), f)
+	})
+}
+
+// publishedFile reports whether name, a slash-separated path relative to
+// the serve directory, may be served, and with which Cache-Control.
+func publishedFile(name string) (string, bool) {
+	for _, pf := range published {
+		if ok, _ := path.Match(pf.pattern, name); ok {
+			return pf.cacheControl, true
+		}
+	}
+	return "", false
+}
+
+// fileETag returns the ETag of a file served in the given
+// Content-Encoding ("" for none).  Each encoding is a different
+// representation, so it gets a different tag.
+func fileETag(fi os.FileInfo, encoding string) string {
+	tag := strconv.FormatInt(fi.ModTime().UnixNano(), 36) + "-" + strconv.FormatInt(fi.Size(), 36)
+	if encoding != "" {
+		tag += "-" + encoding
+	}
+	return `"` + tag + `"`
+}
+
+func servePrecompressed(w http.ResponseWriter, r *http.Request, file string, orig os.FileInfo) bool {
+	for _, pc := range precompressed {
+		if !acceptsEncoding(r.Header.Get("Accept-Encoding"), pc.encoding) {
+			continue
+		}
+		f, err := os.Open(file + pc.ext)
+		if err != nil {
+			continue
+		}
+		defer f.Close()
+		fi, err := f.Stat()
+		if err != nil || !fi.Mode().IsRegular() || fi.ModTime().Before(orig.ModTime()) {
+			continue
+		}
+
+		ctype := mime.TypeByExtension(path.Ext(file))
+		if ctype == "" {
+			ctype = "application/octet-stream"
+		}
+		w.Header().Set("Content-Type", ctype)
+		w.Header().Set("Content-Encoding", pc.encoding)
+		w.Header().Set("ETag", fileETag(orig, pc.encoding))
+		http.ServeContent(w, r, orig.Name(), orig.ModTime(), f)
+		return true
+	}
+	return false
+}
+
+// acceptsEncoding reports whether an Accept-Encoding header value allows
+// the given encoding with a non-zero q value.  An explicit entry for the
+// encoding takes precedence over "*".
+func acceptsEncoding(header, encoding string) bool {
+	star := false
+	for _, part := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		coding = strings.TrimSpace(coding)
+		q := 1.0
+		for _, p := range strings.Split(params, ";") {
+			k, v, ok := strings.Cut(strings.TrimSpace(p), "=")
+			if ok && strings.EqualFold(strings.TrimSpace(k), "q") {
+				q, _ = strconv.ParseFloat(strings.TrimSpace(v), 64)
+			}
+		}
+		switch {
+		case strings.EqualFold(coding, encoding):
+			return q > 0
+		case coding == "*":
+			star = q > 0
+		}
+	}
+	return star
+}
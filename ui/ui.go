@@ -0,0 +1,12 @@
+// Package ui holds the default page templates and stylesheet, and the
+// query console's and job runner's pages.  They are
+// embedded into the binary so that rendering does not depend on the
+// current working directory.
+package ui
+
+import "embed"
+
+// FS contains chart_defs/*.tmpl, gcc.css, query.html and jobs.html.
+//
+//go:embed chart_defs/*.tmpl gcc.css query.html jobs.html
+var FS embed.FS